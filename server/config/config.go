@@ -0,0 +1,449 @@
+// Package config loads the server configuration from defaults, an optional YAML file, BLOG_* environment
+// variables and command line flags, each one overriding the previous ones.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	serve "github.com/gouthams/blogApp/server/restimpl"
+	"github.com/gouthams/blogApp/server/store"
+	"github.com/gouthams/blogApp/server/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnv names the environment variable of the config file, the -config flag takes precedence over it.
+const ConfigEnv = "BLOG_CONFIG"
+
+const redacted = "REDACTED"
+
+// Config is the effective configuration of the server.
+type Config struct {
+	Server  ServerConfig        `yaml:"server"`
+	Log     utils.LogConfig     `yaml:"log"`
+	Tracing utils.TracingConfig `yaml:"tracing"`
+	Auth    serve.AuthConfig    `yaml:"auth"`
+	Store   store.Config        `yaml:"store"`
+}
+
+// ServerConfig configures the http server and the apis.
+type ServerConfig struct {
+	// ListenAddress is the host:port the server listens on, an empty host listens on every interface.
+	ListenAddress string `yaml:"listenAddress"`
+	// MaxPageSize is the default and maximum pageSize of the search apis.
+	MaxPageSize int64 `yaml:"maxPageSize"`
+	// OnPosts is what deleting a user does to their posts unless the request says otherwise.
+	OnPosts store.OnPosts `yaml:"onPosts"`
+	// TrashRetention is how long deleted users and posts stay in the trash.
+	TrashRetention time.Duration `yaml:"trashRetention"`
+	// JanitorInterval is how often the trash is purged.
+	JanitorInterval time.Duration `yaml:"janitorInterval"`
+	// ShutdownDelay is how long the readiness probe fails before the server stops accepting connections,
+	// so that the load balancers stop routing new requests to it.
+	ShutdownDelay time.Duration `yaml:"shutdownDelay"`
+	// ShutdownTimeout is how long the in-flight requests have to complete once the server stops accepting connections.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			ListenAddress:   ":8080",
+			MaxPageSize:     serve.DefaultMaxPageSize,
+			OnPosts:         store.OnPostsReject,
+			TrashRetention:  30 * 24 * time.Hour,
+			JanitorInterval: time.Hour,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Log: utils.LogConfig{
+			Level:         "info",
+			Format:        utils.LogJson,
+			MaxSizeMB:     100,
+			DebugSampling: 1,
+			Redact:        []string{"email", "password", "token", "authorization"},
+		},
+		Tracing: utils.TracingConfig{
+			Exporter:    utils.TracingNone,
+			Endpoint:    "localhost:4318",
+			SampleRatio: 1,
+		},
+		Auth: serve.DefaultAuthConfig(),
+		Store: store.Config{
+			Type:       "mongo",
+			SqlitePath: "/data/db/blog.sqlite",
+			Mongo:      store.DefaultMongoConfig(),
+		},
+	}
+}
+
+// Helper function to register every setting of the configuration as a flag of the flag set
+func (c *Config) register(fs *flag.FlagSet) {
+	server, mongo := &c.Server, &c.Store.Mongo
+	fs.StringVar(&server.ListenAddress, "listenAddress", server.ListenAddress, "host:port the server listens on")
+	fs.Int64Var(&server.MaxPageSize, "maxPageSize", server.MaxPageSize, "maximum pageSize of the search apis")
+	fs.StringVar((*string)(&server.OnPosts), "onPosts", string(server.OnPosts),
+		"what deleting a user does to their posts unless the request says otherwise: reject, cascade or reassign")
+	fs.DurationVar(&server.TrashRetention, "trashRetention", server.TrashRetention,
+		"how long deleted users and posts stay in the trash")
+	fs.DurationVar(&server.JanitorInterval, "janitorInterval", server.JanitorInterval, "how often the trash is purged")
+	fs.DurationVar(&server.ShutdownDelay, "shutdownDelay", server.ShutdownDelay,
+		"how long the readiness probe fails before the server stops accepting connections on shutdown")
+	fs.DurationVar(&server.ShutdownTimeout, "shutdownTimeout", server.ShutdownTimeout,
+		"how long the in-flight requests have to complete on shutdown")
+	fs.StringVar(&c.Log.Level, "logLevel", c.Log.Level, "minimum level of the logged messages")
+	fs.StringVar(&c.Log.Format, "logFormat", c.Log.Format, "format of the log lines: json, logfmt or text")
+	fs.BoolVar(&c.Log.ReportCaller, "logReportCaller", c.Log.ReportCaller,
+		"add the file and the function logging every line")
+	fs.StringVar(&c.Log.File, "logFile", c.Log.File, "file the log lines are written to, stdout when empty")
+	fs.IntVar(&c.Log.MaxSizeMB, "logMaxSizeMB", c.Log.MaxSizeMB, "size in megabytes the log file is rotated at")
+	fs.IntVar(&c.Log.MaxBackups, "logMaxBackups", c.Log.MaxBackups, "rotated log files kept, 0 keeps them all")
+	fs.IntVar(&c.Log.MaxAgeDays, "logMaxAgeDays", c.Log.MaxAgeDays,
+		"days the rotated log files are kept, 0 keeps them regardless of their age")
+	fs.IntVar(&c.Log.DebugSampling, "logDebugSampling", c.Log.DebugSampling,
+		"keep one debug or trace line in every logDebugSampling")
+	fs.Var((*stringList)(&c.Log.Redact), "logRedact", "comma separated fields whose values are masked in the logs")
+	fs.StringVar(&c.Tracing.Exporter, "tracingExporter", c.Tracing.Exporter,
+		"where the spans are exported: none, stdout or otlp")
+	fs.StringVar(&c.Tracing.Endpoint, "tracingEndpoint", c.Tracing.Endpoint,
+		"host:port of the OTLP/HTTP collector of the otlp exporter")
+	fs.BoolVar(&c.Tracing.Insecure, "tracingInsecure", c.Tracing.Insecure,
+		"export the spans to the collector over http rather than https")
+	fs.Float64Var(&c.Tracing.SampleRatio, "tracingSampleRatio", c.Tracing.SampleRatio,
+		"ratio of the traces started by the server that are sampled")
+	fs.StringVar(&c.Auth.Issuer, "authIssuer", c.Auth.Issuer, "iss claim of the issued and the accepted tokens")
+	fs.DurationVar(&c.Auth.AccessTokenTTL, "authAccessTokenTTL", c.Auth.AccessTokenTTL,
+		"how long an access token authenticates the requests")
+	fs.DurationVar(&c.Auth.RefreshTokenTTL, "authRefreshTokenTTL", c.Auth.RefreshTokenTTL,
+		"how long a refresh token can be exchanged for new tokens")
+	fs.Var((*signingKeys)(&c.Auth.Keys), "authKeys",
+		"comma separated id:secret keys signing the tokens, the first one signs the new tokens")
+	fs.StringVar(&c.Auth.Oidc.Issuer, "authOidcIssuer", c.Auth.Oidc.Issuer,
+		"issuer url of the OpenID Connect identity provider, the oidc login is disabled when empty")
+	fs.StringVar(&c.Auth.Oidc.ClientId, "authOidcClientId", c.Auth.Oidc.ClientId,
+		"client id of the server at the identity provider")
+	fs.StringVar(&c.Auth.Oidc.ClientSecret, "authOidcClientSecret", c.Auth.Oidc.ClientSecret,
+		"client secret of the server at the identity provider")
+	fs.StringVar(&c.Auth.Oidc.RedirectUrl, "authOidcRedirectUrl", c.Auth.Oidc.RedirectUrl,
+		"url of /auth/oidc/callback registered at the identity provider")
+	fs.Var((*stringList)(&c.Auth.Oidc.Scopes), "authOidcScopes",
+		"comma separated scopes requested along with openid")
+	fs.BoolVar(&c.Auth.Oidc.AutoProvision, "authOidcAutoProvision", c.Auth.Oidc.AutoProvision,
+		"create the users logging in with the identity provider for the first time")
+	fs.DurationVar(&c.Auth.Oidc.JwksCacheTTL, "authOidcJwksCacheTTL", c.Auth.Oidc.JwksCacheTTL,
+		"how long the signing keys of the identity provider are cached")
+	fs.StringVar(&c.Store.Type, "store", c.Store.Type, "persistence backend to use: mongo, memory or sqlite")
+	fs.StringVar(&c.Store.SqlitePath, "sqlitePath", c.Store.SqlitePath, "database file of the sqlite store")
+	fs.StringVar(&mongo.URI, "mongoUri", mongo.URI, "connection string of mongoDB")
+	fs.StringVar(&mongo.Username, "mongoUsername", mongo.Username,
+		"user authenticating against mongoDB, none when empty")
+	fs.StringVar(&mongo.Password, "mongoPassword", mongo.Password, "password of the mongoDB user")
+	fs.StringVar(&mongo.AuthSource, "mongoAuthSource", mongo.AuthSource, "database the mongoDB user is defined in")
+	fs.StringVar(&mongo.Database, "mongoDatabase", mongo.Database, "mongoDB database holding the collections")
+	fs.StringVar(&mongo.UserCollection, "mongoUserCollection", mongo.UserCollection,
+		"mongoDB collection of the blogUsers")
+	fs.StringVar(&mongo.PostCollection, "mongoPostCollection", mongo.PostCollection,
+		"mongoDB collection of the blogPosts")
+	fs.StringVar(&mongo.ApiKeyCollection, "mongoApiKeyCollection", mongo.ApiKeyCollection,
+		"mongoDB collection of the api keys")
+	fs.StringVar(&mongo.RefreshTokenCollection, "mongoRefreshTokenCollection", mongo.RefreshTokenCollection,
+		"mongoDB collection of the redeemed refresh tokens")
+	fs.DurationVar(&mongo.ConnectTimeout, "mongoConnectTimeout", mongo.ConnectTimeout,
+		"timeout of the mongoDB connection at startup")
+	fs.DurationVar(&mongo.ServerSelectionTimeout, "mongoServerSelectionTimeout", mongo.ServerSelectionTimeout,
+		"how long an operation waits for a mongoDB server")
+	fs.DurationVar(&mongo.SocketTimeout, "mongoSocketTimeout", mongo.SocketTimeout,
+		"timeout of every read and write to mongoDB, 0 for none")
+	fs.Uint64Var(&mongo.MaxPoolSize, "mongoMaxPoolSize", mongo.MaxPoolSize, "maximum connections per mongoDB server")
+	fs.Uint64Var(&mongo.MinPoolSize, "mongoMinPoolSize", mongo.MinPoolSize, "minimum connections per mongoDB server")
+	fs.DurationVar(&mongo.OperationTimeout, "mongoOperationTimeout", mongo.OperationTimeout,
+		"timeout of every store operation on top of the request deadline")
+	fs.DurationVar(&mongo.HeartbeatInterval, "mongoHeartbeatInterval", mongo.HeartbeatInterval,
+		"how often the mongoDB connection is checked while it is up")
+	fs.DurationVar(&mongo.ReconnectMinBackoff, "mongoReconnectMinBackoff", mongo.ReconnectMinBackoff,
+		"first delay between the checks of a mongoDB connection that is down, doubled after every failure")
+	fs.DurationVar(&mongo.ReconnectMaxBackoff, "mongoReconnectMaxBackoff", mongo.ReconnectMaxBackoff,
+		"maximum delay between the checks of a mongoDB connection that is down")
+	fs.IntVar(&mongo.BreakerThreshold, "mongoBreakerThreshold", mongo.BreakerThreshold,
+		"consecutive failed mongoDB operations opening the circuit breaker")
+	fs.DurationVar(&mongo.BreakerCooldown, "mongoBreakerCooldown", mongo.BreakerCooldown,
+		"how long the open circuit breaker fails the mongoDB operations fast")
+	fs.VisitAll(func(f *flag.Flag) {
+		f.Usage = fmt.Sprintf("%s (env %s)", f.Usage, envName(f.Name))
+	})
+}
+
+// stringList is a flag of comma separated values
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// signingKeys is a flag of comma separated id:secret keys
+type signingKeys []serve.SigningKey
+
+func (k *signingKeys) String() string {
+	if k == nil {
+		return ""
+	}
+	keys := make([]string, 0, len(*k))
+	for _, key := range *k {
+		keys = append(keys, key.Id+":"+key.Secret)
+	}
+	return strings.Join(keys, ",")
+}
+
+func (k *signingKeys) Set(value string) error {
+	*k = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(item, ":")
+		if !ok {
+			return errors.New("a key is not id:secret")
+		}
+		*k = append(*k, serve.SigningKey{Id: id, Secret: secret})
+	}
+	return nil
+}
+
+// envName returns the environment variable overriding the flag with the given name,
+// e.g. BLOG_MONGO_MAX_POOL_SIZE for mongoMaxPoolSize
+func envName(flagName string) string {
+	var name strings.Builder
+	name.WriteString("BLOG")
+	for i, r := range flagName {
+		if i == 0 || unicode.IsUpper(r) {
+			name.WriteByte('_')
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return name.String()
+}
+
+// Load builds the configuration from the defaults, the YAML file given by -config or BLOG_CONFIG,
+// the environment and the flags of args, in increasing precedence, and validates it.
+// lookupEnv is usually os.LookupEnv.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	config := Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	config.register(fs)
+	configPath, _ := lookupEnv(ConfigEnv)
+	fs.StringVar(&configPath, "config", configPath, fmt.Sprintf("YAML configuration file (env %s)", ConfigEnv))
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	//The flags were parsed straight into the configuration, keep them aside to apply them last
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	config = Default()
+	if configPath != "" {
+		if err := config.loadFile(configPath); err != nil {
+			return Config{}, err
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(envName(f.Name))
+		if f.Name == "config" || !ok || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", envName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+	for name, value := range flags {
+		//Parsed once already, setting them again can not fail
+		_ = fs.Set(name, value)
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Helper function to override the configuration with the YAML file at the given path, unknown keys are rejected
+func (c *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(c)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate returns every problem of the configuration joined in a single error, nil when it is valid.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.Server.ListenAddress)
+	check(err == nil, "server.listenAddress %q is not a host:port", c.Server.ListenAddress)
+	check(c.Server.MaxPageSize > 0, "server.maxPageSize must be positive")
+	_, err = store.ParseOnPosts(string(c.Server.OnPosts))
+	check(err == nil, "server.onPosts: %v", err)
+	check(c.Server.TrashRetention >= 0, "server.trashRetention must not be negative")
+	check(c.Server.JanitorInterval > 0, "server.janitorInterval must be positive")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdownDelay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+
+	_, err = log.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level: %v", err)
+	check(c.Log.Format == utils.LogJson || c.Log.Format == utils.LogLogfmt || c.Log.Format == utils.LogText,
+		"log.format %q is not json, logfmt or text", c.Log.Format)
+	check(c.Log.File == "" || c.Log.MaxSizeMB > 0, "log.maxSizeMB must be positive")
+	check(c.Log.MaxBackups >= 0, "log.maxBackups must not be negative")
+	check(c.Log.MaxAgeDays >= 0, "log.maxAgeDays must not be negative")
+	check(c.Log.DebugSampling > 0, "log.debugSampling must be positive")
+
+	switch c.Tracing.Exporter {
+	case utils.TracingNone, utils.TracingStdout:
+	case utils.TracingOtlp:
+		_, _, err = net.SplitHostPort(c.Tracing.Endpoint)
+		check(err == nil, "tracing.endpoint %q is not a host:port", c.Tracing.Endpoint)
+	default:
+		check(false, "tracing.exporter %q is not none, stdout or otlp", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+
+	check(c.Auth.Issuer != "", "auth.issuer is required")
+	check(c.Auth.AccessTokenTTL > 0, "auth.accessTokenTTL must be positive")
+	check(c.Auth.RefreshTokenTTL >= c.Auth.AccessTokenTTL, "auth.refreshTokenTTL must not be less than accessTokenTTL")
+	keyIds := make(map[string]bool, len(c.Auth.Keys))
+	for i, key := range c.Auth.Keys {
+		check(key.Id != "", "auth.keys[%d].id is required", i)
+		check(!keyIds[key.Id], "auth.keys[%d].id %q is used more than once", i, key.Id)
+		check(len(key.Secret) >= serve.MinSecretLength, "auth.keys[%d].secret must be at least %d bytes", i,
+			serve.MinSecretLength)
+		keyIds[key.Id] = true
+	}
+	if oidc := c.Auth.Oidc; oidc.Issuer != "" {
+		check(httpUrl(oidc.Issuer), "auth.oidc.issuer %q is not an http or https url", oidc.Issuer)
+		check(oidc.ClientId != "", "auth.oidc.clientId is required by the oidc login")
+		check(httpUrl(oidc.RedirectUrl), "auth.oidc.redirectUrl %q is not an http or https url", oidc.RedirectUrl)
+		check(oidc.JwksCacheTTL > 0, "auth.oidc.jwksCacheTTL must be positive")
+	}
+
+	switch c.Store.Type {
+	case "memory":
+	case "sqlite":
+		check(c.Store.SqlitePath != "", "store.sqlitePath is required by the sqlite store")
+	case "mongo":
+		mongo := c.Store.Mongo
+		uri, err := url.Parse(mongo.URI)
+		check(err == nil && (uri.Scheme == "mongodb" || uri.Scheme == "mongodb+srv"),
+			"store.mongo.uri %q is not a mongodb:// or mongodb+srv:// connection string", redactURI(mongo.URI))
+		check(mongo.Password == "" || mongo.Username != "", "store.mongo.password is set without a username")
+		check(mongo.Database != "", "store.mongo.database is required")
+		check(mongo.UserCollection != "", "store.mongo.userCollection is required")
+		check(mongo.PostCollection != "", "store.mongo.postCollection is required")
+		check(mongo.ApiKeyCollection != "", "store.mongo.apiKeyCollection is required")
+		check(mongo.RefreshTokenCollection != "", "store.mongo.refreshTokenCollection is required")
+		collections := map[string]bool{mongo.UserCollection: true, mongo.PostCollection: true,
+			mongo.ApiKeyCollection: true, mongo.RefreshTokenCollection: true}
+		check(len(collections) == 4, "store.mongo.userCollection, store.mongo.postCollection, "+
+			"store.mongo.apiKeyCollection and store.mongo.refreshTokenCollection must differ")
+		check(mongo.ConnectTimeout > 0, "store.mongo.connectTimeout must be positive")
+		check(mongo.ServerSelectionTimeout > 0, "store.mongo.serverSelectionTimeout must be positive")
+		check(mongo.SocketTimeout >= 0, "store.mongo.socketTimeout must not be negative")
+		check(mongo.MaxPoolSize > 0, "store.mongo.maxPoolSize must be positive")
+		check(mongo.MinPoolSize <= mongo.MaxPoolSize, "store.mongo.minPoolSize must not exceed maxPoolSize")
+		check(mongo.OperationTimeout > 0, "store.mongo.operationTimeout must be positive")
+		check(mongo.HeartbeatInterval > 0, "store.mongo.heartbeatInterval must be positive")
+		check(mongo.ReconnectMinBackoff > 0, "store.mongo.reconnectMinBackoff must be positive")
+		check(mongo.ReconnectMaxBackoff >= mongo.ReconnectMinBackoff,
+			"store.mongo.reconnectMaxBackoff must not be less than reconnectMinBackoff")
+		check(mongo.BreakerThreshold > 0, "store.mongo.breakerThreshold must be positive")
+		check(mongo.BreakerCooldown > 0, "store.mongo.breakerCooldown must be positive")
+	default:
+		check(false, "store.type %q must be one of mongo, memory or sqlite", c.Store.Type)
+	}
+	return errors.Join(errs...)
+}
+
+// Dump writes the configuration as YAML, with the secrets redacted, in the format of the config file.
+func (c Config) Dump(w io.Writer) error {
+	mongo := &c.Store.Mongo
+	if mongo.Password != "" {
+		mongo.Password = redacted
+	}
+	mongo.URI = redactURI(mongo.URI)
+	keys := make([]serve.SigningKey, 0, len(c.Auth.Keys))
+	for _, key := range c.Auth.Keys {
+		keys = append(keys, serve.SigningKey{Id: key.Id, Secret: redacted})
+	}
+	c.Auth.Keys = keys
+	if c.Auth.Oidc.ClientSecret != "" {
+		c.Auth.Oidc.ClientSecret = redacted
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Helper function to hide the password of a connection string
+func redactURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.User == nil {
+		return uri
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+	}
+	return parsed.String()
+}
+
+// Helper function to tell whether the value is an absolute http or https url
+func httpUrl(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -8,24 +8,216 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gouthams/blogApp/server/config"
+	model "github.com/gouthams/blogApp/server/model"
 	serve "github.com/gouthams/blogApp/server/restimpl"
+	"github.com/gouthams/blogApp/server/store"
 	"github.com/gouthams/blogApp/server/utils"
 )
 
-const port = ":8080"
+// dumpConfigCommand prints the effective configuration instead of starting the server
+const dumpConfigCommand = "dump-config"
+
+// setRoleCommand gives a role to the user of an email instead of starting the server, e.g. to make the first admin
+const setRoleCommand = "set-role"
+
+// linkOidcCommand lets the user of an email sign in with the identity provider although it signed up with a password
+const linkOidcCommand = "link-oidc"
+
+// userCommandTimeout bounds the wait for the database of the set-role and link-oidc commands
+const userCommandTimeout = time.Minute
 
 func main() {
+	args := os.Args[1:]
+	dumpConfig := len(args) > 0 && args[0] == dumpConfigCommand
+	if dumpConfig {
+		args = args[1:]
+	}
+	//The user commands change the user of an email instead of starting the server
+	var userEmail, userChanged string
+	var changeUser func(user *model.BlogUser)
+	switch {
+	case len(args) > 0 && args[0] == setRoleCommand:
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s <email> <role> [flags]\n", os.Args[0], setRoleCommand)
+			os.Exit(2)
+		}
+		role, err := serve.ParseRole(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid role: %v\n", err)
+			os.Exit(2)
+		}
+		userEmail, args = args[1], args[3:]
+		changeUser = func(user *model.BlogUser) { user.Role = string(role) }
+		userChanged = fmt.Sprintf("Role %s set", role)
+	case len(args) > 0 && args[0] == linkOidcCommand:
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s <email> [flags]\n", os.Args[0], linkOidcCommand)
+			os.Exit(2)
+		}
+		userEmail, args = args[1], args[2:]
+		changeUser = func(user *model.BlogUser) { user.OidcLinked = true }
+		userChanged = "User linked to the identity provider"
+	}
+
+	cfg, err := config.Load(os.Args[0], args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+	if dumpConfig {
+		if err := cfg.Dump(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to dump the configuration: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	//Initialize logging framework
-	utils.InitializeLogging()
+	if err := utils.InitializeLogging(cfg.Log); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to initialize the logging: %v\n", err)
+		os.Exit(1)
+	}
+	logEntry := utils.Log()
+
+	shutdownTracing, err := utils.InitializeTracing(cfg.Tracing)
+	if err != nil {
+		logEntry.Fatalf("Unable to initialize the tracing: %v", err)
+	}
+
+	//Without configured keys the tokens are only valid until the server stops
+	if len(cfg.Auth.Keys) == 0 {
+		key, err := serve.GenerateSigningKey()
+		if err != nil {
+			logEntry.Fatalf("Unable to generate a signing key: %v", err)
+		}
+		logEntry.Warn("No auth.keys configured, the tokens are signed with a generated key")
+		cfg.Auth.Keys = []serve.SigningKey{key}
+	}
+	auth, err := serve.NewAuth(cfg.Auth)
+	if err != nil {
+		logEntry.Fatalf("Invalid auth configuration: %v", err)
+	}
+	var oidc *serve.Oidc
+	if cfg.Auth.Oidc.Issuer != "" {
+		oidc, err = serve.NewOidc(cfg.Auth.Oidc)
+		if err != nil {
+			logEntry.Fatalf("Invalid auth.oidc configuration: %v", err)
+		}
+		logEntry.Infof("Oidc login with %s", cfg.Auth.Oidc.Issuer)
+	}
 
 	//Initialize DB
-	utils.ConnectToDatabase()
-	logEntry := utils.Log()
-	router := serve.NewRouter()
+	s, err := store.Open(cfg.Store)
+	if err != nil {
+		logEntry.Fatalf("Unable to open the %s store: %v", cfg.Store.Type, err)
+	}
+	logEntry.Infof("Using %s store", cfg.Store.Type)
+	if changeUser != nil {
+		err := updateUser(s, userEmail, changeUser)
+		if closeErr := s.Close(); closeErr != nil {
+			logEntry.Errorf("Unable to close the %s store: %v", cfg.Store.Type, closeErr)
+		}
+		if err != nil {
+			logEntry.Fatalf("Unable to update the user of %s: %v", userEmail, err)
+		}
+		logEntry.Info(userChanged)
+		return
+	}
+
+	//Every store operation is recorded and traced, including the purges of the janitor
+	metrics := serve.NewMetrics()
+	s = s.Observe(metrics.ObserveOperation).Observe(serve.TraceOperation)
+
+	//SIGINT and SIGTERM shut the server down gracefully, a second signal kills it right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	//Purge the trash in the background
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	janitorDone := make(chan struct{})
+	go func() {
+		defer close(janitorDone)
+		store.RunJanitor(janitorCtx, s, cfg.Server.TrashRetention, cfg.Server.JanitorInterval)
+	}()
+
+	lifecycle := &serve.Lifecycle{}
+	router := serve.NewRouter(s, serve.WithMaxPageSize(cfg.Server.MaxPageSize), serve.WithOnPosts(cfg.Server.OnPosts),
+		serve.WithLifecycle(lifecycle), serve.WithMetrics(metrics), serve.WithAuth(auth),
+		serve.WithOidc(oidc))
+
+	address := cfg.Server.ListenAddress
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logEntry.Fatalf("Unable to start the server on %s: %v", address, err)
+	}
+	logEntry.Infof("Server starting on %s", listener.Addr())
+	err = serve.Serve(ctx, &http.Server{Handler: router}, listener, lifecycle,
+		cfg.Server.ShutdownDelay, cfg.Server.ShutdownTimeout)
+	stop()
+	if err != nil {
+		logEntry.Errorf("Server stopped uncleanly: %v", err)
+	}
+
+	//The database is closed once nothing uses it anymore
+	stopJanitor()
+	<-janitorDone
+	if closeErr := s.Close(); closeErr != nil {
+		logEntry.Errorf("Unable to close the %s store: %v", cfg.Store.Type, closeErr)
+		err = closeErr
+	}
+
+	//The spans not exported yet are flushed
+	tracingCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if tracingErr := shutdownTracing(tracingCtx); tracingErr != nil {
+		logEntry.Errorf("Unable to flush the spans: %v", tracingErr)
+	}
+	logEntry.Info("Server stopped")
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// updateUser applies the change to the user of the email, waiting for the database to be available
+func updateUser(s store.Store, email string, change func(user *model.BlogUser)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), userCommandTimeout)
+	defer cancel()
+	for {
+		err := updateUserOnce(ctx, s, email, change)
+		if !errors.Is(err, store.ErrUnavailable) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Second):
+		}
+	}
+}
 
-	err := router.Run(port)
+// Helper function to apply the change to the user of the email, a new role applies to the tokens issued before from
+// their refresh
+func updateUserOnce(ctx context.Context, s store.Store, email string, change func(user *model.BlogUser)) error {
+	user, err := s.Users.GetByEmail(ctx, email)
 	if err != nil {
-		logEntry.Fatalf("Unable to start the server on port:%s", port)
+		return err
 	}
-	logEntry.Info("Server started on port:%s", port)
+	change(&user)
+	user.LastModifiedDate = time.Now().UTC()
+	return s.Users.Replace(ctx, user, user.Version)
 }
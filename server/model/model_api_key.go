@@ -0,0 +1,50 @@
+/*
+ * Simple blogging APIs
+ *
+ * This is a simple blogging API
+ *
+ * API version: 1.0.0
+ * Contact: gouthams.ku@gmail.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package restimpl
+
+import (
+	"time"
+)
+
+// ApiKey authenticates the requests of a machine client on behalf of a user
+type ApiKey struct {
+	Id string `json:"id,omitempty"`
+
+	// UserId is the user the requests are made on behalf of
+	UserId string `json:"userId,omitempty"`
+
+	Name string `json:"name" binding:"required,max=100"`
+
+	// Prefix is the public start of the key, it identifies the key in lists and logs
+	Prefix string `json:"prefix,omitempty"`
+
+	// Scopes are read and write
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,oneof=read write"`
+
+	// ExpiresAt is when the key stops authenticating, never when empty
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+
+	// LastUsedAt is when the key last authenticated a request, approximately
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+
+	// Key is the secret key, only returned when the key is created
+	Key string `json:"key,omitempty" bson:"-"`
+
+	// KeyHash is the SHA-256 hash of the key, the key itself is never stored
+	KeyHash string `json:"-"`
+}
+
+// ApiKeyList is the list of the api keys of a user
+type ApiKeyList struct {
+	Items []ApiKey `json:"items"`
+}
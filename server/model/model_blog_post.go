@@ -24,4 +24,10 @@ type BlogPost struct {
 	Content string `json:"content" binding:"required"`
 
 	LastModifiedDate time.Time `json:"lastModifiedDate,omitempty"`
+
+	// Version is incremented on every update and exposed as the ETag header
+	Version int64 `json:"-"`
+
+	// DeletedAt is set while the post is in the trash, it is ignored in requests
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
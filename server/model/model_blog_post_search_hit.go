@@ -0,0 +1,28 @@
+/*
+ * Simple blogging APIs
+ *
+ * This is a simple blogging API
+ *
+ * API version: 1.0.0
+ * Contact: gouthams.ku@gmail.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package restimpl
+
+type BlogPostSearchHit struct {
+	Post BlogPost `json:"post"`
+
+	// Score is the relevance of the post for the search, hits are ordered by decreasing score
+	Score float64 `json:"score"`
+
+	Highlights BlogPostHighlights `json:"highlights"`
+}
+
+// BlogPostHighlights holds the matching fields with the matched words wrapped in <em> tags,
+// the rest of the text is html escaped. Long contents are cut down to a snippet around the first match.
+type BlogPostHighlights struct {
+	Topic string `json:"topic,omitempty"`
+
+	Content string `json:"content,omitempty"`
+}
@@ -0,0 +1,17 @@
+/*
+ * Simple blogging APIs
+ *
+ * This is a simple blogging API
+ *
+ * API version: 1.0.0
+ * Contact: gouthams.ku@gmail.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package restimpl
+
+type BlogPostSearchPage struct {
+	Items []BlogPostSearchHit `json:"items"`
+
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
@@ -22,4 +22,25 @@ type BlogUser struct {
 	Email string `json:"email" binding:"required"`
 
 	LastModifiedDate time.Time `json:"lastModifiedDate,omitempty"`
+
+	// Version is incremented on every update and exposed as the ETag header
+	Version int64 `json:"-"`
+
+	// DeletedAt is set while the user is in the trash, it is ignored in requests
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// Password is only read from requests, it is stored as PasswordHash and never returned
+	Password string `json:"password,omitempty" bson:"-" binding:"omitempty,min=8,max=72"`
+
+	// PasswordHash is the bcrypt hash of the password, empty when the user can not log in with a password
+	PasswordHash string `json:"-"`
+
+	// Role is one of reader, author, editor and admin, only an admin can change it
+	Role string `json:"role,omitempty" binding:"omitempty,oneof=reader author editor admin"`
+
+	// OidcLinked is set once the user may sign in with the identity provider, it is unset when the email changes
+	OidcLinked bool `json:"-"`
+
+	// TokenGeneration is incremented when the password or the email changes, the older refresh tokens are revoked
+	TokenGeneration int64 `json:"-"`
 }
@@ -0,0 +1,36 @@
+/*
+ * Simple blogging APIs
+ *
+ * This is a simple blogging API
+ *
+ * API version: 1.0.0
+ * Contact: gouthams.ku@gmail.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package restimpl
+
+// Credentials are the email and the password of a user logging in
+type Credentials struct {
+	Email string `json:"email" binding:"required"`
+
+	Password string `json:"password" binding:"required"`
+}
+
+// RefreshRequest exchanges a refresh token for a new pair of tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// TokenPair is the access token authenticating the requests and the refresh token renewing it
+type TokenPair struct {
+	AccessToken string `json:"accessToken"`
+
+	RefreshToken string `json:"refreshToken"`
+
+	// TokenType is always Bearer
+	TokenType string `json:"tokenType"`
+
+	// ExpiresIn is the number of seconds the access token is valid for
+	ExpiresIn int64 `json:"expiresIn"`
+}
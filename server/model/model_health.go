@@ -0,0 +1,31 @@
+/*
+ * Simple blogging APIs
+ *
+ * This is a simple blogging API
+ *
+ * API version: 1.0.0
+ * Contact: gouthams.ku@gmail.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package restimpl
+
+type Health struct {
+	// Status is pass when every check passed, fail otherwise
+	Status string `json:"status"`
+
+	Checks []HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	Name string `json:"name"`
+
+	// Status is pass or fail
+	Status string `json:"status"`
+
+	// LatencyMs is how long the check took in milliseconds
+	LatencyMs float64 `json:"latencyMs"`
+
+	// Error tells why the check failed
+	Error string `json:"error,omitempty"`
+}
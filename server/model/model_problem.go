@@ -0,0 +1,45 @@
+/*
+ * Simple blogging APIs
+ *
+ * This is a simple blogging API
+ *
+ * API version: 1.0.0
+ * Contact: gouthams.ku@gmail.com
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package restimpl
+
+// Problem is an RFC 7807 problem detail, every error is answered with one as application/problem+json
+type Problem struct {
+	// Type identifies the kind of problem, it ends with the code
+	Type string `json:"type"`
+
+	Title string `json:"title"`
+
+	Status int `json:"status"`
+
+	Detail string `json:"detail,omitempty"`
+
+	// Instance is the path of the request that failed
+	Instance string `json:"instance,omitempty"`
+
+	// Code is the machine-readable error code
+	Code string `json:"code"`
+
+	// RequestId is the X-Request-ID of the request, it is in every log line of the request
+	RequestId string `json:"requestId,omitempty"`
+
+	// Errors lists the invalid fields and parameters of the request
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	// Field is the json name of the invalid field or the name of the invalid parameter
+	Field string `json:"field"`
+
+	// Code is the machine-readable reason, e.g. required
+	Code string `json:"code"`
+
+	Message string `json:"message"`
+}
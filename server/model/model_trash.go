@@ -10,8 +10,8 @@
 
 package restimpl
 
-type Error struct {
-	Code string `json:"code"`
+type Trash struct {
+	BlogUsers []BlogUser `json:"blogUsers"`
 
-	Message string `json:"message"`
+	BlogPosts []BlogPost `json:"blogPosts"`
 }
@@ -0,0 +1,123 @@
+/*
+ * Simple blogging API handlers
+ */
+
+package restimpl
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+	"mime"
+	"net/http"
+)
+
+// Login - exchanges the email and the password of a user for tokens
+func Login(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Login request received.")
+
+	contentType := c.Request.Header.Get("Content-type")
+	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
+		logEntry.Errorf("Unsupported content type : %s", contentType)
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
+		return
+	}
+
+	var credentials restimpl.Credentials
+	err := c.ShouldBindJSON(&credentials)
+	if err != nil {
+		logEntry.Errorf("Json parsing error %v", err)
+		abortWithProblem(c, errInvalidBody(err))
+		return
+	}
+
+	user, err := getBlogUserByEmail(c, credentials.Email, logEntry)
+	if err != nil && err != store.ErrNotFound {
+		abortWithProblem(c, err)
+		return
+	}
+	//An unknown email is rejected like a wrong password, to not tell which emails are registered
+	if !checkPassword(user.PasswordHash, credentials.Password) {
+		logEntry.Warn("Invalid credentials")
+		abortWithProblem(c, errInvalidCredentials())
+		return
+	}
+
+	issueTokens(c, user)
+}
+
+// Refresh - exchanges a refresh token for new tokens
+func Refresh(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Refresh request received.")
+
+	contentType := c.Request.Header.Get("Content-type")
+	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
+		logEntry.Errorf("Unsupported content type : %s", contentType)
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
+		return
+	}
+
+	var refresh restimpl.RefreshRequest
+	err := c.ShouldBindJSON(&refresh)
+	if err != nil {
+		logEntry.Errorf("Json parsing error %v", err)
+		abortWithProblem(c, errInvalidBody(err))
+		return
+	}
+
+	claims, err := routerConfig(c).Auth.verifyClaims(refresh.RefreshToken, refreshTokenUse)
+	if err != nil {
+		logEntry.Warnf("Invalid refresh token: %v", err)
+		abortWithProblem(c, errInvalidToken(err))
+		return
+	}
+	//The users deleted since the login can not get new tokens, the others get them with their current role
+	user, err := getBlogUserByid(c, claims.Subject, logEntry)
+	if err == store.ErrNotFound {
+		logEntry.Warnf("User with id: %s of the refresh token not found", claims.Subject)
+		abortWithProblem(c, errInvalidToken(err))
+		return
+	}
+	if err != nil {
+		abortWithProblem(c, err)
+		return
+	}
+	//A change of the password or the email revokes the refresh tokens issued before
+	if claims.Generation != user.TokenGeneration {
+		logEntry.Warnf("Refresh token of user with id: %s is revoked", user.Id)
+		abortWithProblem(c, errInvalidToken(errors.New("refresh token revoked")))
+		return
+	}
+	//A refresh token is exchanged once, a replayed one is rejected
+	err = refreshTokenRepository(c).Redeem(c.Request.Context(), claims.ID, claims.ExpiresAt.Time)
+	if err == store.ErrDuplicate {
+		logEntry.Warnf("Refresh token of user with id: %s already used", user.Id)
+		abortWithProblem(c, errInvalidToken(errors.New("refresh token already used")))
+		return
+	}
+	if err != nil {
+		logEntry.Errorf("Redeem failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	issueTokens(c, user)
+}
+
+// Helper function to answer with new tokens of the user
+func issueTokens(c *gin.Context, user restimpl.BlogUser) {
+	logEntry := requestLog(c).WithField("user", user.Id)
+	tokens, err := routerConfig(c).Auth.issue(Principal{UserId: user.Id, Role: userRole(user)}, user.TokenGeneration)
+	if err != nil {
+		logEntry.Errorf("Token signing failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.Info("Tokens issued")
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, tokens)
+}
@@ -2,19 +2,31 @@ package restimpl
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/golang-jwt/jwt/v5"
 	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
 	"github.com/gouthams/blogApp/server/utils"
 	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/h2non/gock.v1"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 const httpProtocol = "http"
@@ -24,32 +36,65 @@ type RestImplTestSuite struct {
 	suite.Suite
 	MockPost restimpl.BlogPost
 	MockUser restimpl.BlogUser
+	Store    store.Store
+	// NewStore returns the empty store every test starts with
+	NewStore func() store.Store
+	// Auth signs the tokens of the routers of the tests
+	Auth *Auth
+	// Token is the access token of the requests sent without an Authorization header
+	Token string
 }
 
 func TestRestImplTestSuite(t *testing.T) {
-	testSuite := &RestImplTestSuite{}
+	testSuite := &RestImplTestSuite{NewStore: store.NewMemoryStore}
+	suite.Run(t, testSuite)
+}
+
+func TestRestImplTestSuiteSqlite(t *testing.T) {
+	testSuite := &RestImplTestSuite{NewStore: func() store.Store {
+		s, err := store.OpenSqlite(":memory:")
+		if err != nil {
+			t.Fatalf("Open sqlite store failed: %v", err)
+		}
+		return s
+	}}
 	suite.Run(t, testSuite)
 }
 
 func (suite *RestImplTestSuite) SetupSuite() {
-	suite.TearDownSuite()
 	suite.MockPost = restimpl.BlogPost{UserId: "", Topic: "TestTopic", Content: "TestContent"}
 	suite.MockUser = restimpl.BlogUser{Name: "David", Email: "david@abc.com"}
-}
-
-func (suite *RestImplTestSuite) AfterTest(_, _ string) {
-	gock.Off()
-	err := utils.FlushCollections()
+	suite.Auth = generatedAuth()
+	//The token of the suite is an admin's, allowed on the resources of every user
+	tokens, err := suite.Auth.issue(Principal{UserId: uuid.NewV4().String(), Role: RoleAdmin}, 0)
 	if err != nil {
-		log.Printf("Flush Collection failed: %v", err)
+		log.Fatalf("Token signing failed %v", err)
 	}
+	suite.Token = tokens.AccessToken
 }
 
-func (suite *RestImplTestSuite) TearDownSuite() {
-	err := utils.FlushCollections()
-	if err != nil {
-		log.Printf("Flush Collection failed: %v", err)
-	}
+// newRouter returns the router of the store, the requests without an Authorization header are sent with the token of
+// the suite
+func (suite *RestImplTestSuite) newRouter(s store.Store, options ...Option) http.Handler {
+	router := NewRouter(s, append(options, WithAuth(suite.Auth))...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+suite.Token)
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+func (suite *RestImplTestSuite) SetupTest() {
+	suite.Store = suite.NewStore()
+}
+
+func (suite *RestImplTestSuite) TearDownTest() {
+	suite.Store.Close()
+}
+
+func (suite *RestImplTestSuite) AfterTest(_, _ string) {
+	gock.Off()
 }
 
 func getBlogPostUrl(resourceId string) string {
@@ -130,7 +175,7 @@ func PerformRequest(router http.Handler, method, path string, body interface{},
 func (suite *RestImplTestSuite) TestCRUDBlogUsers() {
 
 	_, path := getHostPath(suite.T(), getBlogUserUrl(""))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": "application/json"}
 
 	response := PerformRequest(router, http.MethodPost, path, suite.MockUser, header)
@@ -171,7 +216,7 @@ func (suite *RestImplTestSuite) TestCRUDBlogUsers() {
 }
 
 func (suite *RestImplTestSuite) TestCRUDBlogPosts() {
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": "application/json"}
 
 	//Create a blog User to get the userId
@@ -228,7 +273,7 @@ func (suite *RestImplTestSuite) TestCRUDBlogPosts() {
 //Negative test cases for blogUser
 func (suite *RestImplTestSuite) TestInvalidBlogUsers() {
 	_, path := getHostPath(suite.T(), getBlogUserUrl(""))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": ""}
 
 	response := PerformRequest(router, http.MethodPost, path, suite.MockUser, header)
@@ -245,7 +290,7 @@ func (suite *RestImplTestSuite) TestInvalidBlogUsers() {
 
 func (suite *RestImplTestSuite) TestDuplicateBlogUsers() {
 	_, path := getHostPath(suite.T(), getBlogUserUrl(""))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": "application/json"}
 
 	response := PerformRequest(router, http.MethodPost, path, suite.MockUser, header)
@@ -260,7 +305,7 @@ func (suite *RestImplTestSuite) TestDuplicateBlogUsers() {
 
 func (suite *RestImplTestSuite) TestGetInvalidBlogUsers() {
 	_, path := getHostPath(suite.T(), getBlogUserUrl("12345"))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": "application/json"}
 
 	response := PerformRequest(router, http.MethodGet, path, "", header)
@@ -275,7 +320,7 @@ func (suite *RestImplTestSuite) TestGetInvalidBlogUsers() {
 
 func (suite *RestImplTestSuite) TestInvalidBlogUserUpdate() {
 	_, path := getHostPath(suite.T(), getBlogUserUrl(uuid.NewV4().String()))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": ""}
 
 	response := PerformRequest(router, http.MethodPut, path, suite.MockUser, header)
@@ -296,7 +341,7 @@ func (suite *RestImplTestSuite) TestInvalidBlogUserUpdate() {
 
 func (suite *RestImplTestSuite) TestInvalidBlogUserDelete() {
 	_, path := getHostPath(suite.T(), getBlogUserUrl(uuid.NewV4().String()))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": ""}
 
 	response := PerformRequest(router, http.MethodDelete, path, "", header)
@@ -313,7 +358,7 @@ func (suite *RestImplTestSuite) TestInvalidBlogUserDelete() {
 //Negative test cases for blogPost
 func (suite *RestImplTestSuite) TestInvalidBlogPosts() {
 	_, path := getHostPath(suite.T(), getBlogPostUrl(""))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": ""}
 
 	response := PerformRequest(router, http.MethodPost, path, suite.MockPost, header)
@@ -330,7 +375,7 @@ func (suite *RestImplTestSuite) TestInvalidBlogPosts() {
 
 func (suite *RestImplTestSuite) TestGetInvalidBlogPosts() {
 	_, path := getHostPath(suite.T(), getBlogPostUrl("12345"))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": "application/json"}
 
 	response := PerformRequest(router, http.MethodGet, path, "", header)
@@ -345,7 +390,7 @@ func (suite *RestImplTestSuite) TestGetInvalidBlogPosts() {
 
 func (suite *RestImplTestSuite) TestInvalidBlogPostUpdate() {
 	_, path := getHostPath(suite.T(), getBlogPostUrl(uuid.NewV4().String()))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": ""}
 
 	response := PerformRequest(router, http.MethodPut, path, suite.MockPost, header)
@@ -366,7 +411,7 @@ func (suite *RestImplTestSuite) TestInvalidBlogPostUpdate() {
 
 func (suite *RestImplTestSuite) TestInvalidBlogPostDelete() {
 	_, path := getHostPath(suite.T(), getBlogPostUrl(uuid.NewV4().String()))
-	router := NewRouter()
+	router := suite.newRouter(suite.Store)
 	header := map[string]string{"Content-Type": ""}
 
 	response := PerformRequest(router, http.MethodDelete, path, "", header)
@@ -379,3 +424,1744 @@ func (suite *RestImplTestSuite) TestInvalidBlogPostDelete() {
 	assert.NotNil(suite.T(), response.Result().Body)
 
 }
+
+func (suite *RestImplTestSuite) TestUpdateMissingResources() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	response := PerformRequest(router, http.MethodPut, getBlogUserUrl(uuid.NewV4().String()), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+
+	userResponse := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, userResponse.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(userResponse.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	postBody := restimpl.BlogPost{UserId: blogUserResp.Id, Topic: "Topic", Content: "Content"}
+	missingPath := getBlogPostUrl(uuid.NewV4().String())
+	response = PerformRequest(router, http.MethodPut, missingPath, postBody, header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+
+	//Update must not create the resource
+	response = PerformRequest(router, http.MethodGet, missingPath, "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestUpdateBlogUserEmailConflict() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+
+	other := restimpl.BlogUser{Name: "Other", Email: "other@abc.com"}
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""), other, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	otherResp := restimpl.BlogUser{}
+	err := json.Unmarshal(response.Body.Bytes(), &otherResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	other.Email = suite.MockUser.Email
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(otherResp.Id), other, header)
+	assert.Equal(suite.T(), http.StatusConflict, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestConcurrentBlogPostUpdates() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	userResponse := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, userResponse.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(userResponse.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	postBody := restimpl.BlogPost{UserId: blogUserResp.Id, Topic: "OriginalTopic", Content: "OriginalContent"}
+	response := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), postBody, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	blogPostResp := restimpl.BlogPost{}
+	err = json.Unmarshal(response.Body.Bytes(), &blogPostResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	path := getBlogPostUrl(blogPostResp.Id)
+
+	const writers = 10
+	var wg sync.WaitGroup
+	codes := make(chan int, writers*2)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			body := restimpl.BlogPost{UserId: blogUserResp.Id, Topic: fmt.Sprintf("Topic%d", i), Content: "UpdatedContent"}
+			codes <- PerformRequest(router, http.MethodPut, path, body, header).Code
+		}(i)
+		go func() {
+			defer wg.Done()
+			//The post must stay visible while it is updated
+			codes <- PerformRequest(router, http.MethodGet, path, "", header).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		assert.Equal(suite.T(), http.StatusOK, code)
+	}
+
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(""), "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	var page restimpl.BlogPostPage
+	err = json.Unmarshal(response.Body.Bytes(), &page)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Len(suite.T(), page.Items, 1)
+	assert.Equal(suite.T(), "UpdatedContent", page.Items[0].Content)
+}
+
+func (suite *RestImplTestSuite) TestConcurrentDuplicateBlogUsers() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	const writers = 10
+	var wg sync.WaitGroup
+	codes := make(chan int, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	//Exactly one of the concurrent inserts of the same email wins
+	created := 0
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else {
+			assert.Equal(suite.T(), http.StatusConflict, code)
+		}
+	}
+	assert.Equal(suite.T(), 1, created)
+}
+
+func (suite *RestImplTestSuite) TestBlogPostETags() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	userResponse := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, userResponse.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(userResponse.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	postBody := restimpl.BlogPost{UserId: blogUserResp.Id, Topic: "OriginalTopic", Content: "OriginalContent"}
+	response := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), postBody, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	assert.Equal(suite.T(), `"1"`, response.Header().Get("ETag"))
+	blogPostResp := restimpl.BlogPost{}
+	err = json.Unmarshal(response.Body.Bytes(), &blogPostResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	path := getBlogPostUrl(blogPostResp.Id)
+
+	response = PerformRequest(router, http.MethodGet, path, "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.Equal(suite.T(), `"1"`, response.Header().Get("ETag"))
+
+	response = PerformRequest(router, http.MethodGet, path, "",
+		map[string]string{"If-None-Match": `"1"`})
+	assert.Equal(suite.T(), http.StatusNotModified, response.Code)
+	assert.Empty(suite.T(), response.Body.Bytes())
+
+	postBody.Topic = "UpdatedTopic"
+	response = PerformRequest(router, http.MethodPut, path, postBody,
+		map[string]string{"Content-Type": "application/json", "If-Match": `"1"`})
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.Equal(suite.T(), `"2"`, response.Header().Get("ETag"))
+
+	//A second editor still holding version 1 must not overwrite the update
+	postBody.Topic = "StaleTopic"
+	response = PerformRequest(router, http.MethodPut, path, postBody,
+		map[string]string{"Content-Type": "application/json", "If-Match": `"1"`})
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, response.Code)
+
+	response = PerformRequest(router, http.MethodGet, path, "",
+		map[string]string{"If-None-Match": `"1"`})
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	err = json.Unmarshal(response.Body.Bytes(), &blogPostResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Equal(suite.T(), "UpdatedTopic", blogPostResp.Topic)
+
+	response = PerformRequest(router, http.MethodDelete, path, "",
+		map[string]string{"Content-Type": "application/json", "If-Match": `"1"`})
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, response.Code)
+
+	response = PerformRequest(router, http.MethodDelete, path, "",
+		map[string]string{"Content-Type": "application/json", "If-Match": `"2"`})
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+
+	response = PerformRequest(router, http.MethodDelete, path, "",
+		map[string]string{"Content-Type": "application/json", "If-Match": `"2"`})
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestBlogUserETags() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(response.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	path := getBlogUserUrl(blogUserResp.Id)
+
+	response = PerformRequest(router, http.MethodGet, path, "",
+		map[string]string{"If-None-Match": `W/"1", "5"`})
+	assert.Equal(suite.T(), http.StatusNotModified, response.Code)
+
+	response = PerformRequest(router, http.MethodPut, path, suite.MockUser,
+		map[string]string{"Content-Type": "application/json", "If-Match": `"7"`})
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, response.Code)
+
+	response = PerformRequest(router, http.MethodPut, path, suite.MockUser,
+		map[string]string{"Content-Type": "application/json", "If-Match": "not-an-etag"})
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, response.Code)
+
+	response = PerformRequest(router, http.MethodPut, path, suite.MockUser,
+		map[string]string{"Content-Type": "application/json", "If-Match": "*"})
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.Equal(suite.T(), `"2"`, response.Header().Get("ETag"))
+}
+
+func (suite *RestImplTestSuite) TestPatchBlogPosts() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+	mergeHeader := map[string]string{"Content-Type": "application/merge-patch+json"}
+	patchHeader := map[string]string{"Content-Type": "application/json-patch+json"}
+
+	userResponse := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, userResponse.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(userResponse.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	postBody := restimpl.BlogPost{UserId: blogUserResp.Id, Topic: "OriginalTopic", Content: "OriginalContent"}
+	response := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), postBody, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	blogPostResp := restimpl.BlogPost{}
+	err = json.Unmarshal(response.Body.Bytes(), &blogPostResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	path := getBlogPostUrl(blogPostResp.Id)
+
+	response = PerformRequest(router, http.MethodPatch, path, json.RawMessage(`{"topic": "PatchedTopic"}`), header)
+	assert.Equal(suite.T(), http.StatusUnsupportedMediaType, response.Code)
+
+	response = PerformRequest(router, http.MethodPatch, path, json.RawMessage(`{"topic": "PatchedTopic"}`), mergeHeader)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.Equal(suite.T(), `"2"`, response.Header().Get("ETag"))
+	err = json.Unmarshal(response.Body.Bytes(), &blogPostResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Equal(suite.T(), "PatchedTopic", blogPostResp.Topic)
+	assert.Equal(suite.T(), "OriginalContent", blogPostResp.Content)
+
+	//Removing a required field must fail the validation
+	response = PerformRequest(router, http.MethodPatch, path, json.RawMessage(`{"topic": null}`), mergeHeader)
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+
+	//The id is readonly
+	response = PerformRequest(router, http.MethodPatch, path,
+		json.RawMessage(`[{"op": "replace", "path": "/content", "value": "PatchedContent"},
+			{"op": "replace", "path": "/id", "value": "00000000-0000-0000-0000-000000000000"}]`), patchHeader)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	err = json.Unmarshal(response.Body.Bytes(), &blogPostResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Equal(suite.T(), "PatchedContent", blogPostResp.Content)
+	assert.Equal(suite.T(), "PatchedTopic", blogPostResp.Topic)
+	assert.NotEqual(suite.T(), "00000000-0000-0000-0000-000000000000", blogPostResp.Id)
+
+	response = PerformRequest(router, http.MethodPatch, path,
+		json.RawMessage(`[{"op": "test", "path": "/topic", "value": "OtherTopic"}]`), patchHeader)
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+
+	response = PerformRequest(router, http.MethodPatch, path, json.RawMessage(`{"userId": "`+uuid.NewV4().String()+`"}`), mergeHeader)
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+
+	response = PerformRequest(router, http.MethodPatch, path, json.RawMessage(`{"topic": "StaleTopic"}`),
+		map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": `"1"`})
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, response.Code)
+
+	response = PerformRequest(router, http.MethodPatch, getBlogPostUrl(uuid.NewV4().String()),
+		json.RawMessage(`{"topic": "PatchedTopic"}`), mergeHeader)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestPatchBlogUsers() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(response.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	response = PerformRequest(router, http.MethodPatch, getBlogUserUrl(blogUserResp.Id),
+		json.RawMessage(`[{"op": "replace", "path": "/name", "value": "Patched"}]`),
+		map[string]string{"Content-Type": "application/json-patch+json", "If-Match": `"1"`})
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	err = json.Unmarshal(response.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Equal(suite.T(), "Patched", blogUserResp.Name)
+	assert.Equal(suite.T(), suite.MockUser.Email, blogUserResp.Email)
+}
+
+func (suite *RestImplTestSuite) TestDeleteBlogUserWithPosts() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	addUserWithPost := func(email string) (restimpl.BlogUser, restimpl.BlogPost) {
+		user := restimpl.BlogUser{Name: "David", Email: email}
+		response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), user, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+		err := json.Unmarshal(response.Body.Bytes(), &user)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		post := restimpl.BlogPost{UserId: user.Id, Topic: "Topic", Content: "Content"}
+		response = PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+		err = json.Unmarshal(response.Body.Bytes(), &post)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		return user, post
+	}
+
+	//Users with posts are not deleted by default
+	user, post := addUserWithPost("david@abc.com")
+	response := PerformRequest(router, http.MethodDelete, getBlogUserUrl(user.Id), "", header)
+	assert.Equal(suite.T(), http.StatusConflict, response.Code)
+	response = PerformRequest(router, http.MethodDelete, getBlogUserUrl(user.Id)+"?onPosts=orphan", "", header)
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+	response = PerformRequest(router, http.MethodDelete, getBlogUserUrl(user.Id)+"?onPosts=cascade", "",
+		map[string]string{"Content-Type": "application/json", "If-Match": `"2"`})
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(post.Id), "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	response = PerformRequest(router, http.MethodDelete, getBlogUserUrl(user.Id)+"?onPosts=cascade", "", header)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogUserUrl(user.Id), "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(post.Id), "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+
+	//Reassigned posts keep existing, owned by the tombstone user
+	user, post = addUserWithPost("matt@abc.com")
+	response = PerformRequest(router, http.MethodDelete, getBlogUserUrl(user.Id)+"?onPosts=reassign", "", header)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(post.Id), "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.Equal(suite.T(), `"2"`, response.Header().Get("ETag"))
+	reassigned := restimpl.BlogPost{}
+	err := json.Unmarshal(response.Body.Bytes(), &reassigned)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Equal(suite.T(), store.TombstoneUser.Id, reassigned.UserId)
+	response = PerformRequest(router, http.MethodGet, getBlogUserUrl(store.TombstoneUser.Id), "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	//The default policy is configurable
+	router = suite.newRouter(suite.Store, WithOnPosts(store.OnPostsCascade))
+	user, post = addUserWithPost("john@abc.com")
+	response = PerformRequest(router, http.MethodDelete, getBlogUserUrl(user.Id), "", header)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(post.Id), "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestSearchBlogPostsPagination() {
+	router := suite.newRouter(suite.Store, WithMaxPageSize(3))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	userResponse := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, userResponse.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(userResponse.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		postBody := restimpl.BlogPost{UserId: blogUserResp.Id, Topic: fmt.Sprintf("Topic%d", i), Content: "Content"}
+		response := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), postBody, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	}
+
+	//Without pageSize the configured maximum is used
+	response := PerformRequest(router, http.MethodGet, getBlogPostUrl(""), "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	var page restimpl.BlogPostPage
+	err = json.Unmarshal(response.Body.Bytes(), &page)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Len(suite.T(), page.Items, 3)
+
+	seen := map[string]bool{}
+	path := "/blogPosts?userId=" + blogUserResp.Id + "&pageSize=2"
+	pages := 0
+	for path != "" {
+		response = PerformRequest(router, http.MethodGet, path, "", header)
+		assert.Equal(suite.T(), http.StatusOK, response.Code)
+		page = restimpl.BlogPostPage{}
+		err = json.Unmarshal(response.Body.Bytes(), &page)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		for _, post := range page.Items {
+			assert.False(suite.T(), seen[post.Id])
+			seen[post.Id] = true
+		}
+		pages++
+
+		path = ""
+		if page.NextPageToken != "" {
+			link := response.Header().Get("Link")
+			assert.Contains(suite.T(), link, page.NextPageToken)
+			assert.Contains(suite.T(), link, `rel="next"`)
+			path = link[1:strings.Index(link, ">")]
+		}
+	}
+	assert.Equal(suite.T(), 3, pages)
+	assert.Len(suite.T(), seen, 5)
+
+	for _, query := range []string{"pageSize=abc", "pageSize=0", "pageSize=4", "pageToken=notAToken"} {
+		response = PerformRequest(router, http.MethodGet, "/blogPosts?"+query, "", header)
+		assert.Equal(suite.T(), http.StatusBadRequest, response.Code, query)
+	}
+}
+
+func (suite *RestImplTestSuite) TestFilterBlogPosts() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	var userIds []string
+	for i := 0; i < 3; i++ {
+		user := restimpl.BlogUser{Name: "David", Email: fmt.Sprintf("david%d@abc.com", i)}
+		response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), user, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+		blogUserResp := restimpl.BlogUser{}
+		err := json.Unmarshal(response.Body.Bytes(), &blogUserResp)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		userIds = append(userIds, blogUserResp.Id)
+	}
+
+	posts := []restimpl.BlogPost{
+		{UserId: userIds[0], Topic: "golang tips", Content: "Use gofmt before committing"},
+		{UserId: userIds[1], Topic: "golang", Content: "Channels are typed conduits"},
+		{UserId: userIds[2], Topic: "rust tips", Content: "The borrow checker is your friend"},
+	}
+	var middle time.Time
+	for i, post := range posts {
+		if i == 2 {
+			time.Sleep(time.Millisecond)
+			middle = time.Now().UTC()
+			time.Sleep(time.Millisecond)
+		}
+		response := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	}
+
+	search := func(query url.Values) []string {
+		response := PerformRequest(router, http.MethodGet, "/blogPosts?"+query.Encode(), "", header)
+		assert.Equal(suite.T(), http.StatusOK, response.Code, query.Encode())
+		var page restimpl.BlogPostPage
+		err := json.Unmarshal(response.Body.Bytes(), &page)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		var topics []string
+		for _, post := range page.Items {
+			topics = append(topics, post.Topic)
+		}
+		return topics
+	}
+
+	assert.ElementsMatch(suite.T(), []string{"golang tips", "rust tips"},
+		search(url.Values{"userId": {userIds[0], userIds[2]}}))
+	assert.ElementsMatch(suite.T(), []string{"golang tips", "golang"},
+		search(url.Values{"userId": {userIds[0] + "," + userIds[1]}}))
+	assert.Equal(suite.T(), []string{"golang"}, search(url.Values{"topic": {"golang"}}))
+	assert.ElementsMatch(suite.T(), []string{"golang tips", "golang"}, search(url.Values{"topicPrefix": {"golang"}}))
+	assert.Equal(suite.T(), []string{"rust tips"}, search(url.Values{"content": {"borrow"}}))
+	assert.Empty(suite.T(), search(url.Values{"content": {"BORROW"}}))
+	assert.Equal(suite.T(), []string{"rust tips"}, search(url.Values{"since": {middle.Format(time.RFC3339Nano)}}))
+	assert.ElementsMatch(suite.T(), []string{"golang tips", "golang"},
+		search(url.Values{"until": {middle.Format(time.RFC3339Nano)}}))
+	assert.Equal(suite.T(), []string{"golang"},
+		search(url.Values{"topicPrefix": {"golang"}, "content": {"Channels"}}))
+
+	assert.Equal(suite.T(), []string{"golang", "golang tips", "rust tips"}, search(url.Values{"sort": {"topic"}}))
+	assert.Equal(suite.T(), []string{"rust tips", "golang tips", "golang"}, search(url.Values{"sort": {"-topic"}}))
+	assert.Equal(suite.T(), []string{"rust tips", "golang", "golang tips"},
+		search(url.Values{"sort": {"-lastModifiedDate"}}))
+
+	for _, query := range []string{"name=david", "userId=notAUuid", "since=yesterday", "until=2020-01-01",
+		"since=2020-01-02T00:00:00Z&until=2020-01-01T00:00:00Z", "sort=title", "sort=topic,-topic",
+		"topic=a&topic=b"} {
+		response := PerformRequest(router, http.MethodGet, "/blogPosts?"+query, "", header)
+		assert.Equal(suite.T(), http.StatusBadRequest, response.Code, query)
+	}
+}
+
+func (suite *RestImplTestSuite) TestSortBlogPostsPagination() {
+	router := suite.newRouter(suite.Store, WithMaxPageSize(2))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	userResponse := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, userResponse.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(userResponse.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	//Duplicated topics exercise the id tiebreaker across pages
+	for _, topic := range []string{"b", "a", "c", "b", "a"} {
+		postBody := restimpl.BlogPost{UserId: blogUserResp.Id, Topic: topic, Content: "Content"}
+		response := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), postBody, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	}
+
+	var topics []string
+	var token string
+	path := "/blogPosts?sort=-topic"
+	for path != "" {
+		response := PerformRequest(router, http.MethodGet, path, "", header)
+		assert.Equal(suite.T(), http.StatusOK, response.Code)
+		page := restimpl.BlogPostPage{}
+		err = json.Unmarshal(response.Body.Bytes(), &page)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		for _, post := range page.Items {
+			topics = append(topics, post.Topic)
+		}
+
+		path = ""
+		if page.NextPageToken != "" {
+			token = page.NextPageToken
+			link := response.Header().Get("Link")
+			path = link[1:strings.Index(link, ">")]
+		}
+	}
+	assert.Equal(suite.T(), []string{"c", "b", "b", "a", "a"}, topics)
+
+	//A token only resumes the sort order it was issued for
+	response := PerformRequest(router, http.MethodGet, "/blogPosts?sort=topic&pageToken="+token, "", header)
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestTextSearchBlogPosts() {
+	router := suite.newRouter(suite.Store, WithMaxPageSize(2))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	userResponse := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, userResponse.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(userResponse.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	posts := []restimpl.BlogPost{
+		{UserId: blogUserResp.Id, Topic: "Golang error handling", Content: "Errors are values, wrap them with %w"},
+		{UserId: blogUserResp.Id, Topic: "Java", Content: "Checked exceptions versus golang <errors>"},
+		{UserId: blogUserResp.Id, Topic: "Rust", Content: strings.Repeat("padding ", 40) + "error handling with Result"},
+	}
+	for _, post := range posts {
+		response := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	}
+
+	search := func(query url.Values) restimpl.BlogPostSearchPage {
+		response := PerformRequest(router, http.MethodGet, "/blogPosts/search?"+query.Encode(), "", header)
+		assert.Equal(suite.T(), http.StatusOK, response.Code, query.Encode())
+		var page restimpl.BlogPostSearchPage
+		err := json.Unmarshal(response.Body.Bytes(), &page)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		return page
+	}
+	topics := func(page restimpl.BlogPostSearchPage) []string {
+		var topics []string
+		for _, hit := range page.Items {
+			topics = append(topics, hit.Post.Topic)
+		}
+		return topics
+	}
+
+	//A topic match ranks above a content match
+	page := search(url.Values{"q": {"golang"}})
+	assert.Equal(suite.T(), []string{"Golang error handling", "Java"}, topics(page))
+	assert.Greater(suite.T(), page.Items[0].Score, page.Items[1].Score)
+	assert.Equal(suite.T(), "<em>Golang</em> error handling", page.Items[0].Highlights.Topic)
+	assert.Empty(suite.T(), page.Items[1].Highlights.Topic)
+	assert.Equal(suite.T(), "Checked exceptions versus <em>golang</em> &lt;errors&gt;", page.Items[1].Highlights.Content)
+	assert.Empty(suite.T(), page.NextPageToken)
+
+	assert.ElementsMatch(suite.T(), []string{"Golang error handling", "Rust"},
+		topics(search(url.Values{"q": {`"error handling"`}})))
+	assert.Equal(suite.T(), []string{"Rust"}, topics(search(url.Values{"q": {`"error handling" -golang`}})))
+	assert.Equal(suite.T(), []string{"Golang error handling"}, topics(search(url.Values{"q": {`golang -"checked exceptions"`}})))
+	assert.Empty(suite.T(), search(url.Values{"q": {"python"}}).Items)
+
+	//Long contents are cut down to a snippet around the first match
+	page = search(url.Values{"q": {"result"}})
+	assert.Len(suite.T(), page.Items, 1)
+	snippet := page.Items[0].Highlights.Content
+	assert.True(suite.T(), strings.HasPrefix(snippet, "…padding"), snippet)
+	assert.True(suite.T(), strings.HasSuffix(snippet, "<em>Result</em>"), snippet)
+	assert.Less(suite.T(), len(snippet), len(posts[2].Content))
+
+	//Ranked hits are paged through
+	seen := map[string]bool{}
+	var scores []float64
+	path := "/blogPosts/search?q=" + url.QueryEscape("error handling golang")
+	for path != "" {
+		response := PerformRequest(router, http.MethodGet, path, "", header)
+		assert.Equal(suite.T(), http.StatusOK, response.Code)
+		page = restimpl.BlogPostSearchPage{}
+		err = json.Unmarshal(response.Body.Bytes(), &page)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		for _, hit := range page.Items {
+			assert.False(suite.T(), seen[hit.Post.Id])
+			seen[hit.Post.Id] = true
+			scores = append(scores, hit.Score)
+		}
+		path = ""
+		if page.NextPageToken != "" {
+			link := response.Header().Get("Link")
+			path = link[1:strings.Index(link, ">")]
+		}
+	}
+	assert.Len(suite.T(), seen, 3)
+	for i := 1; i < len(scores); i++ {
+		assert.GreaterOrEqual(suite.T(), scores[i-1], scores[i])
+	}
+
+	response := PerformRequest(router, http.MethodGet, "/blogPosts?pageSize=1", "", header)
+	var postPage restimpl.BlogPostPage
+	err = json.Unmarshal(response.Body.Bytes(), &postPage)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	for _, query := range []string{"", "q=", "q=-golang", "q=golang&userId=" + blogUserResp.Id, "q=a&q=b",
+		"q=golang&pageSize=3", "q=golang&pageToken=" + postPage.NextPageToken} {
+		response = PerformRequest(router, http.MethodGet, "/blogPosts/search?"+query, "", header)
+		assert.Equal(suite.T(), http.StatusBadRequest, response.Code, query)
+	}
+}
+
+func (suite *RestImplTestSuite) TestSearchBlogUsersPagination() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	for i := 0; i < 3; i++ {
+		user := restimpl.BlogUser{Name: "David", Email: fmt.Sprintf("david%d@abc.com", i)}
+		response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), user, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	}
+
+	response := PerformRequest(router, http.MethodGet, "/blogUsers?name=David&pageSize=2", "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	var page restimpl.BlogUserPage
+	err := json.Unmarshal(response.Body.Bytes(), &page)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Len(suite.T(), page.Items, 2)
+	assert.NotEmpty(suite.T(), page.NextPageToken)
+
+	response = PerformRequest(router, http.MethodGet, "/blogUsers?name=David&pageSize=2&pageToken="+page.NextPageToken, "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	page = restimpl.BlogUserPage{}
+	err = json.Unmarshal(response.Body.Bytes(), &page)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Len(suite.T(), page.Items, 1)
+	assert.Empty(suite.T(), page.NextPageToken)
+	assert.Empty(suite.T(), response.Header().Get("Link"))
+}
+
+func (suite *RestImplTestSuite) TestTrashAndRestore() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	userResponse := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, userResponse.Code)
+	blogUserResp := restimpl.BlogUser{}
+	err := json.Unmarshal(userResponse.Body.Bytes(), &blogUserResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	postBody := restimpl.BlogPost{UserId: blogUserResp.Id, Topic: "Topic", Content: "Content"}
+	postResponse := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), postBody, header)
+	assert.Equal(suite.T(), http.StatusCreated, postResponse.Code)
+	blogPostResp := restimpl.BlogPost{}
+	err = json.Unmarshal(postResponse.Body.Bytes(), &blogPostResp)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	//Deleted posts are moved to the trash
+	response := PerformRequest(router, http.MethodPost, getBlogPostUrl(blogPostResp.Id)+"/restore", "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+	response = PerformRequest(router, http.MethodDelete, getBlogPostUrl(blogPostResp.Id), "", header)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(blogPostResp.Id), "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+
+	response = PerformRequest(router, http.MethodGet, "/trash", "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	var trash restimpl.Trash
+	err = json.Unmarshal(response.Body.Bytes(), &trash)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Empty(suite.T(), trash.BlogUsers)
+	if assert.Len(suite.T(), trash.BlogPosts, 1) {
+		assert.Equal(suite.T(), blogPostResp.Id, trash.BlogPosts[0].Id)
+		assert.NotNil(suite.T(), trash.BlogPosts[0].DeletedAt)
+	}
+
+	response = PerformRequest(router, http.MethodPost, getBlogPostUrl(blogPostResp.Id)+"/restore", "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.Equal(suite.T(), `"3"`, response.Header().Get("ETag"))
+	restored := restimpl.BlogPost{}
+	err = json.Unmarshal(response.Body.Bytes(), &restored)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.Nil(suite.T(), restored.DeletedAt)
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(blogPostResp.Id), "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	//A post can not be restored while its user is in the trash, restoring the user brings it back
+	response = PerformRequest(router, http.MethodDelete, getBlogUserUrl(blogUserResp.Id)+"?onPosts=cascade", "", header)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	response = PerformRequest(router, http.MethodPost, getBlogPostUrl(blogPostResp.Id)+"/restore", "", header)
+	assert.Equal(suite.T(), http.StatusConflict, response.Code)
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(blogUserResp.Id)+"/restore", "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(blogPostResp.Id), "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(blogUserResp.Id)+"/restore", "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl("notAUuid")+"/restore", "", header)
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestDatabaseUnavailable() {
+	health := store.Health{State: store.ConnectionDown, Breaker: store.BreakerOpen, RetryAfter: 1500 * time.Millisecond}
+	router := suite.newRouter(suite.Store.WithHealth(func() store.Health { return health }))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	//Requests fail fast while the database is unavailable
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, response.Code)
+	assert.Equal(suite.T(), "2", response.Header().Get("Retry-After"))
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(""), "", header)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, response.Code)
+
+	health = store.Health{State: store.ConnectionUp, Breaker: store.BreakerClosed}
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestBreakerTrial() {
+	breaker := store.NewBreaker(1, 20*time.Millisecond)
+	router := suite.newRouter(suite.Store.WithHealth(func() store.Health {
+		state, retryAfter := breaker.State()
+		return store.Health{State: store.ConnectionUp, Breaker: state, RetryAfter: retryAfter}
+	}))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	//The breaker opened by failed operations fails the requests fast until its cooldown is over
+	breaker.Failure()
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, response.Code)
+
+	//Then a request gets through as the trial, without waiting for the connection check
+	time.Sleep(30 * time.Millisecond)
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestProbes() {
+	lifecycle := &Lifecycle{}
+	down := store.Health{State: store.ConnectionDown, Breaker: store.BreakerOpen}
+	router := suite.newRouter(suite.Store.WithHealth(func() store.Health { return down }), WithLifecycle(lifecycle))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	probe := func(path string, status int) restimpl.Health {
+		response := PerformRequest(router, http.MethodGet, path, "", header)
+		assert.Equal(suite.T(), status, response.Code, path)
+		var health restimpl.Health
+		err := json.Unmarshal(response.Body.Bytes(), &health)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		return health
+	}
+
+	//The probes answer even when the apis fail fast
+	assert.Equal(suite.T(), "pass", probe("/healthz", http.StatusOK).Status)
+	health := probe("/readyz", http.StatusOK)
+	assert.Equal(suite.T(), "pass", health.Status)
+	var names []string
+	for _, check := range health.Checks {
+		names = append(names, check.Name)
+		assert.Equal(suite.T(), "pass", check.Status)
+		assert.GreaterOrEqual(suite.T(), check.LatencyMs, float64(0))
+	}
+	assert.Equal(suite.T(), []string{"database", "migrations", "shutdown"}, names)
+
+	lifecycle.ShutDown()
+	health = probe("/readyz", http.StatusServiceUnavailable)
+	assert.Equal(suite.T(), "fail", health.Status)
+	assert.Equal(suite.T(), "fail", health.Checks[2].Status)
+	assert.NotEmpty(suite.T(), health.Checks[2].Error)
+	assert.Equal(suite.T(), "pass", probe("/healthz", http.StatusOK).Status)
+
+	//An unreachable database fails the readiness
+	closed, err := store.OpenSqlite(":memory:")
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), closed.Close())
+	router = suite.newRouter(closed)
+	response := PerformRequest(router, http.MethodGet, "/readyz", "", header)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), `"name":"database","status":"fail"`)
+}
+
+func (suite *RestImplTestSuite) TestMetrics() {
+	metrics := NewMetrics()
+	up := true
+	health := func() store.Health {
+		if up {
+			return store.Health{State: store.ConnectionUp, Breaker: store.BreakerClosed}
+		}
+		return store.Health{State: store.ConnectionDown, Breaker: store.BreakerOpen}
+	}
+	router := suite.newRouter(suite.Store.Observe(metrics.ObserveOperation).WithHealth(health), WithMetrics(metrics))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogUserUrl(uuid.NewV4().String()), "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+	response = PerformRequest(router, http.MethodGet, "/unknown", "", header)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+	up = false
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(""), "", header)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, response.Code)
+
+	//Only the operations failing to run are counted as errors
+	_, done := metrics.ObserveOperation(context.Background(), "posts", "Search")
+	done(store.ErrUnavailable)
+
+	//The metrics are served while the database is unavailable
+	response = PerformRequest(router, http.MethodGet, "/metrics", "", header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	body := response.Body.String()
+	for _, metric := range []string{
+		`blog_http_request_duration_seconds_count{route="AddBlogUsers",status="201"} 1`,
+		`blog_http_request_duration_seconds_count{route="GetblogUsers",status="404"} 1`,
+		`blog_http_request_duration_seconds_count{route="Unmatched",status="404"} 1`,
+		`blog_http_request_duration_seconds_count{route="SearchblogPosts",status="503"} 1`,
+		`blog_db_operation_duration_seconds_count{operation="Insert",outcome="ok",repository="users"} 1`,
+		`blog_db_operation_duration_seconds_count{operation="GetById",outcome="not_found",repository="users"} 1`,
+		`blog_db_operation_errors_total{operation="Search",outcome="unavailable",repository="posts"} 1`,
+		`go_goroutines`,
+	} {
+		assert.Contains(suite.T(), body, metric)
+	}
+	assert.NotContains(suite.T(), body, `blog_db_operation_errors_total{operation="GetById"`)
+	assert.NotContains(suite.T(), body, `route="Healthz"`)
+}
+
+func (suite *RestImplTestSuite) TestTracing() {
+	_, err := utils.InitializeTracing(utils.TracingConfig{Exporter: utils.TracingNone})
+	assert.Nil(suite.T(), err)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	logs := new(logtest.Hook)
+	logrus.AddHook(logs)
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	router := suite.newRouter(suite.Store.Observe(TraceOperation))
+	traceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	header := map[string]string{"Content-Type": "application/json",
+		"traceparent": "00-" + traceId + "-00f067aa0ba902b7-01"}
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+
+	//The spans of the route, its handler and the store operation are nested in the trace of the caller
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(suite.T(), traceId, span.SpanContext().TraceID().String(), span.Name())
+		spans[span.Name()] = span
+	}
+	server, handler, insert := spans["AddBlogUsers"], spans["handler"], spans["users.Insert"]
+	if assert.NotNil(suite.T(), server) && assert.NotNil(suite.T(), handler) && assert.NotNil(suite.T(), insert) {
+		assert.Equal(suite.T(), "00f067aa0ba902b7", server.Parent().SpanID().String())
+		assert.Equal(suite.T(), trace.SpanKindServer, server.SpanKind())
+		assert.Equal(suite.T(), server.SpanContext().SpanID(), handler.Parent().SpanID())
+		assert.Equal(suite.T(), handler.SpanContext().SpanID(), insert.Parent().SpanID())
+		assert.Equal(suite.T(), trace.SpanKindClient, insert.SpanKind())
+	}
+
+	//The logs of the handler carry the ids of its span
+	traced := 0
+	for _, entry := range logs.AllEntries() {
+		if entry.Data["traceId"] == traceId {
+			traced++
+			assert.Equal(suite.T(), handler.SpanContext().SpanID().String(), entry.Data["spanId"])
+		}
+	}
+	assert.NotZero(suite.T(), traced)
+}
+
+func (suite *RestImplTestSuite) TestRequestId() {
+	logs := new(logtest.Hook)
+	logrus.AddHook(logs)
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	router := suite.newRouter(suite.Store)
+
+	//A valid id of the caller is kept, an invalid one is replaced
+	for requestId, kept := range map[string]bool{
+		"":                         false,
+		"checkout-42":              true,
+		"bad id\r\nX-Injected: 1":  false,
+		strings.Repeat("a", 129):   false,
+		"4bf92f35-77b3-4da6-a3ce":  true,
+		"abc.DEF_123:tenant/1+2==": true,
+	} {
+		header := map[string]string{"Content-Type": "application/json", RequestIdHeader: requestId}
+		response := PerformRequest(router, http.MethodGet, getBlogUserUrl(uuid.NewV4().String()), "", header)
+		returned := response.Header().Get(RequestIdHeader)
+		if kept {
+			assert.Equal(suite.T(), requestId, returned)
+		} else {
+			_, err := uuid.FromString(returned)
+			assert.Nil(suite.T(), err, requestId)
+		}
+	}
+
+	//Every log line of the request carries its id
+	logs.Reset()
+	header := map[string]string{"Content-Type": "application/json", RequestIdHeader: "trace-me"}
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	var requestLogs []*logrus.Entry
+	for _, entry := range logs.AllEntries() {
+		if entry.Data["requestId"] == "trace-me" {
+			requestLogs = append(requestLogs, entry)
+		}
+	}
+	if assert.NotEmpty(suite.T(), requestLogs) {
+		assert.Equal(suite.T(), "AddBlogUsers", requestLogs[0].Data["route"])
+		assert.Contains(suite.T(), requestLogs[0].Data, "clientIp")
+		assert.Equal(suite.T(), http.MethodPost, requestLogs[0].Data["Method"])
+	}
+}
+
+func (suite *RestImplTestSuite) TestProblems() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json", RequestIdHeader: "problem-1"}
+
+	problem := func(response *httptest.ResponseRecorder, status int, code ErrorCode) restimpl.Problem {
+		assert.Equal(suite.T(), status, response.Code)
+		assert.Equal(suite.T(), problemContentType, response.Header().Get("Content-Type"))
+		var problem restimpl.Problem
+		err := json.Unmarshal(response.Body.Bytes(), &problem)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		assert.Equal(suite.T(), status, problem.Status)
+		assert.Equal(suite.T(), string(code), problem.Code)
+		assert.Equal(suite.T(), problemTypePrefix+string(code), problem.Type)
+		assert.Equal(suite.T(), "problem-1", problem.RequestId)
+		return problem
+	}
+
+	//The invalid fields are listed by their json name
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), restimpl.BlogUser{Name: "Invalid"}, header)
+	details := problem(response, http.StatusBadRequest, CodeValidationFailed)
+	assert.Equal(suite.T(), []restimpl.FieldError{{Field: "email", Code: "required", Message: "is required"}},
+		details.Errors)
+	assert.Equal(suite.T(), "/blogUsers", details.Instance)
+
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	var created restimpl.BlogUser
+	err := json.Unmarshal(response.Body.Bytes(), &created)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser, header)
+	details = problem(response, http.StatusConflict, CodeEmailTaken)
+	assert.Equal(suite.T(), "email", details.Errors[0].Field)
+
+	//The messages of the patch library are not sent to the client
+	response = PerformRequest(router, http.MethodPatch, getBlogUserUrl(created.Id),
+		json.RawMessage(`[{"op": "test", "path": "/name", "value": "Other"}]`),
+		map[string]string{"Content-Type": "application/json-patch+json", RequestIdHeader: "problem-1"})
+	details = problem(response, http.StatusBadRequest, CodeInvalidPatch)
+	assert.Equal(suite.T(), "The patch can not be applied", details.Detail)
+
+	response = PerformRequest(router, http.MethodGet, getBlogUserUrl("12345"), "", header)
+	details = problem(response, http.StatusBadRequest, CodeInvalidParameter)
+	assert.Equal(suite.T(), "id", details.Errors[0].Field)
+
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl("")+"?sort=title", "", header)
+	details = problem(response, http.StatusBadRequest, CodeInvalidParameter)
+	assert.Equal(suite.T(), "sort", details.Errors[0].Field)
+
+	post := restimpl.BlogPost{UserId: uuid.NewV4().String(), Topic: "Orphan", Content: "No user"}
+	response = PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, header)
+	details = problem(response, http.StatusBadRequest, CodeValidationFailed)
+	assert.Equal(suite.T(), "userId", details.Errors[0].Field)
+
+	response = PerformRequest(router, http.MethodGet, getBlogUserUrl(uuid.NewV4().String()), "", header)
+	problem(response, http.StatusNotFound, CodeNotFound)
+	response = PerformRequest(router, http.MethodGet, "/unknown", "", header)
+	problem(response, http.StatusNotFound, CodeNotFound)
+
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""), suite.MockUser,
+		map[string]string{"Content-Type": "text/plain", RequestIdHeader: "problem-1"})
+	problem(response, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType)
+
+	//The unavailable database is a problem as well
+	down := store.Health{State: store.ConnectionDown, Breaker: store.BreakerOpen}
+	router = suite.newRouter(suite.Store.WithHealth(func() store.Health { return down }))
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(""), "", header)
+	problem(response, http.StatusServiceUnavailable, CodeServiceUnavailable)
+	assert.Equal(suite.T(), "1", response.Header().Get("Retry-After"))
+}
+
+func (suite *RestImplTestSuite) TestDeleteHasNoBody() {
+	router := suite.newRouter(suite.Store)
+	header := map[string]string{"Content-Type": "application/json"}
+
+	response := PerformRequest(router, http.MethodDelete, getBlogUserUrl(uuid.NewV4().String()), "", header)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	assert.Empty(suite.T(), response.Body.String())
+	response = PerformRequest(router, http.MethodDelete, getBlogPostUrl(uuid.NewV4().String()), "", header)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	assert.Empty(suite.T(), response.Body.String())
+}
+
+func (suite *RestImplTestSuite) TestLogin() {
+	logs := new(logtest.Hook)
+	logrus.AddHook(logs)
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	router := NewRouter(suite.Store, WithAuth(suite.Auth))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	user := restimpl.BlogUser{Name: "David", Email: "david@abc.com", Password: "correct horse"}
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), user, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	assert.NotContains(suite.T(), response.Body.String(), "correct horse")
+	assert.NotContains(suite.T(), strings.ToLower(response.Body.String()), "password")
+	var created restimpl.BlogUser
+	err := json.Unmarshal(response.Body.Bytes(), &created)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	login := func(email, password string) (restimpl.TokenPair, int) {
+		response := PerformRequest(router, http.MethodPost, "/auth/login",
+			restimpl.Credentials{Email: email, Password: password}, header)
+		var tokens restimpl.TokenPair
+		if response.Code == http.StatusOK {
+			assert.Equal(suite.T(), "no-store", response.Header().Get("Cache-Control"))
+			err := json.Unmarshal(response.Body.Bytes(), &tokens)
+			if err != nil {
+				log.Fatalf("Unmarshall Error %v", err)
+			}
+		}
+		return tokens, response.Code
+	}
+
+	//Unknown emails and wrong passwords are rejected alike
+	_, status := login("david@abc.com", "wrong horse")
+	assert.Equal(suite.T(), http.StatusUnauthorized, status)
+	_, status = login("nobody@abc.com", "correct horse")
+	assert.Equal(suite.T(), http.StatusUnauthorized, status)
+	tokens, status := login("david@abc.com", "correct horse")
+	assert.Equal(suite.T(), http.StatusOK, status)
+	assert.Equal(suite.T(), "Bearer", tokens.TokenType)
+	assert.Equal(suite.T(), int64(900), tokens.ExpiresIn)
+
+	//The writes need an access token
+	update := restimpl.BlogUser{Name: "Dave", Email: "david@abc.com"}
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id), update, header)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	assert.Equal(suite.T(), `Bearer realm="blogApp"`, response.Header().Get("WWW-Authenticate"))
+	assert.Contains(suite.T(), response.Body.String(), string(CodeUnauthenticated))
+
+	for _, token := range []string{tokens.RefreshToken, tokens.AccessToken + "x", "garbage"} {
+		header["Authorization"] = "Bearer " + token
+		response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id), update, header)
+		assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+		assert.Contains(suite.T(), response.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	}
+
+	logs.Reset()
+	header["Authorization"] = "Bearer " + tokens.AccessToken
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id), update, header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	var userLogs int
+	for _, entry := range logs.AllEntries() {
+		if entry.Data["user"] == created.Id {
+			userLogs++
+		}
+	}
+	assert.NotZero(suite.T(), userLogs)
+
+	//An update without password keeps the password, an update with one changes it
+	_, status = login("david@abc.com", "correct horse")
+	assert.Equal(suite.T(), http.StatusOK, status)
+	update.Password = "battery staple"
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id), update, header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	_, status = login("david@abc.com", "correct horse")
+	assert.Equal(suite.T(), http.StatusUnauthorized, status)
+	_, status = login("david@abc.com", "battery staple")
+	assert.Equal(suite.T(), http.StatusOK, status)
+
+	update.Password = "short"
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id), update, header)
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), `"field":"password"`)
+}
+
+func (suite *RestImplTestSuite) TestRefresh() {
+	router := NewRouter(suite.Store, WithAuth(suite.Auth))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	user := restimpl.BlogUser{Name: "David", Email: "david@abc.com", Password: "correct horse"}
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), user, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	response = PerformRequest(router, http.MethodPost, "/auth/login",
+		restimpl.Credentials{Email: user.Email, Password: user.Password}, header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	var tokens restimpl.TokenPair
+	err := json.Unmarshal(response.Body.Bytes(), &tokens)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	//Only a refresh token can be refreshed
+	response = PerformRequest(router, http.MethodPost, "/auth/refresh",
+		restimpl.RefreshRequest{RefreshToken: tokens.AccessToken}, header)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	response = PerformRequest(router, http.MethodPost, "/auth/refresh",
+		restimpl.RefreshRequest{RefreshToken: tokens.RefreshToken}, header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	var refreshed restimpl.TokenPair
+	err = json.Unmarshal(response.Body.Bytes(), &refreshed)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assert.NotEqual(suite.T(), tokens.AccessToken, refreshed.AccessToken)
+
+	//A refresh token is used once
+	response = PerformRequest(router, http.MethodPost, "/auth/refresh",
+		restimpl.RefreshRequest{RefreshToken: tokens.RefreshToken}, header)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), string(CodeInvalidToken))
+
+	//A new password revokes the refresh tokens issued before, the next login issues working ones
+	created, err := suite.Store.Users.GetByEmail(context.Background(), user.Email)
+	assert.Nil(suite.T(), err)
+	authHeader := map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + refreshed.AccessToken}
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id),
+		restimpl.BlogUser{Name: "David", Email: user.Email, Password: "battery staple"}, authHeader)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	response = PerformRequest(router, http.MethodPost, "/auth/refresh",
+		restimpl.RefreshRequest{RefreshToken: refreshed.RefreshToken}, header)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	response = PerformRequest(router, http.MethodPost, "/auth/login",
+		restimpl.Credentials{Email: user.Email, Password: "battery staple"}, header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	err = json.Unmarshal(response.Body.Bytes(), &tokens)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	response = PerformRequest(router, http.MethodPost, "/auth/refresh",
+		restimpl.RefreshRequest{RefreshToken: tokens.RefreshToken}, header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	err = json.Unmarshal(response.Body.Bytes(), &refreshed)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	//An update keeping the password and the email keeps the refresh tokens
+	authHeader["Authorization"] = "Bearer " + refreshed.AccessToken
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id),
+		restimpl.BlogUser{Name: "Dave", Email: user.Email}, authHeader)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	response = PerformRequest(router, http.MethodPost, "/auth/refresh",
+		restimpl.RefreshRequest{RefreshToken: refreshed.RefreshToken}, header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	err = json.Unmarshal(response.Body.Bytes(), &refreshed)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+
+	//The refresh tokens of a deleted user are rejected
+	header["Authorization"] = "Bearer " + refreshed.AccessToken
+	response = PerformRequest(router, http.MethodDelete, getBlogUserUrl(created.Id), "", header)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	response = PerformRequest(router, http.MethodPost, "/auth/refresh",
+		restimpl.RefreshRequest{RefreshToken: refreshed.RefreshToken}, header)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestAuthorization() {
+	router := NewRouter(suite.Store, WithAuth(suite.Auth))
+	as := func(caller Principal) map[string]string {
+		header := map[string]string{"Content-Type": "application/json"}
+		if caller.UserId != "" {
+			tokens, err := suite.Auth.issue(caller, 0)
+			if err != nil {
+				log.Fatalf("Token signing failed %v", err)
+			}
+			header["Authorization"] = "Bearer " + tokens.AccessToken
+		}
+		return header
+	}
+	admin := as(Principal{UserId: uuid.NewV4().String(), Role: RoleAdmin})
+	assertForbidden := func(response *httptest.ResponseRecorder) {
+		assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+		assert.Equal(suite.T(), problemContentType, response.Header().Get("Content-Type"))
+		assert.Contains(suite.T(), response.Body.String(), `"code":"forbidden"`)
+	}
+	createUser := func(user restimpl.BlogUser, header map[string]string) restimpl.BlogUser {
+		response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""), user, header)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+		var created restimpl.BlogUser
+		err := json.Unmarshal(response.Body.Bytes(), &created)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		return created
+	}
+
+	//The users signing up are authors, only an admin gives another role
+	alice := createUser(restimpl.BlogUser{Name: "Alice", Email: "alice@abc.com"}, as(Principal{}))
+	assert.Equal(suite.T(), string(RoleAuthor), alice.Role)
+	assertForbidden(PerformRequest(router, http.MethodPost, getBlogUserUrl(""),
+		restimpl.BlogUser{Name: "Mallory", Email: "mallory@abc.com", Role: string(RoleAdmin)}, as(Principal{})))
+	bob := createUser(restimpl.BlogUser{Name: "Bob", Email: "bob@abc.com", Role: string(RoleReader)}, admin)
+	assert.Equal(suite.T(), string(RoleReader), bob.Role)
+	asAlice := as(Principal{UserId: alice.Id, Role: RoleAuthor})
+	asBob := as(Principal{UserId: bob.Id, Role: RoleReader})
+	asEditor := as(Principal{UserId: uuid.NewV4().String(), Role: RoleEditor})
+
+	//An author writes their own posts only, a reader none
+	post := restimpl.BlogPost{UserId: alice.Id, Topic: "Topic", Content: "Content"}
+	response := PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, asAlice)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	var created restimpl.BlogPost
+	err := json.Unmarshal(response.Body.Bytes(), &created)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	assertForbidden(PerformRequest(router, http.MethodPost, getBlogPostUrl(""),
+		restimpl.BlogPost{UserId: bob.Id, Topic: "Topic", Content: "Content"}, asAlice))
+	assertForbidden(PerformRequest(router, http.MethodPost, getBlogPostUrl(""),
+		restimpl.BlogPost{UserId: bob.Id, Topic: "Topic", Content: "Content"}, asBob))
+
+	//The owner and the admins update a post, the owner can not give it away
+	path := getBlogPostUrl(created.Id)
+	post.Topic = "Updated"
+	assertForbidden(PerformRequest(router, http.MethodPut, path, post, asBob))
+	response = PerformRequest(router, http.MethodPut, path, post, asAlice)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assertForbidden(PerformRequest(router, http.MethodPut, path,
+		restimpl.BlogPost{UserId: bob.Id, Topic: "Topic", Content: "Content"}, asAlice))
+	response = PerformRequest(router, http.MethodPut, path, post, admin)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assertForbidden(PerformRequest(router, http.MethodDelete, path, "", asBob))
+
+	//An editor does not change the posts of another author
+	assertForbidden(PerformRequest(router, http.MethodPut, path, post, asEditor))
+	patchHeader := map[string]string{"Content-Type": "application/merge-patch+json",
+		"Authorization": asEditor["Authorization"]}
+	assertForbidden(PerformRequest(router, http.MethodPatch, path, map[string]string{"topic": "Edited"},
+		patchHeader))
+	assertForbidden(PerformRequest(router, http.MethodDelete, path, "", asEditor))
+
+	//The trash is for the editors
+	assertForbidden(PerformRequest(router, http.MethodGet, "/trash", "", asAlice))
+	response = PerformRequest(router, http.MethodDelete, path, "", asAlice)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	response = PerformRequest(router, http.MethodGet, "/trash", "", asEditor)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	//A user updates and deletes itself only, and can not change its own role
+	update := restimpl.BlogUser{Name: "Robert", Email: bob.Email}
+	assertForbidden(PerformRequest(router, http.MethodPut, getBlogUserUrl(alice.Id), update, asBob))
+	assertForbidden(PerformRequest(router, http.MethodDelete, getBlogUserUrl(alice.Id), "", asBob))
+	assertForbidden(PerformRequest(router, http.MethodPut, getBlogUserUrl(bob.Id), update, asEditor))
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(bob.Id), update, asBob)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	update.Role = string(RoleAdmin)
+	assertForbidden(PerformRequest(router, http.MethodPut, getBlogUserUrl(bob.Id), update, asBob))
+	update.Role = string(RoleReader)
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(bob.Id), update, asBob)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	//An admin changes the roles, they apply from the next token
+	update.Role = string(RoleEditor)
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(bob.Id), update, admin)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), `"role":"editor"`)
+	update.Role = ""
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(bob.Id), update, asBob)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), `"role":"editor"`)
+	response = PerformRequest(router, http.MethodDelete, getBlogUserUrl(bob.Id), "", asBob)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+}
+
+func (suite *RestImplTestSuite) TestApiKeys() {
+	router := NewRouter(suite.Store, WithAuth(suite.Auth))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	response := PerformRequest(router, http.MethodPost, getBlogUserUrl(""),
+		restimpl.BlogUser{Name: "Alice", Email: "alice@abc.com"}, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	var alice restimpl.BlogUser
+	err := json.Unmarshal(response.Body.Bytes(), &alice)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	tokens, err := suite.Auth.issue(Principal{UserId: alice.Id, Role: RoleAuthor}, 0)
+	if err != nil {
+		log.Fatalf("Token signing failed %v", err)
+	}
+	withToken := map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + tokens.AccessToken}
+	withKey := func(key string) map[string]string {
+		return map[string]string{"Content-Type": "application/json", "Authorization": "ApiKey " + key}
+	}
+	keysPath := getBlogUserUrl(alice.Id) + "/apiKeys"
+	createKey := func(apiKey restimpl.ApiKey) restimpl.ApiKey {
+		response := PerformRequest(router, http.MethodPost, keysPath, apiKey, withToken)
+		assert.Equal(suite.T(), http.StatusCreated, response.Code)
+		assert.Equal(suite.T(), "no-store", response.Header().Get("Cache-Control"))
+		var created restimpl.ApiKey
+		err := json.Unmarshal(response.Body.Bytes(), &created)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		return created
+	}
+
+	//The key is only returned on creation, its prefix identifies it
+	reader := createKey(restimpl.ApiKey{Name: "ci", Scopes: []string{"read"}})
+	assert.True(suite.T(), strings.HasPrefix(reader.Key, reader.Prefix+"_"))
+	assert.True(suite.T(), strings.HasPrefix(reader.Prefix, "blog_"))
+	assert.Equal(suite.T(), alice.Id, reader.UserId)
+	writer := createKey(restimpl.ApiKey{Name: "importer", Scopes: []string{"write", "write"}})
+	assert.Equal(suite.T(), []string{"write"}, writer.Scopes)
+
+	past := time.Now().Add(-time.Hour)
+	for _, invalid := range []restimpl.ApiKey{{Name: "ci", Scopes: []string{"admin"}}, {Name: "ci"},
+		{Name: "ci", Scopes: []string{"read"}, ExpiresAt: &past}} {
+		response = PerformRequest(router, http.MethodPost, keysPath, invalid, withToken)
+		assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+	}
+	response = PerformRequest(router, http.MethodPost, keysPath, restimpl.ApiKey{Name: "ci", Scopes: []string{}},
+		withToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Code)
+	assert.Contains(suite.T(), response.Body.String(),
+		`{"field":"scopes","code":"min","message":"must have at least 1 items"}`)
+
+	//The keys act for their user within their scopes
+	post := restimpl.BlogPost{UserId: alice.Id, Topic: "Topic", Content: "Content"}
+	response = PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, withKey(writer.Key))
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	response = PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, withKey(reader.Key))
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+	response = PerformRequest(router, http.MethodGet, getBlogPostUrl(""), "", withKey(reader.Key))
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	//The keys do not manage the keys
+	response = PerformRequest(router, http.MethodGet, keysPath, "", withKey(reader.Key))
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+	response = PerformRequest(router, http.MethodPost, keysPath, restimpl.ApiKey{Name: "ci", Scopes: []string{"read"}},
+		withKey(writer.Key))
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+
+	for _, key := range []string{writer.Key + "x", "blog_unknown_secret", "garbage"} {
+		response = PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, withKey(key))
+		assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+		assert.Equal(suite.T(), `ApiKey realm="blogApp"`, response.Header().Get("WWW-Authenticate"))
+		assert.Contains(suite.T(), response.Body.String(), string(CodeInvalidApiKey))
+	}
+
+	//The list has the last uses but never the keys
+	response = PerformRequest(router, http.MethodGet, keysPath, "", withToken)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	assert.NotContains(suite.T(), response.Body.String(), reader.Key)
+	assert.NotContains(suite.T(), response.Body.String(), writer.Key)
+	var list restimpl.ApiKeyList
+	err = json.Unmarshal(response.Body.Bytes(), &list)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	if assert.Len(suite.T(), list.Items, 2) {
+		assert.Equal(suite.T(), reader.Id, list.Items[0].Id)
+		assert.NotNil(suite.T(), list.Items[1].LastUsedAt)
+	}
+
+	//Another user can not see the keys
+	other, err := suite.Auth.issue(Principal{UserId: uuid.NewV4().String(), Role: RoleEditor}, 0)
+	if err != nil {
+		log.Fatalf("Token signing failed %v", err)
+	}
+	response = PerformRequest(router, http.MethodGet, keysPath, "",
+		map[string]string{"Authorization": "Bearer " + other.AccessToken})
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+
+	//A revoked key and an expired key are rejected
+	response = PerformRequest(router, http.MethodDelete, keysPath+"/"+writer.Id, "", withToken)
+	assert.Equal(suite.T(), http.StatusNoContent, response.Code)
+	response = PerformRequest(router, http.MethodDelete, keysPath+"/"+writer.Id, "", withToken)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+	response = PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, withKey(writer.Key))
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+
+	key, prefix, hash, err := generateApiKey()
+	assert.Nil(suite.T(), err)
+	err = suite.Store.ApiKeys.Insert(context.Background(), restimpl.ApiKey{Id: uuid.NewV4().String(), UserId: alice.Id,
+		Name: "expired", Prefix: prefix, KeyHash: hash, Scopes: []string{"write"}, ExpiresAt: &past,
+		CreatedAt: past.Add(-time.Hour)})
+	assert.Nil(suite.T(), err)
+	response = PerformRequest(router, http.MethodPost, getBlogPostUrl(""), post, withKey(key))
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	//A read key of an admin can not use the anonymous writes to give roles
+	admin := restimpl.BlogUser{Id: uuid.NewV4().String(), Name: "Root", Email: "root@abc.com",
+		Role: string(RoleAdmin), Version: 1}
+	err = suite.Store.Users.Insert(context.Background(), admin)
+	assert.Nil(suite.T(), err)
+	adminTokens, err := suite.Auth.issue(Principal{UserId: admin.Id, Role: RoleAdmin}, 0)
+	if err != nil {
+		log.Fatalf("Token signing failed %v", err)
+	}
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(admin.Id)+"/apiKeys",
+		restimpl.ApiKey{Name: "audit", Scopes: []string{"read"}},
+		map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + adminTokens.AccessToken})
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	var adminKey restimpl.ApiKey
+	err = json.Unmarshal(response.Body.Bytes(), &adminKey)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""),
+		restimpl.BlogUser{Name: "Mallory", Email: "mallory@abc.com", Role: string(RoleAdmin)}, withKey(adminKey.Key))
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+	_, err = suite.Store.Users.GetByEmail(context.Background(), "mallory@abc.com")
+	assert.Equal(suite.T(), store.ErrNotFound, err)
+}
+
+func (suite *RestImplTestSuite) TestOidcLogin() {
+	idp := newMockIdentityProvider(suite.T())
+	oidc, err := NewOidc(idp.config())
+	assert.Nil(suite.T(), err)
+	router := NewRouter(suite.Store, WithAuth(suite.Auth), WithOidc(oidc))
+	header := map[string]string{"Content-Type": "application/json"}
+
+	//Without identity provider the oidc login is not found
+	response := PerformRequest(NewRouter(suite.Store, WithAuth(suite.Auth)), http.MethodGet, "/auth/oidc/login", nil,
+		nil)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Code)
+
+	start := func() (*url.URL, *http.Cookie) {
+		response := PerformRequest(router, http.MethodGet, "/auth/oidc/login", nil, nil)
+		assert.Equal(suite.T(), http.StatusFound, response.Code)
+		authUrl, err := url.Parse(response.Header().Get("Location"))
+		assert.Nil(suite.T(), err)
+		cookies := response.Result().Cookies()
+		assert.Len(suite.T(), cookies, 1)
+		return authUrl, cookies[0]
+	}
+	callback := func(query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		headers := map[string]string{}
+		if cookie != nil {
+			headers["Cookie"] = cookie.Name + "=" + cookie.Value
+		}
+		return PerformRequest(router, http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil, headers)
+	}
+	login := func(claims jwt.MapClaims) *httptest.ResponseRecorder {
+		authUrl, cookie := start()
+		code := idp.authorize(authUrl, claims)
+		return callback(url.Values{"code": {code}, "state": {authUrl.Query().Get("state")}}, cookie)
+	}
+	loggedIn := func(response *httptest.ResponseRecorder) Principal {
+		assert.Equal(suite.T(), http.StatusOK, response.Code, response.Body.String())
+		var tokens restimpl.TokenPair
+		err := json.Unmarshal(response.Body.Bytes(), &tokens)
+		if err != nil {
+			log.Fatalf("Unmarshall Error %v", err)
+		}
+		caller, err := suite.Auth.verify(tokens.AccessToken, accessTokenUse)
+		assert.Nil(suite.T(), err)
+		return caller
+	}
+
+	//The login redirects to the provider with a PKCE challenge, its state is kept in a cookie
+	authUrl, cookie := start()
+	assert.Equal(suite.T(), idp.URL+"/authorize", authUrl.Scheme+"://"+authUrl.Host+authUrl.Path)
+	query := authUrl.Query()
+	assert.Equal(suite.T(), "code", query.Get("response_type"))
+	assert.Equal(suite.T(), mockClientId, query.Get("client_id"))
+	assert.Equal(suite.T(), mockRedirectUrl, query.Get("redirect_uri"))
+	assert.Equal(suite.T(), "openid email profile", query.Get("scope"))
+	assert.Equal(suite.T(), "S256", query.Get("code_challenge_method"))
+	assert.NotEmpty(suite.T(), query.Get("state"))
+	assert.NotEmpty(suite.T(), query.Get("nonce"))
+	assert.Equal(suite.T(), oidcStateCookie, cookie.Name)
+	assert.Equal(suite.T(), oidcCookiePath, cookie.Path)
+	assert.True(suite.T(), cookie.HttpOnly)
+	assert.Equal(suite.T(), http.SameSiteLaxMode, cookie.SameSite)
+
+	//The first login provisions the user with the default role
+	caller := loggedIn(login(jwt.MapClaims{"email": "nina@abc.com", "email_verified": true, "name": "Nina"}))
+	provisioned, err := suite.Store.Users.GetByEmail(context.Background(), "nina@abc.com")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), provisioned.Id, caller.UserId)
+	assert.Equal(suite.T(), DefaultRole, caller.Role)
+	assert.Equal(suite.T(), "Nina", provisioned.Name)
+	assert.Empty(suite.T(), provisioned.PasswordHash)
+	caller = loggedIn(login(jwt.MapClaims{"email": "nina@abc.com", "email_verified": "true"}))
+	assert.Equal(suite.T(), provisioned.Id, caller.UserId)
+
+	//A user without password, e.g. created by an admin, is linked to the verified email
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""),
+		restimpl.BlogUser{Name: "Olga", Email: "olga@abc.com"}, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	var olga restimpl.BlogUser
+	err = json.Unmarshal(response.Body.Bytes(), &olga)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	caller = loggedIn(login(jwt.MapClaims{"email": "olga@abc.com", "email_verified": true}))
+	assert.Equal(suite.T(), olga.Id, caller.UserId)
+	linked, err := suite.Store.Users.GetById(context.Background(), olga.Id)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), linked.OidcLinked)
+
+	//A user whose email an admin changes during its link is not signed in, the login is for the old email
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""),
+		restimpl.BlogUser{Name: "Petra", Email: "petra@abc.com"}, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	var petra restimpl.BlogUser
+	err = json.Unmarshal(response.Body.Bytes(), &petra)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	racing := suite.Store
+	users := &racingUserRepository{UserRepository: suite.Store.Users, race: func() {
+		current, err := suite.Store.Users.GetById(context.Background(), petra.Id)
+		assert.Nil(suite.T(), err)
+		current.Email = "petra.new@abc.com"
+		err = suite.Store.Users.Replace(context.Background(), current, current.Version)
+		assert.Nil(suite.T(), err)
+	}}
+	racing.Users = users
+	router = NewRouter(racing, WithAuth(suite.Auth), WithOidc(oidc))
+	response = login(jwt.MapClaims{"email": "petra@abc.com", "email_verified": true})
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+	assert.Nil(suite.T(), users.race)
+	stored, err := suite.Store.Users.GetById(context.Background(), petra.Id)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "petra.new@abc.com", stored.Email)
+	assert.False(suite.T(), stored.OidcLinked)
+	router = NewRouter(suite.Store, WithAuth(suite.Auth), WithOidc(oidc))
+
+	//Anyone can sign up with the email of someone else and a password, such a user is only linked by an admin
+	user := restimpl.BlogUser{Name: "David", Email: "david@abc.com", Password: "correct horse"}
+	response = PerformRequest(router, http.MethodPost, getBlogUserUrl(""), user, header)
+	assert.Equal(suite.T(), http.StatusCreated, response.Code)
+	var created restimpl.BlogUser
+	err = json.Unmarshal(response.Body.Bytes(), &created)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	response = login(jwt.MapClaims{"email": "david@abc.com", "email_verified": true, "name": "Dave"})
+	assert.Equal(suite.T(), http.StatusConflict, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), string(CodeAccountLinkRequired))
+	stored, err = suite.Store.Users.GetById(context.Background(), created.Id)
+	assert.Nil(suite.T(), err)
+	assert.False(suite.T(), stored.OidcLinked)
+
+	//Once linked, like the link-oidc command does, the user signs in with both
+	stored.OidcLinked = true
+	err = suite.Store.Users.Replace(context.Background(), stored, stored.Version)
+	assert.Nil(suite.T(), err)
+	caller = loggedIn(login(jwt.MapClaims{"email": "david@abc.com", "email_verified": true, "name": "Dave"}))
+	assert.Equal(suite.T(), created.Id, caller.UserId)
+	response = PerformRequest(router, http.MethodPost, "/auth/login",
+		restimpl.Credentials{Email: "david@abc.com", Password: "correct horse"}, header)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	//A linked user can not move to an email the provider did not verify, the sso login of its owner would land here
+	var tokens restimpl.TokenPair
+	err = json.Unmarshal(response.Body.Bytes(), &tokens)
+	if err != nil {
+		log.Fatalf("Unmarshall Error %v", err)
+	}
+	davidHeader := map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + tokens.AccessToken}
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id),
+		restimpl.BlogUser{Name: "David", Email: "victim@abc.com", Password: "correct horse"}, davidHeader)
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+	response = PerformRequest(router, http.MethodPatch, getBlogUserUrl(created.Id),
+		json.RawMessage(`{"email": "victim@abc.com"}`),
+		map[string]string{"Content-Type": "application/merge-patch+json", "Authorization": davidHeader["Authorization"]})
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+	response = PerformRequest(router, http.MethodPut, getBlogUserUrl(created.Id),
+		restimpl.BlogUser{Name: "Dave", Email: "david@abc.com"}, davidHeader)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+
+	//An email changed by an admin unlinks the user, the sso login of the new email needs a link again
+	stored, err = suite.Store.Users.GetById(context.Background(), created.Id)
+	assert.Nil(suite.T(), err)
+	stored.Email = "victim@abc.com"
+	err = suite.Store.Users.Replace(context.Background(), stored, stored.Version)
+	assert.Nil(suite.T(), err)
+	response = login(jwt.MapClaims{"email": "victim@abc.com", "email_verified": true})
+	assert.Equal(suite.T(), http.StatusConflict, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), string(CodeAccountLinkRequired))
+	stored.Email = "david@abc.com"
+	err = suite.Store.Users.Replace(context.Background(), stored, store.AnyVersion)
+	assert.Nil(suite.T(), err)
+	stored.OidcLinked = true
+	err = suite.Store.Users.Replace(context.Background(), stored, store.AnyVersion)
+	assert.Nil(suite.T(), err)
+
+	//An unverified email is neither linked nor provisioned
+	response = login(jwt.MapClaims{"email": "david@abc.com", "email_verified": false})
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), string(CodeOidcFailed))
+	response = login(jwt.MapClaims{"email": "eve@abc.com"})
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	_, err = suite.Store.Users.GetByEmail(context.Background(), "eve@abc.com")
+	assert.Equal(suite.T(), store.ErrNotFound, err)
+
+	//The callback needs the state of a login started here, its nonce, and a code not exchanged yet
+	verified := jwt.MapClaims{"email": "david@abc.com", "email_verified": true}
+	authUrl, cookie = start()
+	code := idp.authorize(authUrl, verified)
+	response = callback(url.Values{"code": {code}, "state": {"forged"}}, cookie)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	response = callback(url.Values{"code": {code}, "state": {authUrl.Query().Get("state")}}, nil)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	_, otherCookie := start()
+	response = callback(url.Values{"code": {code}, "state": {authUrl.Query().Get("state")}}, otherCookie)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	response = callback(url.Values{"code": {code}, "state": {authUrl.Query().Get("state")}}, cookie)
+	assert.Equal(suite.T(), http.StatusOK, response.Code)
+	response = callback(url.Values{"code": {code}, "state": {authUrl.Query().Get("state")}}, cookie)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	response = login(jwt.MapClaims{"email": "david@abc.com", "email_verified": true, "nonce": "replayed"})
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	response = login(jwt.MapClaims{"email": "david@abc.com", "email_verified": true,
+		"iss": "https://evil.example.com"})
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	authUrl, cookie = start()
+	response = callback(url.Values{"error": {"access_denied"}, "state": {authUrl.Query().Get("state")}}, cookie)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), "access_denied")
+
+	//Without auto provisioning only the existing users log in
+	config := idp.config()
+	config.AutoProvision = false
+	oidc, err = NewOidc(config)
+	assert.Nil(suite.T(), err)
+	router = NewRouter(suite.Store, WithAuth(suite.Auth), WithOidc(oidc))
+	response = login(jwt.MapClaims{"email": "frank@abc.com", "email_verified": true})
+	assert.Equal(suite.T(), http.StatusForbidden, response.Code)
+	caller = loggedIn(login(verified))
+	assert.Equal(suite.T(), created.Id, caller.UserId)
+
+	//An unreachable provider is a bad gateway
+	idp.Close()
+	oidc, err = NewOidc(config)
+	assert.Nil(suite.T(), err)
+	router = NewRouter(suite.Store, WithAuth(suite.Auth), WithOidc(oidc))
+	response = PerformRequest(router, http.MethodGet, "/auth/oidc/login", nil, nil)
+	assert.Equal(suite.T(), http.StatusBadGateway, response.Code)
+	assert.Contains(suite.T(), response.Body.String(), string(CodeIdentityProviderUnavailable))
+}
+
+// racingUserRepository runs race once before a replace, like a concurrent update between a read and its write
+type racingUserRepository struct {
+	store.UserRepository
+	race func()
+}
+
+func (r *racingUserRepository) Replace(ctx context.Context, user restimpl.BlogUser, version int64) error {
+	if r.race != nil {
+		race := r.race
+		r.race = nil
+		race()
+	}
+	return r.UserRepository.Replace(ctx, user, version)
+}
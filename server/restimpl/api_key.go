@@ -0,0 +1,150 @@
+/*
+ * Simple blogging API handlers
+ */
+
+package restimpl
+
+import (
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+	uuid "github.com/satori/go.uuid"
+)
+
+// AddApiKeys - creates an api key of a blogUsers item, the key is only returned in this response
+func AddApiKeys(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Api key request received.")
+
+	contentType := c.Request.Header.Get("Content-type")
+	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
+		logEntry.Errorf("Unsupported content type : %s", contentType)
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
+		return
+	}
+
+	id := c.Param("id")
+	if id, err := uuid.FromString(id); err != nil {
+		logEntry.Errorf("Invalid UUID: %s", id.String())
+		abortWithProblem(c, errInvalidId(c))
+		return
+	}
+
+	var apiKey restimpl.ApiKey
+	err := c.ShouldBindJSON(&apiKey)
+	if err != nil {
+		logEntry.Errorf("Json parsing error %v", err)
+		abortWithProblem(c, errInvalidBody(err))
+		return
+	}
+	now := time.Now().UTC()
+	if apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now) {
+		logEntry.Errorf("Api key expiring at %v", apiKey.ExpiresAt)
+		abortWithProblem(c, errInvalidField("expiresAt", "future", "must be in the future"))
+		return
+	}
+
+	_, err = getBlogUserByid(c, id, logEntry)
+	if err != nil {
+		logEntry.Errorf("Retrieval failed!")
+		abortWithProblem(c, errNotFound(err, "User", id))
+		return
+	}
+
+	key, prefix, hash, err := generateApiKey()
+	if err != nil {
+		logEntry.Errorf("Api key generation failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	//Set the readonly fields
+	apiKey.Id = uuid.NewV4().String()
+	apiKey.UserId = id
+	apiKey.Prefix = prefix
+	apiKey.KeyHash = hash
+	apiKey.Scopes = uniqueScopes(apiKey.Scopes)
+	apiKey.CreatedAt = now
+	apiKey.LastUsedAt = nil
+	apiKey.Key = ""
+
+	err = apiKeyRepository(c).Insert(c.Request.Context(), apiKey)
+	if err != nil {
+		logEntry.Errorf("Insert failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.Infof("Api key %s of user with id: %s created!", prefix, id)
+	apiKey.Key = key
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, apiKey)
+}
+
+// ListApiKeys - lists the api keys of a blogUsers item, without their secret
+func ListApiKeys(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Api key list request received.")
+
+	id := c.Param("id")
+	if id, err := uuid.FromString(id); err != nil {
+		logEntry.Errorf("Invalid UUID: %s", id.String())
+		abortWithProblem(c, errInvalidId(c))
+		return
+	}
+
+	_, err := getBlogUserByid(c, id, logEntry)
+	if err != nil {
+		logEntry.Errorf("Retrieval failed!")
+		abortWithProblem(c, errNotFound(err, "User", id))
+		return
+	}
+
+	keys, err := apiKeyRepository(c).ListByUser(c.Request.Context(), id)
+	if err != nil {
+		logEntry.Errorf("Api key list failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.Infof("Api keys of user with id: %s listed", id)
+	c.JSON(http.StatusOK, restimpl.ApiKeyList{Items: keys})
+}
+
+// DeleteApiKeys - revokes an api key of a blogUsers item
+func DeleteApiKeys(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Api key revoke request received.")
+
+	id := c.Param("id")
+	if id, err := uuid.FromString(id); err != nil {
+		logEntry.Errorf("Invalid UUID: %s", id.String())
+		abortWithProblem(c, errInvalidId(c))
+		return
+	}
+	keyId := c.Param("keyId")
+	if keyId, err := uuid.FromString(keyId); err != nil {
+		logEntry.Errorf("Invalid UUID: %s", keyId.String())
+		abortWithProblem(c, errInvalidParam("keyId", "%s is not a uuid", c.Param("keyId")))
+		return
+	}
+
+	err := apiKeyRepository(c).Delete(c.Request.Context(), id, keyId)
+	if err == store.ErrNotFound {
+		logEntry.Errorf("Api key with id: %s of user with id: %s not found", keyId, id)
+		abortWithProblem(c, errNotFound(err, "Api key", keyId))
+		return
+	}
+	if err != nil {
+		logEntry.Errorf("Api key revoke failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.Infof("Api key with id: %s of user with id: %s revoked!", keyId, id)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,138 @@
+package restimpl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+// apiKeyPrefix starts every api key, it tells them apart from the other credentials in logs and secret scanners
+const apiKeyPrefix = "blog_"
+
+// apiKeyScheme is the Authorization scheme of the api keys
+const apiKeyScheme = "ApiKey"
+
+// Scopes of the api keys, a read key makes the GET requests and a write key the others
+const (
+	readScope  = "read"
+	writeScope = "write"
+)
+
+// lastUsedResolution is how stale the last use of a key may get, so that not every request writes it
+const lastUsedResolution = time.Minute
+
+// generateApiKey returns a new key, its public prefix and its hash. The key is blog_<prefix id>_<secret>, the
+// prefix is blog_<prefix id>.
+func generateApiKey() (key string, prefix string, hash string, err error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	prefix = apiKeyPrefix + hex.EncodeToString(id)
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, hashApiKey(key), nil
+}
+
+// Helper function to hash a key, the keys are random enough that a fast hash does not make them guessable
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper function to get the prefix of a key
+func apiKeyPrefixOf(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !ok || id == "" {
+		return "", false
+	}
+	return apiKeyPrefix + id, true
+}
+
+// verifyApiKey returns the caller of a valid key, whose user is not deleted, and records its use
+func verifyApiKey(c *gin.Context, s store.Store, key string) (Principal, error) {
+	ctx := c.Request.Context()
+	prefix, ok := apiKeyPrefixOf(key)
+	if !ok {
+		return Principal{}, errInvalidApiKey(errors.New("malformed api key"))
+	}
+	stored, err := s.ApiKeys.GetByPrefix(ctx, prefix)
+	if err == store.ErrNotFound {
+		return Principal{}, errInvalidApiKey(fmt.Errorf("unknown api key %s", prefix))
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashApiKey(key)), []byte(stored.KeyHash)) != 1 {
+		return Principal{}, errInvalidApiKey(fmt.Errorf("wrong secret of api key %s", prefix))
+	}
+	now := time.Now().UTC()
+	if stored.ExpiresAt != nil && !now.Before(*stored.ExpiresAt) {
+		return Principal{}, errInvalidApiKey(fmt.Errorf("api key %s expired", prefix))
+	}
+	user, err := s.Users.GetById(ctx, stored.UserId)
+	if err == store.ErrNotFound {
+		return Principal{}, errInvalidApiKey(fmt.Errorf("user of api key %s not found", prefix))
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= lastUsedResolution {
+		//The request is served even when its use is not recorded
+		if err := s.ApiKeys.Touch(ctx, stored.Id, now); err != nil {
+			requestLog(c).Warnf("Unable to record the use of api key %s: %v", prefix, err)
+		}
+	}
+	return Principal{UserId: user.Id, Role: userRole(user), ApiKeyId: stored.Id, Scopes: stored.Scopes}, nil
+}
+
+// requiredScope is the scope of the api keys allowed to make a request of the method
+func requiredScope(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return readScope
+	}
+	return writeScope
+}
+
+// Helper function to remove the repeated scopes of a new key
+func uniqueScopes(scopes []string) []string {
+	var res []string
+	for _, scope := range scopes {
+		if !containsScope(res, scope) {
+			res = append(res, scope)
+		}
+	}
+	return res
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// errInvalidApiKey is the error of an api key that is malformed, unknown, expired or of a deleted user
+func errInvalidApiKey(err error) *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Code: CodeInvalidApiKey,
+		Detail: "The api key is not valid or has expired", Challenge: apiKeyScheme + ` realm="blogApp"`, Err: err}
+}
@@ -0,0 +1,275 @@
+/*
+ * Simple blogging API handlers
+ */
+
+package restimpl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+	"github.com/gouthams/blogApp/server/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+// oidcStateCookie keeps the signed state of a login between its start and its callback
+const oidcStateCookie = "blog_oidc_state"
+
+// oidcCookiePath limits the state cookie to the oidc login apis
+const oidcCookiePath = "/auth/oidc"
+
+// linkAttempts bounds the links of a user updated concurrently with its oidc login
+const linkAttempts = 3
+
+// OidcLogin - redirects the user to the identity provider to log in
+func OidcLogin(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Oidc login request received.")
+
+	config := routerConfig(c)
+	if config.Oidc == nil {
+		logEntry.Warn("Oidc login is not configured")
+		abortWithProblem(c, errOidcDisabled())
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		abortWithProblem(c, err)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		abortWithProblem(c, err)
+		return
+	}
+	verifier, err := randomToken()
+	if err != nil {
+		abortWithProblem(c, err)
+		return
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	authUrl, err := config.Oidc.authCodeUrl(c.Request.Context(), state, nonce,
+		base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		logEntry.Errorf("Oidc discovery failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+	signedState, err := config.Auth.signOidcState(oidcState{State: state, Nonce: nonce, Verifier: verifier})
+	if err != nil {
+		logEntry.Errorf("State signing failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	setOidcStateCookie(c, signedState, int(oidcStateTTL.Seconds()))
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, authUrl)
+}
+
+// OidcCallback - logs in the user the identity provider redirected back, the user of the verified email address is
+// linked, or created when the auto provisioning is enabled
+func OidcCallback(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Oidc callback request received.")
+
+	config := routerConfig(c)
+	if config.Oidc == nil {
+		logEntry.Warn("Oidc login is not configured")
+		abortWithProblem(c, errOidcDisabled())
+		return
+	}
+
+	//The state is used once, whatever the outcome of the callback
+	rawState, err := c.Cookie(oidcStateCookie)
+	setOidcStateCookie(c, "", -1)
+	if err != nil {
+		logEntry.Warn("Oidc callback without state cookie")
+		abortWithProblem(c, errOidcFailed("The login has expired or was not started here, log in again", err))
+		return
+	}
+	state, err := config.Auth.verifyOidcState(rawState)
+	if err != nil {
+		logEntry.Warnf("Invalid oidc state: %v", err)
+		abortWithProblem(c, errOidcFailed("The login has expired or was not started here, log in again", err))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(state.State)) != 1 {
+		logEntry.Warn("Oidc state does not match")
+		abortWithProblem(c, errOidcFailed("The login has expired or was not started here, log in again", nil))
+		return
+	}
+	if providerError := c.Query("error"); providerError != "" {
+		logEntry.Warnf("Identity provider denied the login: %s %s", providerError, c.Query("error_description"))
+		abortWithProblem(c, errOidcFailed("The identity provider denied the login: "+providerError, nil))
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		logEntry.Errorf("Oidc callback without code")
+		abortWithProblem(c, errInvalidParam("code", "is required"))
+		return
+	}
+
+	rawIdToken, err := config.Oidc.exchange(c.Request.Context(), code, state.Verifier)
+	if err != nil {
+		logEntry.Errorf("Code exchange failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+	claims, err := config.Oidc.verify(c.Request.Context(), rawIdToken, state.Nonce)
+	if err != nil {
+		logEntry.Errorf("Id token verification failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+	//Only an address the identity provider verified may be linked, anyone can claim an address otherwise
+	if claims.Email == "" || !claims.emailVerified() {
+		logEntry.Warnf("Id token of subject %s without verified email", claims.Subject)
+		abortWithProblem(c, errOidcFailed("The identity provider did not verify the email address", nil))
+		return
+	}
+
+	user, err := getBlogUserByEmail(c, claims.Email, logEntry)
+	switch err {
+	case nil:
+		user, err = linkUser(c, claims.Email, user, logEntry)
+	case store.ErrNotFound:
+		user, err = provisionUser(c, claims, logEntry)
+	}
+	if err != nil {
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.WithField("user", user.Id).Infof("Oidc login of subject %s", claims.Subject)
+	issueTokens(c, user)
+}
+
+// Helper function to link the user of the verified email address of an oidc login. The email of a user signing up with
+// a password is not verified, whoever chose the password could keep using it after the link, so these users are only
+// linked by an admin.
+func linkUser(c *gin.Context, email string, user restimpl.BlogUser, logEntry *utils.REntry) (restimpl.BlogUser, error) {
+	for attempt := 1; ; attempt++ {
+		if user.OidcLinked {
+			return user, nil
+		}
+		if user.PasswordHash != "" {
+			logEntry.Warnf("User with id: %s has a password and is not linked", user.Id)
+			return restimpl.BlogUser{}, errAccountLinkRequired()
+		}
+
+		linked := user
+		linked.OidcLinked = true
+		linked.LastModifiedDate = time.Now().UTC()
+		err := userRepository(c).Replace(c.Request.Context(), linked, user.Version)
+		if err == nil {
+			logEntry.Infof("User with id: %s linked", user.Id)
+			return getBlogUserByid(c, user.Id, logEntry)
+		}
+		if err != store.ErrVersionMismatch {
+			logEntry.Errorf("Link failed %v", err)
+			return restimpl.BlogUser{}, err
+		}
+
+		//The user was updated meanwhile, e.g. given a password or another email, it is checked again
+		logEntry.Warnf("Version of user with id: %s does not match", user.Id)
+		if attempt == linkAttempts {
+			return restimpl.BlogUser{}, errUserChanged()
+		}
+		current, err := getBlogUserByEmail(c, email, logEntry)
+		if err == store.ErrNotFound || (err == nil && current.Id != user.Id) {
+			logEntry.Warnf("User with id: %s no longer has the email address of the login", user.Id)
+			return restimpl.BlogUser{}, errUserChanged()
+		}
+		if err != nil {
+			return restimpl.BlogUser{}, err
+		}
+		user = current
+	}
+}
+
+// Helper function to create the user of a first oidc login, with the default role and without password
+func provisionUser(c *gin.Context, claims oidcClaims, logEntry *utils.REntry) (restimpl.BlogUser, error) {
+	if !routerConfig(c).Oidc.config.AutoProvision {
+		logEntry.Warn("No user with the email address of the oidc login")
+		return restimpl.BlogUser{}, errForbidden("No user has the email address, ask an admin to create it")
+	}
+
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	blogUser := restimpl.BlogUser{
+		Id:               uuid.NewV4().String(),
+		Name:             name,
+		Email:            claims.Email,
+		Role:             string(DefaultRole),
+		OidcLinked:       true,
+		Version:          1,
+		LastModifiedDate: time.Now().UTC(),
+	}
+	err := userRepository(c).Insert(c.Request.Context(), blogUser)
+	if err == store.ErrDuplicate {
+		//A concurrent login provisioned the user first, someone signed up meanwhile, or the user is in the trash
+		logEntry.Warnf("User already exists %v", err)
+		user, err := getBlogUserByEmail(c, claims.Email, logEntry)
+		if err == store.ErrNotFound {
+			return restimpl.BlogUser{}, errForbidden("The user of the email address is deleted")
+		}
+		if err != nil {
+			return restimpl.BlogUser{}, err
+		}
+		return linkUser(c, claims.Email, user, logEntry)
+	}
+	if err != nil {
+		logEntry.Errorf("Insert failed %v", err)
+		return restimpl.BlogUser{}, err
+	}
+
+	logEntry.Infof("User provisioned with id: %s", blogUser.Id)
+	return getBlogUserByid(c, blogUser.Id, logEntry)
+}
+
+// Helper function to set the state cookie of a login, a negative maxAge deletes it
+func setOidcStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := strings.HasPrefix(routerConfig(c).Oidc.config.RedirectUrl, "https://")
+	//Lax lets the cookie come back with the redirect of the identity provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, oidcCookiePath, "", secure, true)
+}
+
+// Helper function to make a random url safe value
+func randomToken() (string, error) {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+// errAccountLinkRequired is the error of an oidc login of a user with a password who is not linked yet
+func errAccountLinkRequired() *APIError {
+	return errConflict(CodeAccountLinkRequired,
+		"The user of the email address signs in with a password, ask an admin to link it to the identity provider", nil)
+}
+
+// errUserChanged is the error of an oidc login whose user is updated, e.g. given another email, while it is linked
+func errUserChanged() *APIError {
+	return errForbidden("The user of the email address changed during the login, log in again")
+}
+
+// errOidcDisabled is the error of the oidc login apis of a server configured without identity provider
+func errOidcDisabled() *APIError {
+	return &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Detail: "The oidc login is not configured"}
+}
@@ -3,13 +3,11 @@ package restimpl
 import (
 	"fmt"
 	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
 	"github.com/gouthams/blogApp/server/utils"
 	uuid "github.com/satori/go.uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"mime"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,29 +15,34 @@ import (
 
 // AddblogPosts - adds an blogPosts item
 func AddblogPosts(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Debug("Post request received.")
 
 	contentType := c.Request.Header.Get("Content-type")
 	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
 		logEntry.Errorf("Unsupported content type : %s", contentType)
-		c.JSON(http.StatusUnsupportedMediaType, restimpl.Error{Code: "415", Message: contentType})
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
 		return
 	}
 
 	var blogPost restimpl.BlogPost
-	err := c.BindJSON(&blogPost)
+	err := c.ShouldBindJSON(&blogPost)
 	if err != nil {
 		logEntry.Errorf("Json parsing error %v", err)
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidBody(err))
 		return
 	}
 
-	_, err = getBlogUserByid(blogPost.UserId, logEntry)
+	err = authorizeOwner(c, blogPost.UserId)
+	if err != nil {
+		logEntry.Warnf("Post of user with id: %s not allowed", blogPost.UserId)
+		abortWithProblem(c, err)
+		return
+	}
+	_, err = getBlogUserByid(c, blogPost.UserId, logEntry)
 	if err != nil {
 		logEntry.Errorf("Retrieval failed!")
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: "UserId is not valid."})
+		abortWithProblem(c, errUnknownUser(err))
 		return
 	}
 
@@ -47,81 +50,88 @@ func AddblogPosts(c *gin.Context) {
 	//Set the time in UTC
 	blogPost.LastModifiedDate = time.Now().UTC()
 	blogPost.Id = uuid.NewV4().String()
+	blogPost.Version = 1
+	blogPost.DeletedAt = nil
 
-	blogCollection, ctx := utils.GetPostCollection()
-	doc, err := blogCollection.InsertOne(ctx, blogPost)
+	err = postRepository(c).Insert(c.Request.Context(), blogPost)
 	if err != nil {
 		logEntry.Errorf("Insert failed %v", err)
+		abortWithProblem(c, err)
+		return
 	}
 
-	logEntry.Debugf("Document created %v doc: %v", doc.InsertedID)
+	logEntry.Debugf("Document created with id: %s", blogPost.Id)
 
-	post, err := getBlogPostByid(blogPost.Id, logEntry)
+	post, err := getBlogPostByid(c, blogPost.Id, logEntry)
 	if err != nil {
 		logEntry.Errorf("Retrieval failed!")
-		c.JSON(http.StatusInternalServerError, restimpl.Error{Code: "500", Message: err.Error()})
+		abortWithProblem(c, err)
 		return
 	}
 
 	logEntry.Infof("blogPost with id: %s created!", blogPost.Id)
+	c.Header("ETag", formatETag(post.Version))
 	c.JSON(http.StatusCreated, post)
 	return
 }
 
 // DeleteBlogPosts - deletes an blogPosts item
 func DeleteBlogPosts(c *gin.Context) {
-	logEntry := utils.Log().WithField("url", c.Request.URL)
+	logEntry := requestLog(c)
 	logEntry.Debug("Delete request received.")
 
 	contentType := c.Request.Header.Get("Content-type")
 	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
 		logEntry.Errorf("Unsupported content type : %s", contentType)
-		c.JSON(http.StatusUnsupportedMediaType, restimpl.Error{Code: "415", Message: contentType})
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
 		return
 	}
 
 	id := c.Param("id")
 	if id, err := uuid.FromString(id); err != nil {
 		logEntry.Errorf("Invalid UUID: %s", id.String())
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidId(c))
 		return
 	}
 
-	isDone, _ := deletePostById(id, logEntry)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		logEntry.Errorf("Invalid If-Match: %s", c.GetHeader("If-Match"))
+		preconditionFailed(c, id)
+		return
+	}
+
+	isDone, err := deletePostById(c, id, version, logEntry)
+	if err == store.ErrVersionMismatch {
+		logEntry.Errorf("Version of post with id: %s does not match", id)
+		preconditionFailed(c, id)
+		return
+	}
 	if isDone == false {
 		logEntry.Errorf("Delete post failed")
-		c.JSON(http.StatusInternalServerError, restimpl.Error{Code: "500",
-			Message: fmt.Sprintf("Delete post with id: %s failed", id)})
+		abortWithProblem(c, err)
+		return
 	}
 
 	logEntry.Infof("blogPost with id: %s deleted!", id)
-	c.JSON(http.StatusNoContent, restimpl.Error{Code: "204",
-		Message: fmt.Sprintf("Delete post with id: %s Succeeded",
-			id)})
+	c.Status(http.StatusNoContent)
 }
 
 //Helper function to delete post by the given Id
-func deletePostById(id string, logEntry *utils.REntry) (bool, error) {
+func deletePostById(c *gin.Context, id string, version int64, logEntry *utils.REntry) (bool, error) {
 	//Delete the blogPost
-	deleteFilter := bson.D{{"id", id}}
-
-	postCollection, ctx := utils.GetPostCollection()
-	//Check to see if post exist
-	_, err := getBlogPostByid(id, logEntry)
-	if err != nil {
-		// If get post fails that means the post is not in the system, delete will be treated as success.
-		// TODO: If the failure is not able to connect to DB this behaviour needs to be changed.
+	err := postRepository(c).DeleteById(c.Request.Context(), id, version)
+	if err == store.ErrNotFound && version != store.AnyVersion {
+		// A conditional delete can not match a missing post
+		return false, store.ErrVersionMismatch
+	}
+	if err == store.ErrNotFound {
+		// If the post is not in the system, delete will be treated as success.
 		logEntry.Errorf("Unable to get the post with id: %s", id)
 		return true, nil
 	}
-
-	deletedPost, err := postCollection.DeleteOne(ctx, deleteFilter)
 	if err != nil {
 		logEntry.Errorf("Delete failed %v", err)
-	}
-
-	if deletedPost.DeletedCount != 1 {
-		logEntry.Errorf("Delete count is not 1 %d", deletedPost.DeletedCount)
 		return false, err
 	}
 	return true, nil
@@ -129,21 +139,27 @@ func deletePostById(id string, logEntry *utils.REntry) (bool, error) {
 
 // GetblogPosts - get a single blogPosts
 func GetblogPosts(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Debug("Get request received.")
 
 	id := c.Param("id")
 	if id, err := uuid.FromString(id); err != nil {
 		logEntry.Errorf("Invalid UUID: %s", id.String())
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidId(c))
 		return
 	}
 
-	post, err := getBlogPostByid(id, logEntry)
+	post, err := getBlogPostByid(c, id, logEntry)
 	if err != nil {
 		logEntry.Errorf("Retrieval failed!")
-		c.JSON(http.StatusNotFound, restimpl.Error{Code: "404", Message: err.Error()})
+		abortWithProblem(c, errNotFound(err, "Post", id))
+		return
+	}
+
+	c.Header("ETag", formatETag(post.Version))
+	if ifNoneMatch(c, post.Version) {
+		logEntry.Debugf("Post with id: %s not modified", id)
+		c.Status(http.StatusNotModified)
 		return
 	}
 
@@ -153,13 +169,8 @@ func GetblogPosts(c *gin.Context) {
 }
 
 // Helper method to get psot based on the id
-func getBlogPostByid(id string, logEntry *utils.REntry) (restimpl.BlogPost, error) {
-	//Filter with the parameter id from the url
-	filter := bson.D{{"id", id}}
-
-	var post restimpl.BlogPost
-	blogCollection, ctx := utils.GetPostCollection()
-	err := blogCollection.FindOne(ctx, filter).Decode(&post)
+func getBlogPostByid(c *gin.Context, id string, logEntry *utils.REntry) (restimpl.BlogPost, error) {
+	post, err := postRepository(c).GetById(c.Request.Context(), id)
 	if err != nil {
 		logEntry.Errorf("Search failed %v", err)
 		return restimpl.BlogPost{}, err
@@ -169,114 +180,251 @@ func getBlogPostByid(id string, logEntry *utils.REntry) (restimpl.BlogPost, erro
 
 // SearchblogPosts - searches blogPosts
 func SearchblogPosts(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Debug("Search request received.")
 
-	//Query string from the url
-	query := c.Request.URL.Query()
-	var filter bson.D
-
-	userId := query.Get("userId")
-	if userId, err := uuid.FromString(userId); err != nil {
-		logEntry.Errorf("Invalid userId to search: %s. Ignores this filter", userId)
-		//Empty filter to get all the records of post in the slice
-		filter = bson.D{}
-	} else {
-		filter = bson.D{{"userid", userId.String()}}
+	filter, err := parsePostQuery(c)
+	if err != nil {
+		logEntry.Errorf("Invalid search query: %v", err)
+		abortWithProblem(c, err)
+		return
 	}
-	logEntry.Debugf("Filter criteria %v", filter)
+	logEntry.Debugf("Filter criteria %+v", filter)
 
-	findOptions := options.Find()
-	pageSize := query.Get("pageSize")
-	pageLimit, err := strconv.ParseInt(pageSize, 10, 64)
+	res, err := postRepository(c).Search(c.Request.Context(), filter)
 	if err != nil {
-		logEntry.Errorf("Invalid pageSize: %d. Ignores this filter", pageSize)
+		logEntry.Errorf("Search failed %v", err)
+		abortWithProblem(c, err)
+		return
 	}
-	findOptions.SetLimit(pageLimit)
+	logEntry.Debugf("%d documents retrieved", len(res))
 
-	//Explicitly initialize the slice with empty value to return if none found
-	var res []restimpl.BlogPost
-	postCollection, ctx := utils.GetPostCollection()
-	cursor, err := postCollection.Find(ctx, filter, findOptions)
+	page := restimpl.BlogPostPage{Items: res}
+	if pageSize := filter.PageSize - 1; int64(len(res)) > pageSize {
+		page.Items = res[:pageSize]
+		page.NextPageToken = nextPage(c, postPageToken(filter, page.Items[pageSize-1]))
+	}
+
+	logEntry.Info("BlogPost document search done!")
+	c.JSON(http.StatusOK, page)
+}
+
+// TextSearchblogPosts - full-text searches the topic and content of blogPosts
+func TextSearchblogPosts(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Text search request received.")
+
+	query, err := parseTextQuery(c)
 	if err != nil {
-		logEntry.Errorf("Search failed %v", err)
-		c.JSON(http.StatusNotFound, restimpl.Error{Code: "404", Message: err.Error()})
+		logEntry.Errorf("Invalid text search query: %v", err)
+		abortWithProblem(c, err)
 		return
 	}
+	logEntry.Debugf("Text query %+v", query)
 
-	logEntry.Debugf("Cursor from DB: %v", cursor)
-	for cursor.Next(ctx) {
-		logEntry.Debugf("Documents retrieved %v", cursor.Current)
-		var post restimpl.BlogPost
-		err := cursor.Decode(&post)
-		//If the is issue with one post log the error and continue
-		if err != nil {
-			logEntry.Errorf("Unable to decode post: %v", err)
+	hits, err := postRepository(c).TextSearch(c.Request.Context(), query)
+	if err != nil {
+		logEntry.Errorf("Text search failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+	logEntry.Debugf("%d documents retrieved", len(hits))
 
-		}
-		res = append(res, post)
+	page := restimpl.BlogPostSearchPage{Items: []restimpl.BlogPostSearchHit{}}
+	if pageSize := query.PageSize - 1; int64(len(hits)) > pageSize {
+		hits = hits[:pageSize]
+		last := hits[pageSize-1]
+		page.NextPageToken = nextPage(c, pageToken{Id: last.Post.Id, Score: &last.Score})
+	}
+	words := query.Words()
+	for _, hit := range hits {
+		page.Items = append(page.Items, restimpl.BlogPostSearchHit{Post: hit.Post, Score: hit.Score,
+			Highlights: highlightPost(hit.Post, words)})
 	}
 
-	logEntry.Info("BlogPost document search done!")
-	c.JSON(http.StatusOK, res)
-	return
+	logEntry.Info("BlogPost text search done!")
+	c.JSON(http.StatusOK, page)
 }
 
 // UpdateblogPosts - update an blogPosts item
 func UpdateblogPosts(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Debug("Update request received.")
 
 	contentType := c.Request.Header.Get("Content-type")
 	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
 		logEntry.Errorf("Unsupported content type : %s", contentType)
-		c.JSON(http.StatusUnsupportedMediaType, restimpl.Error{Code: "415", Message: contentType})
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
 		return
 	}
 
 	id := c.Param("id")
 	if id, err := uuid.FromString(id); err != nil {
 		logEntry.Errorf("Invalid UUID: %s", id.String())
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidId(c))
 		return
 	}
 
 	var blogPost restimpl.BlogPost
-	err := c.BindJSON(&blogPost)
+	err := c.ShouldBindJSON(&blogPost)
 	if err != nil {
 		logEntry.Errorf("Json parsing error %v", err)
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidBody(err))
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		logEntry.Errorf("Invalid If-Match: %s", c.GetHeader("If-Match"))
+		preconditionFailed(c, id)
 		return
 	}
 
-	//update the time in UTC
-	blogPost.LastModifiedDate = time.Now().UTC()
 	blogPost.Id = id
+	savePost(c, blogPost, version, logEntry)
+}
 
-	blogCollection, ctx := utils.GetPostCollection()
-	isDone, err := deletePostById(id, logEntry)
-	if isDone == false {
-		logEntry.Errorf("Delete post failed")
-		c.JSON(http.StatusInternalServerError, restimpl.Error{Code: "500",
-			Message: fmt.Sprintf("Delete post with id: %s failed", id)})
+// PatchblogPosts - partially update an blogPosts item
+func PatchblogPosts(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Patch request received.")
+
+	contentType, ok := patchContentType(c)
+	if !ok {
+		logEntry.Errorf("Unsupported content type : %s", contentType)
+		abortWithProblem(c, errUnsupportedMediaType(contentType, mergePatchContentType, jsonPatchContentType))
+		return
+	}
+
+	id := c.Param("id")
+	if id, err := uuid.FromString(id); err != nil {
+		logEntry.Errorf("Invalid UUID: %s", id.String())
+		abortWithProblem(c, errInvalidId(c))
+		return
 	}
 
-	doc, err := blogCollection.InsertOne(ctx, blogPost)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		logEntry.Errorf("Invalid If-Match: %s", c.GetHeader("If-Match"))
+		preconditionFailed(c, id)
+		return
+	}
+
+	current, err := getBlogPostByid(c, id, logEntry)
 	if err != nil {
-		logEntry.Errorf("Insert failed %v", err)
+		logEntry.Errorf("Retrieval failed!")
+		abortWithProblem(c, errNotFound(err, "Post", id))
+		return
+	}
+	//Without If-Match the patch is still applied only on top of the version it was computed from
+	if version == store.AnyVersion {
+		version = current.Version
+	}
+
+	var blogPost restimpl.BlogPost
+	err = applyPatch(c, contentType, current, &blogPost)
+	if err != nil {
+		logEntry.Errorf("Patch failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	//The id is readonly, it can not be changed by the patch
+	blogPost.Id = id
+	savePost(c, blogPost, version, logEntry)
+}
+
+// Helper function to persist an updated post and write the response, shared by PUT and PATCH
+func savePost(c *gin.Context, blogPost restimpl.BlogPost, version int64, logEntry *utils.REntry) {
+	id := blogPost.Id
+	//The post can only be given to another user by the roles allowed on the posts of every user
+	err := authorizeOwner(c, blogPost.UserId)
+	if err != nil {
+		logEntry.Warnf("Post of user with id: %s not allowed", blogPost.UserId)
+		abortWithProblem(c, err)
+		return
+	}
+	_, err = getBlogUserByid(c, blogPost.UserId, logEntry)
+	if err != nil {
+		logEntry.Errorf("Retrieval failed!")
+		abortWithProblem(c, errUnknownUser(err))
+		return
+	}
+
+	//update the time in UTC
+	blogPost.LastModifiedDate = time.Now().UTC()
+	//Only a delete moves the post to the trash
+	blogPost.DeletedAt = nil
+
+	//Replace the post in place so that it is never missing for concurrent readers
+	err = postRepository(c).Replace(c.Request.Context(), blogPost, version)
+	if err == store.ErrVersionMismatch {
+		logEntry.Errorf("Version of post with id: %s does not match", id)
+		preconditionFailed(c, id)
+		return
+	}
+	if err == store.ErrNotFound {
+		logEntry.Errorf("Unable to get the post with id: %s", id)
+		abortWithProblem(c, errNotFound(err, "Post", id))
+		return
+	}
+	if err != nil {
+		logEntry.Errorf("Replace failed %v", err)
+		abortWithProblem(c, err)
+		return
 	}
 
-	logEntry.Debugf("Document updated with doc id:%v doc: %v", doc.InsertedID, doc)
-	post, err := getBlogPostByid(blogPost.Id, logEntry)
+	logEntry.Debugf("Document updated with id: %s", blogPost.Id)
+	post, err := getBlogPostByid(c, blogPost.Id, logEntry)
 	if err != nil {
 		logEntry.Errorf("Retrieval failed!")
-		c.JSON(http.StatusInternalServerError, restimpl.Error{Code: "500", Message: err.Error()})
+		abortWithProblem(c, err)
 		return
 	}
 
 	logEntry.Infof("blogPost with id: %s updated!", id)
+	c.Header("ETag", formatETag(post.Version))
+	c.JSON(http.StatusOK, post)
+}
+
+// RestoreblogPosts - takes a blogPosts item out of the trash
+func RestoreblogPosts(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Restore request received.")
+
+	id := c.Param("id")
+	if id, err := uuid.FromString(id); err != nil {
+		logEntry.Errorf("Invalid UUID: %s", id.String())
+		abortWithProblem(c, errInvalidId(c))
+		return
+	}
+
+	err := postRepository(c).Restore(c.Request.Context(), id)
+	if err == store.ErrNotFound {
+		logEntry.Errorf("Post with id: %s is not in the trash", id)
+		abortWithProblem(c, errNotFound(err, "Post in the trash", id))
+		return
+	}
+	if err == store.ErrOwnerDeleted {
+		logEntry.Errorf("User of the post with id: %s is in the trash", id)
+		abortWithProblem(c, errConflict(CodeOwnerInTrash,
+			fmt.Sprintf("User of the post with id: %s is in the trash, restore the user first", id), err))
+		return
+	}
+	if err != nil {
+		logEntry.Errorf("Restore failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	post, err := getBlogPostByid(c, id, logEntry)
+	if err != nil {
+		logEntry.Errorf("Retrieval failed!")
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.Infof("blogPosts with id: %s restored!", id)
+	c.Header("ETag", formatETag(post.Version))
 	c.JSON(http.StatusOK, post)
-	return
 }
@@ -0,0 +1,30 @@
+package restimpl
+
+import (
+	"github.com/gin-gonic/gin"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"net/http"
+)
+
+// GetTrash - lists the deleted blogUsers and blogPosts, most recently deleted first
+func GetTrash(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Trash request received.")
+
+	users, err := userRepository(c).Trash(c.Request.Context())
+	if err != nil {
+		logEntry.Errorf("User trash retrieval failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	posts, err := postRepository(c).Trash(c.Request.Context())
+	if err != nil {
+		logEntry.Errorf("Post trash retrieval failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.Info("Trash retrieved!")
+	c.JSON(http.StatusOK, restimpl.Trash{BlogUsers: users, BlogPosts: posts})
+}
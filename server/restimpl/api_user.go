@@ -8,41 +8,46 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
 	"github.com/gouthams/blogApp/server/utils"
 	"github.com/satori/go.uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"mime"
 	"net/http"
-	"strconv"
 	"time"
 )
 
 // AddBlogUsers - adds an blogUsers item
 func AddBlogUsers(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Debug("Post request received.")
 
 	contentType := c.Request.Header.Get("Content-type")
 	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
 		logEntry.Errorf("Unsupported content type : %s", contentType)
-		c.JSON(http.StatusUnsupportedMediaType, restimpl.Error{Code: "415", Message: contentType})
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
 		return
 	}
 
 	var blogUser restimpl.BlogUser
-	err := c.BindJSON(&blogUser)
+	err := c.ShouldBindJSON(&blogUser)
 	if err != nil {
 		logEntry.Errorf("Json parsing error %v", err)
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidBody(err))
 		return
 	}
-
-	isDup, err := getBlogUserByEmail(blogUser.Email, logEntry)
-	if isDup != (restimpl.BlogUser{}) {
-		logEntry.Errorf("User already exists %v", err)
-		c.JSON(http.StatusConflict, restimpl.Error{Code: "409", Message: "Email address is not unique"})
+	err = authorizeRole(c, blogUser.Role, DefaultRole)
+	if err != nil {
+		logEntry.Warnf("Role %s not allowed", blogUser.Role)
+		abortWithProblem(c, err)
+		return
+	}
+	if blogUser.Role == "" {
+		blogUser.Role = string(DefaultRole)
+	}
+	err = hashPassword(&blogUser)
+	if err != nil {
+		logEntry.Errorf("Password hashing failed %v", err)
+		abortWithProblem(c, err)
 		return
 	}
 
@@ -50,44 +55,60 @@ func AddBlogUsers(c *gin.Context) {
 	//Set the time in UTC
 	blogUser.LastModifiedDate = time.Now().UTC()
 	blogUser.Id = uuid.NewV4().String()
+	blogUser.Version = 1
+	blogUser.DeletedAt = nil
 
-	blogCollection, ctx := utils.GetUserCollection()
-	doc, err := blogCollection.InsertOne(ctx, blogUser)
+	//The email uniqueness is enforced by the store, a concurrent insert of the same email fails as well
+	err = userRepository(c).Insert(c.Request.Context(), blogUser)
+	if err == store.ErrDuplicate {
+		logEntry.Errorf("User already exists %v", err)
+		abortWithProblem(c, errEmailTaken(err))
+		return
+	}
 	if err != nil {
 		logEntry.Errorf("Insert failed %v", err)
+		abortWithProblem(c, err)
+		return
 	}
 
-	logEntry.Debugf("Document created %v doc: %v", doc.InsertedID)
+	logEntry.Debugf("Document created with id: %s", blogUser.Id)
 
-	user, err := getBlogUserByid(blogUser.Id, logEntry)
+	user, err := getBlogUserByid(c, blogUser.Id, logEntry)
 	if err != nil {
 		logEntry.Errorf("Retrieval failed!")
-		c.JSON(http.StatusInternalServerError, restimpl.Error{Code: "500", Message: err.Error()})
+		abortWithProblem(c, err)
 		return
 	}
 
 	logEntry.Infof("blogUser with id: %s created!", blogUser.Id)
+	c.Header("ETag", formatETag(user.Version))
 	c.JSON(http.StatusCreated, user)
 	return
 }
 
 // GetblogUsers - get a single blogUsers
 func GetblogUsers(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Debug("Get request received.")
 
 	id := c.Param("id")
 	if id, err := uuid.FromString(id); err != nil {
 		logEntry.Errorf("Invalid UUID: %s", id.String())
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidId(c))
 		return
 	}
 
-	user, err := getBlogUserByid(id, logEntry)
+	user, err := getBlogUserByid(c, id, logEntry)
 	if err != nil {
 		logEntry.Errorf("Retrieval failed!")
-		c.JSON(http.StatusNotFound, restimpl.Error{Code: "404", Message: err.Error()})
+		abortWithProblem(c, errNotFound(err, "User", id))
+		return
+	}
+
+	c.Header("ETag", formatETag(user.Version))
+	if ifNoneMatch(c, user.Version) {
+		logEntry.Debugf("User with id: %s not modified", id)
+		c.Status(http.StatusNotModified)
 		return
 	}
 
@@ -97,13 +118,8 @@ func GetblogUsers(c *gin.Context) {
 }
 
 // Helper method to get user based on the id
-func getBlogUserByid(id string, logEntry *utils.REntry) (restimpl.BlogUser, error) {
-	//Filter with the parameter id from the url
-	filter := bson.D{{"id", id}}
-
-	var user restimpl.BlogUser
-	blogCollection, ctx := utils.GetUserCollection()
-	err := blogCollection.FindOne(ctx, filter).Decode(&user)
+func getBlogUserByid(c *gin.Context, id string, logEntry *utils.REntry) (restimpl.BlogUser, error) {
+	user, err := userRepository(c).GetById(c.Request.Context(), id)
 	if err != nil {
 		logEntry.Errorf("Search failed %v", err)
 		return restimpl.BlogUser{}, err
@@ -112,13 +128,8 @@ func getBlogUserByid(id string, logEntry *utils.REntry) (restimpl.BlogUser, erro
 }
 
 // Helper method to get user based on the email
-func getBlogUserByEmail(email string, logEntry *utils.REntry) (restimpl.BlogUser, error) {
-	//Filter with the parameter email from the url
-	filter := bson.D{{"email", email}}
-
-	var user restimpl.BlogUser
-	blogCollection, ctx := utils.GetUserCollection()
-	err := blogCollection.FindOne(ctx, filter).Decode(&user)
+func getBlogUserByEmail(c *gin.Context, email string, logEntry *utils.REntry) (restimpl.BlogUser, error) {
+	user, err := userRepository(c).GetByEmail(c.Request.Context(), email)
 	if err != nil {
 		logEntry.Errorf("Search failed %v", err)
 		return restimpl.BlogUser{}, err
@@ -128,140 +139,223 @@ func getBlogUserByEmail(email string, logEntry *utils.REntry) (restimpl.BlogUser
 
 // SearchblogUsers - searches blogUsers
 func SearchblogUsers(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Info("Search request received.")
 
 	//Query string from the url
 	query := c.Request.URL.Query()
-	var filter bson.D
+	var filter store.UserFilter
 
 	name := query.Get("name")
 	if name == "" {
-		logEntry.Errorf("Invalid name to search search: %s. Ignores this filter", name)
 		//Empty filter to get all the records of user in the slice
-		filter = bson.D{}
+		logEntry.Errorf("Invalid name to search search: %s. Ignores this filter", name)
 	} else {
-		filter = bson.D{{"name", name}}
+		filter.Name = name
 	}
-	logEntry.Debugf("Filter criteria %v", filter)
 
-	findOptions := options.Find()
-	pageSize := query.Get("pageSize")
-	pageLimit, err := strconv.ParseInt(pageSize, 10, 64)
+	pageSize, token, err := parsePage(c)
 	if err != nil {
-		logEntry.Errorf("Invalid pageSize: %d. Ignores this filter", pageSize)
+		logEntry.Errorf("Invalid page: %v", err)
+		abortWithProblem(c, err)
+		return
 	}
-	findOptions.SetLimit(pageLimit)
+	//Fetch one extra document to know whether there is a next page
+	filter.PageSize = pageSize + 1
+	filter.After = token.Id
+	logEntry.Debugf("Filter criteria %+v", filter)
 
-	//Explicitly initialize the slice with empty value to return if none found
-	res := []restimpl.BlogUser{}
-	blogCollection, ctx := utils.GetUserCollection()
-	cursor, err := blogCollection.Find(ctx, filter, findOptions)
+	res, err := userRepository(c).Search(c.Request.Context(), filter)
 	if err != nil {
 		logEntry.Errorf("Search failed %v", err)
-		c.JSON(http.StatusNotFound, restimpl.Error{Code: "404", Message: err.Error()})
+		abortWithProblem(c, err)
 		return
 	}
+	logEntry.Debugf("%d documents retrieved", len(res))
 
-	for cursor.Next(ctx) {
-		logEntry.Debugf("Documents retrieved %v", cursor.Current)
-		var user restimpl.BlogUser
-		err := cursor.Decode(&user)
-		//If the is issue with one user log the error and continue
-		if err != nil {
-			logEntry.Errorf("Unable to decode user: %v", err)
-
-		}
-		res = append(res, user)
+	page := restimpl.BlogUserPage{Items: res}
+	if int64(len(res)) > pageSize {
+		page.Items = res[:pageSize]
+		page.NextPageToken = nextPage(c, pageToken{Id: page.Items[pageSize-1].Id})
 	}
 
 	logEntry.Info("BlogUser document search done!")
-	c.JSON(http.StatusOK, res)
-	return
+	c.JSON(http.StatusOK, page)
 }
 
 // UpdateBlogUsers - update an blogUsers item
 func UpdateBlogUsers(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Debug("Update request received.")
 
 	contentType := c.Request.Header.Get("Content-type")
 	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
 		logEntry.Errorf("Unsupported content type : %s", contentType)
-		c.JSON(http.StatusUnsupportedMediaType, restimpl.Error{Code: "415", Message: contentType})
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
 		return
 	}
 
 	id := c.Param("id")
 	if id, err := uuid.FromString(id); err != nil {
 		logEntry.Errorf("Invalid UUID: %s", id.String())
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidId(c))
 		return
 	}
 
 	var blogUser restimpl.BlogUser
-	err := c.BindJSON(&blogUser)
+	err := c.ShouldBindJSON(&blogUser)
 	if err != nil {
 		logEntry.Errorf("Json parsing error %v", err)
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidBody(err))
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		logEntry.Errorf("Invalid If-Match: %s", c.GetHeader("If-Match"))
+		preconditionFailed(c, id)
 		return
 	}
 
-	//update the time in UTC
-	blogUser.LastModifiedDate = time.Now().UTC()
 	blogUser.Id = id
+	saveUser(c, blogUser, version, logEntry)
+}
 
-	blogCollection, ctx := utils.GetUserCollection()
-	isDone, err := deleteUserById(id, logEntry)
-	if isDone == false {
-		logEntry.Errorf("Delete user failed")
-		c.JSON(http.StatusInternalServerError, restimpl.Error{Code: "500",
-			Message: fmt.Sprintf("Delete user with id: %s failed", id)})
+// PatchBlogUsers - partially update an blogUsers item
+func PatchBlogUsers(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Patch request received.")
+
+	contentType, ok := patchContentType(c)
+	if !ok {
+		logEntry.Errorf("Unsupported content type : %s", contentType)
+		abortWithProblem(c, errUnsupportedMediaType(contentType, mergePatchContentType, jsonPatchContentType))
+		return
+	}
+
+	id := c.Param("id")
+	if id, err := uuid.FromString(id); err != nil {
+		logEntry.Errorf("Invalid UUID: %s", id.String())
+		abortWithProblem(c, errInvalidId(c))
+		return
 	}
 
-	doc, err := blogCollection.InsertOne(ctx, blogUser)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		logEntry.Errorf("Invalid If-Match: %s", c.GetHeader("If-Match"))
+		preconditionFailed(c, id)
+		return
+	}
+
+	current, err := getBlogUserByid(c, id, logEntry)
 	if err != nil {
-		logEntry.Errorf("Insert failed %v", err)
+		logEntry.Errorf("Retrieval failed!")
+		abortWithProblem(c, errNotFound(err, "User", id))
+		return
+	}
+	//Without If-Match the patch is still applied only on top of the version it was computed from
+	if version == store.AnyVersion {
+		version = current.Version
+	}
+
+	var blogUser restimpl.BlogUser
+	err = applyPatch(c, contentType, current, &blogUser)
+	if err != nil {
+		logEntry.Errorf("Patch failed %v", err)
+		abortWithProblem(c, err)
+		return
 	}
 
-	logEntry.Infof("Document created %v doc: %v", doc.InsertedID, doc)
+	//The id is readonly, it can not be changed by the patch
+	blogUser.Id = id
+	saveUser(c, blogUser, version, logEntry)
+}
 
-	user, err := getBlogUserByid(blogUser.Id, logEntry)
+// Helper function to persist an updated user and write the response, shared by PUT and PATCH
+func saveUser(c *gin.Context, blogUser restimpl.BlogUser, version int64, logEntry *utils.REntry) {
+	id := blogUser.Id
+	//update the time in UTC
+	blogUser.LastModifiedDate = time.Now().UTC()
+	//Only a delete moves the user to the trash
+	blogUser.DeletedAt = nil
+	//Only an admin changes the roles, for the others the stored role is kept, nor the email of a linked user
+	caller, _ := principal(c)
+	if !caller.Role.includes(RoleAdmin) {
+		current, err := getBlogUserByid(c, id, logEntry)
+		if err == nil {
+			err = authorizeRole(c, blogUser.Role, userRole(current))
+		}
+		if err == nil {
+			err = authorizeEmail(c, blogUser.Email, current)
+		}
+		if err != nil && err != store.ErrNotFound {
+			logEntry.Warnf("Update of user with id: %s not allowed", id)
+			abortWithProblem(c, err)
+			return
+		}
+		blogUser.Role = ""
+	}
+	//Without a new password the stored hash is kept
+	err := hashPassword(&blogUser)
+	if err != nil {
+		logEntry.Errorf("Password hashing failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	//Replace the user in place so that it is never missing for concurrent readers
+	err = userRepository(c).Replace(c.Request.Context(), blogUser, version)
+	if err == store.ErrVersionMismatch {
+		logEntry.Errorf("Version of user with id: %s does not match", id)
+		preconditionFailed(c, id)
+		return
+	}
+	if err == store.ErrNotFound {
+		logEntry.Errorf("Unable to get the user with id: %s", id)
+		abortWithProblem(c, errNotFound(err, "User", id))
+		return
+	}
+	if err == store.ErrDuplicate {
+		logEntry.Errorf("Email already used by another user %v", err)
+		abortWithProblem(c, errEmailTaken(err))
+		return
+	}
+	if err != nil {
+		logEntry.Errorf("Replace failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.Debugf("Document updated with id: %s", blogUser.Id)
+
+	user, err := getBlogUserByid(c, blogUser.Id, logEntry)
 	if err != nil {
 		logEntry.Errorf("Retrieval failed!")
-		c.JSON(http.StatusInternalServerError, restimpl.Error{Code: "500", Message: err.Error()})
+		abortWithProblem(c, err)
 		return
 	}
 
 	logEntry.Infof("blogUser with id: %s updated!", blogUser.Id)
+	c.Header("ETag", formatETag(user.Version))
 	c.JSON(http.StatusOK, user)
-	return
 }
 
 //Helper function to delete user by the given Id
-func deleteUserById(id string, logEntry *utils.REntry) (bool, error) {
-	//Delete the blogUser
-	deleteFilter := bson.D{{"id", id}}
-
-	blogCollection, ctx := utils.GetUserCollection()
-	//Check to see if user exist
-	_, err := getBlogUserByid(id, logEntry)
-	if err != nil {
-		// If get user fails that means the user is not in the system, delete will be treated as success.
-		// TODO: If the failure is not able to connect to DB this behaviour needs to be changed.
+func deleteUserById(c *gin.Context, id string, version int64, onPosts store.OnPosts,
+	logEntry *utils.REntry) (bool, error) {
+	//Delete the blogUser along with the posts as the policy says
+	err := userRepository(c).DeleteById(c.Request.Context(), id, version, onPosts)
+	if err == store.ErrNotFound && version != store.AnyVersion {
+		// A conditional delete can not match a missing user
+		return false, store.ErrVersionMismatch
+	}
+	if err == store.ErrNotFound {
+		// If the user is not in the system, delete will be treated as success.
 		logEntry.Errorf("Unable to get the user with id: %s. Error : %v", id, err)
 		return true, nil
 	}
-
-	deletedUser, err := blogCollection.DeleteOne(ctx, deleteFilter)
 	if err != nil {
 		logEntry.Errorf("Delete failed %v", err)
-	}
-
-	if deletedUser.DeletedCount != 1 {
-		logEntry.Errorf("Delete count is not 1 %d", deletedUser.DeletedCount)
 		return false, err
 	}
 	return true, nil
@@ -269,33 +363,95 @@ func deleteUserById(id string, logEntry *utils.REntry) (bool, error) {
 
 // DeleteBlogUsers - deletes an blogUsers item
 func DeleteBlogUsers(c *gin.Context) {
-	logEntry := utils.Log().WithFields(utils.Fields{"url": c.Request.URL,
-		"Method": c.Request.Method})
+	logEntry := requestLog(c)
 	logEntry.Debug("Delete request received.")
 
 	contentType := c.Request.Header.Get("Content-type")
 	if contentType, _, err := mime.ParseMediaType(contentType); contentType != "application/json" || err != nil {
 		logEntry.Errorf("Unsupported content type : %s", contentType)
-		c.JSON(http.StatusUnsupportedMediaType, restimpl.Error{Code: "415", Message: contentType})
+		abortWithProblem(c, errUnsupportedMediaType(contentType))
 		return
 	}
 
 	id := c.Param("id")
 	if id, err := uuid.FromString(id); err != nil {
 		logEntry.Errorf("Invalid UUID: %s", id.String())
-		c.JSON(http.StatusBadRequest, restimpl.Error{Code: "400", Message: err.Error()})
+		abortWithProblem(c, errInvalidId(c))
 		return
 	}
 
-	isDone, _ := deleteUserById(id, logEntry)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		logEntry.Errorf("Invalid If-Match: %s", c.GetHeader("If-Match"))
+		preconditionFailed(c, id)
+		return
+	}
+
+	onPosts := routerConfig(c).OnPosts
+	if value, ok := c.GetQuery("onPosts"); ok {
+		var err error
+		if onPosts, err = store.ParseOnPosts(value); err != nil {
+			logEntry.Errorf("Invalid onPosts: %s", value)
+			abortWithProblem(c, errInvalidParam("onPosts", "%v", err))
+			return
+		}
+	}
+
+	isDone, err := deleteUserById(c, id, version, onPosts, logEntry)
+	if err == store.ErrVersionMismatch {
+		logEntry.Errorf("Version of user with id: %s does not match", id)
+		preconditionFailed(c, id)
+		return
+	}
+	if err == store.ErrHasPosts {
+		logEntry.Errorf("User with id: %s still has posts", id)
+		abortWithProblem(c, errConflict(CodeUserHasPosts,
+			fmt.Sprintf("User with id: %s still has posts, delete them or pass onPosts=%s or onPosts=%s",
+				id, store.OnPostsCascade, store.OnPostsReassign), err))
+		return
+	}
 	if isDone == false {
 		logEntry.Errorf("Delete user failed")
-		c.JSON(http.StatusInternalServerError, restimpl.Error{Code: "500",
-			Message: fmt.Sprintf("Delete user with id: %s failed", id)})
+		abortWithProblem(c, err)
+		return
 	}
 
 	logEntry.Infof("blogUser with id: %s deleted!", id)
-	c.JSON(http.StatusNoContent, restimpl.Error{Code: "204",
-		Message: fmt.Sprintf("Delete user with id: %s Succeeded",
-			id)})
+	c.Status(http.StatusNoContent)
+}
+
+// RestoreBlogUsers - takes a blogUsers item out of the trash
+func RestoreBlogUsers(c *gin.Context) {
+	logEntry := requestLog(c)
+	logEntry.Debug("Restore request received.")
+
+	id := c.Param("id")
+	if id, err := uuid.FromString(id); err != nil {
+		logEntry.Errorf("Invalid UUID: %s", id.String())
+		abortWithProblem(c, errInvalidId(c))
+		return
+	}
+
+	err := userRepository(c).Restore(c.Request.Context(), id)
+	if err == store.ErrNotFound {
+		logEntry.Errorf("User with id: %s is not in the trash", id)
+		abortWithProblem(c, errNotFound(err, "User in the trash", id))
+		return
+	}
+	if err != nil {
+		logEntry.Errorf("Restore failed %v", err)
+		abortWithProblem(c, err)
+		return
+	}
+
+	user, err := getBlogUserByid(c, id, logEntry)
+	if err != nil {
+		logEntry.Errorf("Retrieval failed!")
+		abortWithProblem(c, err)
+		return
+	}
+
+	logEntry.Infof("blogUsers with id: %s restored!", id)
+	c.Header("ETag", formatETag(user.Version))
+	c.JSON(http.StatusOK, user)
 }
@@ -0,0 +1,309 @@
+package restimpl
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MinSecretLength is the minimum length in bytes of the secret of a signing key
+const MinSecretLength = 32
+
+const principalKey = "principal"
+
+// Uses of the tokens, a refresh token can not authenticate a request and an access token can not be refreshed
+const (
+	accessTokenUse  = "access"
+	refreshTokenUse = "refresh"
+)
+
+// AuthConfig configures the tokens authenticating the requests.
+type AuthConfig struct {
+	// Issuer is the iss claim of the issued tokens, only the tokens of this issuer are accepted.
+	Issuer string `yaml:"issuer"`
+	// AccessTokenTTL is how long an access token authenticates the requests.
+	AccessTokenTTL time.Duration `yaml:"accessTokenTTL"`
+	// RefreshTokenTTL is how long a refresh token can be exchanged for new tokens.
+	RefreshTokenTTL time.Duration `yaml:"refreshTokenTTL"`
+	// Keys sign and verify the tokens. The first one signs the new tokens, every one of them verifies the tokens
+	// it signed, so that a key can be rotated without invalidating the tokens in use.
+	Keys []SigningKey `yaml:"keys"`
+	// Oidc configures the login with an OpenID Connect identity provider, disabled unless its issuer is set.
+	Oidc OidcConfig `yaml:"oidc"`
+}
+
+// SigningKey is an HMAC-SHA256 key, its Id is the kid header of the tokens it signs.
+type SigningKey struct {
+	Id     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+// DefaultAuthConfig returns the token lifetimes used unless configured otherwise, the keys have to be configured.
+func DefaultAuthConfig() AuthConfig {
+	return AuthConfig{Issuer: "blogApp", AccessTokenTTL: 15 * time.Minute, RefreshTokenTTL: 7 * 24 * time.Hour,
+		Oidc: DefaultOidcConfig()}
+}
+
+// GenerateSigningKey returns a random key, for the servers started without configured keys. The tokens it signs
+// are not accepted by the other servers and do not survive a restart.
+func GenerateSigningKey() (SigningKey, error) {
+	secret := make([]byte, MinSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return SigningKey{}, err
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{Id: "generated-" + hex.EncodeToString(id), Secret: base64.RawStdEncoding.EncodeToString(secret)},
+		nil
+}
+
+// Auth issues the tokens of the users and verifies the tokens of the requests.
+type Auth struct {
+	config  AuthConfig
+	signing SigningKey
+	keys    map[string][]byte
+}
+
+// NewAuth returns the Auth of the configuration, or an error when the configuration is not valid.
+func NewAuth(config AuthConfig) (*Auth, error) {
+	if config.Issuer == "" {
+		return nil, errors.New("issuer is required")
+	}
+	if config.AccessTokenTTL <= 0 || config.RefreshTokenTTL <= 0 {
+		return nil, errors.New("accessTokenTTL and refreshTokenTTL must be positive")
+	}
+	if len(config.Keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+	auth := &Auth{config: config, signing: config.Keys[0], keys: make(map[string][]byte, len(config.Keys))}
+	for _, key := range config.Keys {
+		if key.Id == "" {
+			return nil, errors.New("every key needs an id")
+		}
+		if _, ok := auth.keys[key.Id]; ok {
+			return nil, fmt.Errorf("key id %s is used more than once", key.Id)
+		}
+		if len(key.Secret) < MinSecretLength {
+			return nil, fmt.Errorf("the secret of key %s is shorter than %d bytes", key.Id, MinSecretLength)
+		}
+		auth.keys[key.Id] = []byte(key.Secret)
+	}
+	return auth, nil
+}
+
+// tokenClaims are the claims of the access and the refresh tokens, the subject is the id of the user and the id
+// identifies a refresh token when it is redeemed
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	TokenUse string `json:"token_use"`
+	Role     Role   `json:"role,omitempty"`
+	// Generation is the TokenGeneration of the user a refresh token is issued to
+	Generation int64 `json:"gen,omitempty"`
+}
+
+// issue returns a new access token and a new refresh token of the caller, the refresh token is revoked once the
+// token generation of the user moves past the given one
+func (a *Auth) issue(caller Principal, generation int64) (restimpl.TokenPair, error) {
+	accessToken, err := a.sign(caller, accessTokenUse, a.config.AccessTokenTTL, 0)
+	if err != nil {
+		return restimpl.TokenPair{}, err
+	}
+	refreshToken, err := a.sign(caller, refreshTokenUse, a.config.RefreshTokenTTL, generation)
+	if err != nil {
+		return restimpl.TokenPair{}, err
+	}
+	return restimpl.TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, TokenType: "Bearer",
+		ExpiresIn: int64(a.config.AccessTokenTTL.Seconds())}, nil
+}
+
+// Helper function to sign a token of the caller for the given use
+func (a *Auth) sign(caller Principal, use string, ttl time.Duration, generation int64) (string, error) {
+	now := time.Now()
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    a.config.Issuer,
+			Subject:   caller.UserId,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			ID:        uuid.NewV4().String(),
+		},
+		TokenUse:   use,
+		Role:       caller.Role,
+		Generation: generation,
+	}
+	return a.signClaims(claims)
+}
+
+// Helper function to sign the claims with the signing key
+func (a *Auth) signClaims(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = a.signing.Id
+	return token.SignedString([]byte(a.signing.Secret))
+}
+
+// Helper function to parse the claims of an unexpired token of the issuer signed by one of the keys
+func (a *Auth) parseClaims(rawToken string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := a.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(a.config.Issuer),
+		jwt.WithExpirationRequired())
+	return err
+}
+
+// verify returns the caller of a valid token for the given use
+func (a *Auth) verify(rawToken string, use string) (Principal, error) {
+	claims, err := a.verifyClaims(rawToken, use)
+	if err != nil {
+		return Principal{}, err
+	}
+	return claims.principal(), nil
+}
+
+// verifyClaims returns the claims of a valid token for the given use
+func (a *Auth) verifyClaims(rawToken string, use string) (tokenClaims, error) {
+	var claims tokenClaims
+	if err := a.parseClaims(rawToken, &claims); err != nil {
+		return tokenClaims{}, err
+	}
+	if claims.TokenUse != use {
+		return tokenClaims{}, fmt.Errorf("%s token used as %s token", claims.TokenUse, use)
+	}
+	if claims.Subject == "" {
+		return tokenClaims{}, errors.New("token without subject")
+	}
+	return claims, nil
+}
+
+// principal is the caller the token was issued to
+func (claims tokenClaims) principal() Principal {
+	//The tokens issued before the roles are of users with the default role
+	role := claims.Role
+	if role == "" {
+		role = DefaultRole
+	}
+	return Principal{UserId: claims.Subject, Role: role}
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// UserId is the id of the blogUser the request is made by
+	UserId string
+	// Role is the role of the blogUser when the token was issued, a new role applies from the next refresh
+	Role Role
+	// ApiKeyId is the id of the api key authenticating the request, empty for a token
+	ApiKeyId string
+	// Scopes are the scopes of the api key, the tokens are not limited by scopes
+	Scopes []string
+}
+
+// authenticate verifies the bearer token or the api key of the request and makes its Principal available to the
+// handlers. A request without credentials is anonymous, it is rejected unless the policy of its route allows anonymous
+// requests.
+func authenticate(auth *Auth, s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			if !policies[routeName(c)].Anonymous {
+				requestLog(c).Warn("Unauthenticated request")
+				abortWithProblem(c, errUnauthenticated())
+				return
+			}
+			c.Next()
+			return
+		}
+
+		var caller Principal
+		var err error
+		scheme, credentials, _ := strings.Cut(header, " ")
+		credentials = strings.TrimSpace(credentials)
+		switch {
+		case strings.EqualFold(scheme, "Bearer"):
+			caller, err = auth.verify(credentials, accessTokenUse)
+			if err != nil {
+				requestLog(c).Warnf("Invalid access token: %v", err)
+				abortWithProblem(c, errInvalidToken(err))
+				return
+			}
+		case strings.EqualFold(scheme, apiKeyScheme):
+			caller, err = verifyApiKey(c, s, credentials)
+			if err != nil {
+				requestLog(c).Warnf("Invalid api key: %v", err)
+				abortWithProblem(c, err)
+				return
+			}
+		default:
+			requestLog(c).Warnf("Unsupported authorization scheme %s", scheme)
+			abortWithProblem(c, errInvalidToken(fmt.Errorf("unsupported authorization scheme %s", scheme)))
+			return
+		}
+
+		c.Set(principalKey, caller)
+		entry := requestLog(c).WithField("user", caller.UserId)
+		if caller.ApiKeyId != "" {
+			entry = entry.WithField("apiKey", caller.ApiKeyId)
+		}
+		setRequestLog(c, entry)
+		c.Next()
+	}
+}
+
+// principal returns the authenticated caller of the request, false for an anonymous request
+func principal(c *gin.Context) (Principal, bool) {
+	if value, ok := c.Get(principalKey); ok {
+		return value.(Principal), true
+	}
+	return Principal{}, false
+}
+
+// errUnauthenticated is the error of a request without credentials to a route requiring them
+func errUnauthenticated() *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthenticated,
+		Detail: "The request requires a bearer token", Challenge: `Bearer realm="blogApp"`}
+}
+
+// errInvalidToken is the error of a token that is malformed, expired, or not signed by a known key
+func errInvalidToken(err error) *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Code: CodeInvalidToken,
+		Detail: "The token is not valid or has expired", Challenge: `Bearer realm="blogApp", error="invalid_token"`,
+		Err: err}
+}
+
+// Helper function to make the Auth of a router configured without one
+func generatedAuth() *Auth {
+	config := DefaultAuthConfig()
+	key, err := GenerateSigningKey()
+	if err != nil {
+		//crypto/rand only fails when the platform has no source of randomness
+		panic(fmt.Sprintf("generate a signing key: %v", err))
+	}
+	config.Keys = []SigningKey{key}
+	auth, err := NewAuth(config)
+	if err != nil {
+		panic(fmt.Sprintf("generated auth config: %v", err))
+	}
+	return auth
+}
+
+// errInvalidCredentials is the error of a login with an unknown email or a wrong password
+func errInvalidCredentials() *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Code: CodeInvalidCredentials,
+		Detail: "The email or the password is not valid"}
+}
@@ -0,0 +1,90 @@
+package restimpl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthKeyRotation(t *testing.T) {
+	oldKey := SigningKey{Id: "old", Secret: strings.Repeat("o", MinSecretLength)}
+	newKey := SigningKey{Id: "new", Secret: strings.Repeat("n", MinSecretLength)}
+	config := DefaultAuthConfig()
+
+	config.Keys = []SigningKey{oldKey}
+	before, err := NewAuth(config)
+	assert.Nil(t, err)
+	tokens, err := before.issue(Principal{UserId: "user-1", Role: RoleAuthor}, 0)
+	assert.Nil(t, err)
+
+	//The tokens of the old key are accepted as long as it is configured
+	config.Keys = []SigningKey{newKey, oldKey}
+	during, err := NewAuth(config)
+	assert.Nil(t, err)
+	caller, err := during.verify(tokens.AccessToken, accessTokenUse)
+	assert.Nil(t, err)
+	assert.Equal(t, Principal{UserId: "user-1", Role: RoleAuthor}, caller)
+	rotated, err := during.issue(Principal{UserId: "user-1", Role: RoleAuthor}, 0)
+	assert.Nil(t, err)
+	_, err = before.verify(rotated.AccessToken, accessTokenUse)
+	assert.Error(t, err)
+
+	config.Keys = []SigningKey{newKey}
+	after, err := NewAuth(config)
+	assert.Nil(t, err)
+	_, err = after.verify(tokens.AccessToken, accessTokenUse)
+	assert.Error(t, err)
+	_, err = after.verify(rotated.AccessToken, accessTokenUse)
+	assert.Nil(t, err)
+
+	//Another issuer is not trusted
+	config.Issuer = "someone else"
+	other, err := NewAuth(config)
+	assert.Nil(t, err)
+	_, err = other.verify(rotated.AccessToken, accessTokenUse)
+	assert.Error(t, err)
+}
+
+func TestAuthExpiry(t *testing.T) {
+	config := DefaultAuthConfig()
+	config.Keys = []SigningKey{{Id: "key", Secret: strings.Repeat("k", MinSecretLength)}}
+	config.AccessTokenTTL = time.Nanosecond
+	auth, err := NewAuth(config)
+	assert.Nil(t, err)
+	tokens, err := auth.issue(Principal{UserId: "user-1", Role: RoleAuthor}, 0)
+	assert.Nil(t, err)
+	time.Sleep(time.Second)
+	_, err = auth.verify(tokens.AccessToken, accessTokenUse)
+	assert.Error(t, err)
+	_, err = auth.verify(tokens.RefreshToken, refreshTokenUse)
+	assert.Nil(t, err)
+}
+
+func TestNewAuthValidation(t *testing.T) {
+	config := DefaultAuthConfig()
+	_, err := NewAuth(config)
+	assert.Error(t, err)
+
+	config.Keys = []SigningKey{{Id: "short", Secret: "secret"}}
+	_, err = NewAuth(config)
+	assert.Error(t, err)
+
+	secret := strings.Repeat("s", MinSecretLength)
+	config.Keys = []SigningKey{{Id: "twice", Secret: secret}, {Id: "twice", Secret: secret}}
+	_, err = NewAuth(config)
+	assert.Error(t, err)
+
+	config.Keys = []SigningKey{{Id: "key", Secret: secret}}
+	config.AccessTokenTTL = 0
+	_, err = NewAuth(config)
+	assert.Error(t, err)
+}
+
+func TestEveryRouteHasAPolicy(t *testing.T) {
+	for _, route := range routes {
+		_, ok := policies[route.Name]
+		assert.True(t, ok, route.Name)
+	}
+}
@@ -0,0 +1,182 @@
+package restimpl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+// Role is the role of a blogUser, every role can do what the roles below it can.
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleAuthor Role = "author"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// DefaultRole is the role of the users created without one and of the users stored before the roles.
+const DefaultRole = RoleAuthor
+
+var roleRanks = map[Role]int{RoleReader: 1, RoleAuthor: 2, RoleEditor: 3, RoleAdmin: 4}
+
+// ParseRole returns the Role of the given name.
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q, use %s, %s, %s or %s", name, RoleReader, RoleAuthor, RoleEditor,
+			RoleAdmin)
+	}
+	return role, nil
+}
+
+// includes tells whether the role can do what the other role can, no role includes the empty one
+func (r Role) includes(other Role) bool {
+	return other != "" && roleRanks[r] >= roleRanks[other]
+}
+
+// userRole returns the role of the user, the users stored before the roles have the DefaultRole
+func userRole(user restimpl.BlogUser) Role {
+	if user.Role == "" {
+		return DefaultRole
+	}
+	return Role(user.Role)
+}
+
+// ownerFunc returns the id of the user owning the resource of the request
+type ownerFunc func(c *gin.Context) (string, error)
+
+// policy is who may call a Route, the routes without a policy are forbidden to everyone.
+type policy struct {
+	// Anonymous allows the requests without a token
+	Anonymous bool
+	// Any is the least role allowed on every resource of the route, empty when no role is
+	Any Role
+	// Own is the least role allowed on the resources owned by the caller, empty when owning grants nothing
+	Own Role
+	// Owner finds the owner of the resource of the request, without it the handler checks the owner of the body
+	// with authorizeOwner
+	Owner ownerFunc
+	// Interactive routes need the token of a login, the api keys are not allowed on them
+	Interactive bool
+}
+
+// authorize rejects the requests the policy of their route does not allow with a forbidden problem. The requests
+// authenticated by an api key also need its scope, on the anonymous routes as well. A resource whose owner is not
+// found is left to the handler, which answers as it would for anyone.
+func authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy, ok := policies[routeName(c)]
+		if !ok {
+			requestLog(c).Errorf("No policy for route %s", routeName(c))
+			abortWithProblem(c, errForbidden("The api is not available"))
+			return
+		}
+		//The scopes of a key also limit the anonymous routes, whose handlers may rely on the role of the caller
+		caller, _ := principal(c)
+		if caller.ApiKeyId != "" {
+			if policy.Interactive {
+				requestLog(c).Warnf("Api key used on interactive route %s", routeName(c))
+				abortWithProblem(c, errForbidden(fmt.Sprintf("%s %s needs the token of a login, not an api key",
+					c.Request.Method, c.FullPath())))
+				return
+			}
+			if scope := requiredScope(c.Request.Method); !containsScope(caller.Scopes, scope) {
+				requestLog(c).Warnf("Api key without the %s scope on route %s", scope, routeName(c))
+				abortWithProblem(c, errForbidden(fmt.Sprintf("The api key needs the %s scope", scope)))
+				return
+			}
+		}
+		if policy.Anonymous || caller.Role.includes(policy.Any) {
+			c.Next()
+			return
+		}
+		if !caller.Role.includes(policy.Own) {
+			requestLog(c).Warnf("Role %s is not allowed on route %s", caller.Role, routeName(c))
+			abortWithProblem(c, errForbidden(fmt.Sprintf("The %s role is not allowed to %s %s", caller.Role,
+				c.Request.Method, c.FullPath())))
+			return
+		}
+		if policy.Owner == nil {
+			c.Next()
+			return
+		}
+
+		ownerId, err := policy.Owner(c)
+		if errors.Is(err, store.ErrNotFound) {
+			c.Next()
+			return
+		}
+		if err != nil {
+			abortWithProblem(c, err)
+			return
+		}
+		if ownerId != caller.UserId {
+			requestLog(c).Warnf("User is not the owner %s on route %s", ownerId, routeName(c))
+			abortWithProblem(c, errNotOwner(caller.Role))
+			return
+		}
+		c.Next()
+	}
+}
+
+// authorizeOwner returns a forbidden problem unless the policy of the route allows the caller on the resources of
+// the given owner, for the owners the handlers only know from the request body
+func authorizeOwner(c *gin.Context, ownerId string) error {
+	policy := policies[routeName(c)]
+	caller, _ := principal(c)
+	if caller.Role.includes(policy.Any) || (caller.Role.includes(policy.Own) && ownerId == caller.UserId) {
+		return nil
+	}
+	return errNotOwner(caller.Role)
+}
+
+// authorizeRole returns a forbidden problem unless the caller may give a user the role, only an admin can change
+// the current role of a user
+func authorizeRole(c *gin.Context, role string, current Role) error {
+	caller, _ := principal(c)
+	if role == "" || Role(role) == current || caller.Role.includes(RoleAdmin) {
+		return nil
+	}
+	return errForbidden(fmt.Sprintf("Only the %s role can give the %s role", RoleAdmin, role))
+}
+
+// authorizeEmail returns a forbidden problem unless the caller may give the user the email, the email of a user linked
+// to the identity provider was verified by it and only an admin can change it
+func authorizeEmail(c *gin.Context, email string, current restimpl.BlogUser) error {
+	caller, _ := principal(c)
+	if email == current.Email || !current.OidcLinked || caller.Role.includes(RoleAdmin) {
+		return nil
+	}
+	return errForbidden(fmt.Sprintf("Only the %s role can change the email of a user linked to the identity provider",
+		RoleAdmin))
+}
+
+// pathUser is the owner of the user of the id parameter, the user itself
+func pathUser(c *gin.Context) (string, error) {
+	return c.Param("id"), nil
+}
+
+// pathPostOwner is the owner of the post of the id parameter
+func pathPostOwner(c *gin.Context) (string, error) {
+	post, err := postRepository(c).GetById(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return "", err
+	}
+	return post.UserId, nil
+}
+
+// errForbidden is the error of an authenticated request its caller is not allowed to make
+func errForbidden(detail string) *APIError {
+	return &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Detail: detail}
+}
+
+// errNotOwner is the error of a request on a resource of another user the role of its caller is not allowed on
+func errNotOwner(role Role) *APIError {
+	return errForbidden(fmt.Sprintf("The %s role is only allowed on the resources of its own user", role))
+}
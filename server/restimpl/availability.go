@@ -0,0 +1,21 @@
+package restimpl
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+// failFast rejects the requests with 503 while the store reports its database unavailable,
+// rather than letting them wait for the database to time out
+func failFast(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		health := s.Health()
+		if health.Available() {
+			c.Next()
+			return
+		}
+
+		requestLog(c).Warnf("Db unavailable, state: %s, breaker: %s", health.State, health.Breaker)
+		abortWithProblem(c, errUnavailable(health.RetryAfter))
+	}
+}
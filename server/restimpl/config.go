@@ -0,0 +1,98 @@
+package restimpl
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+const configKey = "routerConfig"
+
+// DefaultMaxPageSize is the maximum pageSize of the search apis unless configured otherwise.
+const DefaultMaxPageSize = 50
+
+// Config holds the tunables of the api handlers.
+type Config struct {
+	// MaxPageSize caps the pageSize of the search apis and is used when no pageSize is given.
+	MaxPageSize int64
+	// OnPosts is what deleting a user does to their posts when the request has no onPosts parameter.
+	OnPosts store.OnPosts
+	// Lifecycle fails the readiness probe once the server is shutting down.
+	Lifecycle *Lifecycle
+	// Metrics records the requests and is served on /metrics.
+	Metrics *Metrics
+	// Auth issues and verifies the tokens of the users.
+	Auth *Auth
+	// Oidc logs the users in with an identity provider, the oidc login apis answer not found without it.
+	Oidc *Oidc
+}
+
+// Option customizes the Config of NewRouter.
+type Option func(*Config)
+
+// WithMaxPageSize sets the maximum pageSize of the search apis.
+func WithMaxPageSize(maxPageSize int64) Option {
+	return func(config *Config) {
+		config.MaxPageSize = maxPageSize
+	}
+}
+
+// WithOnPosts sets the default policy applied to the posts of deleted users.
+func WithOnPosts(onPosts store.OnPosts) Option {
+	return func(config *Config) {
+		config.OnPosts = onPosts
+	}
+}
+
+// WithLifecycle sets the Lifecycle the readiness probe reports the shutdown of.
+func WithLifecycle(lifecycle *Lifecycle) Option {
+	return func(config *Config) {
+		config.Lifecycle = lifecycle
+	}
+}
+
+// WithMetrics sets the Metrics recording the requests, the store given to NewRouter is not observed by them unless
+// it is wrapped with Store.Observe.
+func WithMetrics(metrics *Metrics) Option {
+	return func(config *Config) {
+		config.Metrics = metrics
+	}
+}
+
+// WithAuth sets the Auth of the tokens, without it the tokens are signed with a generated key.
+func WithAuth(auth *Auth) Option {
+	return func(config *Config) {
+		config.Auth = auth
+	}
+}
+
+// WithOidc sets the identity provider of the oidc login.
+func WithOidc(oidc *Oidc) Option {
+	return func(config *Config) {
+		config.Oidc = oidc
+	}
+}
+
+func newConfig(options []Option) Config {
+	config := Config{MaxPageSize: DefaultMaxPageSize, OnPosts: store.OnPostsReject, Lifecycle: &Lifecycle{},
+		Metrics: NewMetrics()}
+	for _, option := range options {
+		option(&config)
+	}
+	if config.Auth == nil {
+		config.Auth = generatedAuth()
+	}
+	return config
+}
+
+// injectConfig makes the router configuration available to every handler
+func injectConfig(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(configKey, config)
+		c.Next()
+	}
+}
+
+// routerConfig returns the Config injected by NewRouter
+func routerConfig(c *gin.Context) Config {
+	return c.MustGet(configKey).(Config)
+}
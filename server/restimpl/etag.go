@@ -0,0 +1,63 @@
+package restimpl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+// formatETag returns the strong entity tag of the given document version
+func formatETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseETag returns the version of the given strong entity tag
+func parseETag(etag string) (int64, bool) {
+	etag = strings.TrimSpace(etag)
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(etag[1:len(etag)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// ifMatchVersion returns the version the If-Match header of the request expects.
+// A missing header or "*" accepts any version. Only a single entity tag is supported,
+// false is returned when the header can not match any version.
+func ifMatchVersion(c *gin.Context) (int64, bool) {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return store.AnyVersion, true
+	}
+	return parseETag(ifMatch)
+}
+
+// ifNoneMatch reports whether the If-None-Match header of the request matches the given version,
+// using the weak comparison.
+func ifNoneMatch(c *gin.Context, version int64) bool {
+	ifNoneMatch := c.GetHeader("If-None-Match")
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, etag := range strings.Split(ifNoneMatch, ",") {
+		etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+		if etag == "*" {
+			return true
+		}
+		if parsed, ok := parseETag(etag); ok && parsed == version {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed aborts the request when the If-Match header does not match the stored version
+func preconditionFailed(c *gin.Context, id string) {
+	abortWithProblem(c, errPreconditionFailed(id))
+}
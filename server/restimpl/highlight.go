@@ -0,0 +1,82 @@
+package restimpl
+
+import (
+	"html"
+	"strings"
+
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+// snippetLength is the approximate length in bytes of the content snippet of a search hit
+const snippetLength = 200
+
+// highlightPost returns the topic and a content snippet of the post with the given words highlighted
+func highlightPost(post restimpl.BlogPost, words []string) restimpl.BlogPostHighlights {
+	return restimpl.BlogPostHighlights{
+		Topic:   highlight(post.Topic, words, 0),
+		Content: highlight(post.Content, words, snippetLength),
+	}
+}
+
+// highlight html escapes text and wraps the given words in <em> tags. When maxLength is positive the
+// text is cut down to about maxLength bytes around the first match, on word boundaries.
+// An empty string is returned when none of the words occurs in text.
+func highlight(text string, words []string, maxLength int) string {
+	spans := store.WordSpans(text)
+	matched := make([]bool, len(spans))
+	first := -1
+	for i, span := range spans {
+		if containsWord(words, strings.ToLower(text[span[0]:span[1]])) {
+			matched[i] = true
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	start, end := 0, len(text)
+	if maxLength > 0 && len(text) > maxLength {
+		//Start a quarter of the snippet before the first match, at the beginning of a word
+		start = spans[first][0]
+		for i := first; i >= 0 && spans[first][0]-spans[i][0] <= maxLength/4; i-- {
+			start = spans[i][0]
+		}
+		//End at the last word fitting in the snippet
+		end = spans[first][1]
+		for i := first; i < len(spans) && spans[i][1]-start <= maxLength; i++ {
+			end = spans[i][1]
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for i, span := range spans {
+		if !matched[i] || span[0] < start || span[1] > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:span[0]]))
+		b.WriteString("<em>" + html.EscapeString(text[span[0]:span[1]]) + "</em>")
+		pos = span[1]
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func containsWord(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package restimpl
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of the store operations
+const (
+	outcomeOk          = "ok"
+	outcomeUnavailable = "unavailable"
+	outcomeError       = "error"
+)
+
+// Metrics collects the prometheus metrics of the http requests and of the store operations.
+type Metrics struct {
+	registry          *prometheus.Registry
+	requestDuration   *prometheus.HistogramVec
+	operationDuration *prometheus.HistogramVec
+	operationErrors   *prometheus.CounterVec
+}
+
+// NewMetrics returns the metrics of a server, along with the go runtime and process metrics.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "blog_http_request_duration_seconds",
+			Help:    "Latency of the http requests by route name and response status, its count is the request count.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "status"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "blog_db_operation_duration_seconds",
+			Help:    "Latency of the store operations by repository, operation and outcome.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"repository", "operation", "outcome"}),
+		operationErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "blog_db_operation_errors_total",
+			Help: "Store operations failed to run by repository, operation and outcome, unavailable or error.",
+		}, []string{"repository", "operation", "outcome"}),
+	}
+	m.registry.MustRegister(m.requestDuration, m.operationDuration, m.operationErrors,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// ObserveOperation records a store operation, it is the store.Observer of the metrics.
+func (m *Metrics) ObserveOperation(ctx context.Context, repository string, operation string) (context.Context,
+	func(error)) {
+	start := time.Now()
+	return ctx, func(err error) {
+		outcome := operationOutcome(err)
+		m.operationDuration.WithLabelValues(repository, operation, outcome).Observe(time.Since(start).Seconds())
+		//A missing document or a version mismatch is an answer of the store, not a failure
+		if outcome == outcomeUnavailable || outcome == outcomeError {
+			m.operationErrors.WithLabelValues(repository, operation, outcome).Inc()
+		}
+	}
+}
+
+// instrument records the latency of every request by the name of its route and its response status
+func (m *Metrics) instrument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		m.requestDuration.WithLabelValues(routeName(c), strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// handler serves the metrics in the prometheus exposition format
+func (m *Metrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Helper function to label the outcome of a store operation with a bounded set of values
+func operationOutcome(err error) string {
+	switch {
+	case err == nil:
+		return outcomeOk
+	case errors.Is(err, store.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, store.ErrDuplicate):
+		return "duplicate"
+	case errors.Is(err, store.ErrVersionMismatch):
+		return "version_mismatch"
+	case errors.Is(err, store.ErrOwnerDeleted), errors.Is(err, store.ErrHasPosts):
+		return "conflict"
+	case errors.Is(err, store.ErrEmptyTextQuery):
+		return "invalid"
+	case errors.Is(err, store.ErrUnavailable):
+		return outcomeUnavailable
+	default:
+		return outcomeError
+	}
+}
@@ -0,0 +1,382 @@
+package restimpl
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gouthams/blogApp/server/utils"
+)
+
+// oidcRequestTimeout bounds every request to the identity provider
+const oidcRequestTimeout = 10 * time.Second
+
+// jwksMinRefresh is how long a token signed by an unknown key waits for the keys to be fetched again, so that the
+// tokens of made up keys do not make the server hammer the identity provider
+const jwksMinRefresh = 10 * time.Second
+
+// OidcConfig configures the login with an OpenID Connect identity provider by the authorization code flow.
+type OidcConfig struct {
+	// Issuer is the issuer url of the identity provider, its endpoints are discovered at
+	// <issuer>/.well-known/openid-configuration. The oidc login is disabled when it is empty.
+	Issuer string `yaml:"issuer"`
+	// ClientId and ClientSecret are the credentials of the server at the identity provider.
+	ClientId     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	// RedirectUrl is the url of the callback api, /auth/oidc/callback, as registered at the identity provider.
+	RedirectUrl string `yaml:"redirectUrl"`
+	// Scopes are requested along with openid, the email scope is needed to find the user.
+	Scopes []string `yaml:"scopes"`
+	// AutoProvision creates the users logging in for the first time, otherwise only the existing users can log in.
+	AutoProvision bool `yaml:"autoProvision"`
+	// JwksCacheTTL is how long the signing keys of the identity provider are cached, a token signed by an unknown
+	// key fetches them sooner.
+	JwksCacheTTL time.Duration `yaml:"jwksCacheTTL"`
+}
+
+// DefaultOidcConfig returns the oidc settings used unless configured otherwise, the login is disabled without issuer.
+func DefaultOidcConfig() OidcConfig {
+	return OidcConfig{Scopes: []string{"email", "profile"}, AutoProvision: true, JwksCacheTTL: time.Hour}
+}
+
+// Oidc logs the users in with the identity provider, it caches the endpoints and the signing keys of the provider.
+type Oidc struct {
+	config OidcConfig
+	client *http.Client
+	// minRefresh is jwksMinRefresh, shorter in the tests
+	minRefresh time.Duration
+
+	mu sync.Mutex
+	// provider is discovered on the first login
+	provider *oidcProvider
+	keys     map[string]*rsa.PublicKey
+	// keysFetchedAt is when the keys were last fetched, or last failed to be
+	keysFetchedAt time.Time
+	// keysFresh is false once the keys failed to be fetched again, they are used until the provider is back
+	keysFresh bool
+}
+
+// oidcStateUse is the use of the tokens carrying the state of a login through the identity provider
+const oidcStateUse = "oidc_state"
+
+// oidcStateTTL is how long a user has to log in at the identity provider
+const oidcStateTTL = 10 * time.Minute
+
+// oidcState is what the callback of a login needs to know about its start, it is kept in a cookie signed by Auth
+type oidcState struct {
+	jwt.RegisteredClaims
+	TokenUse string `json:"token_use"`
+	// State is the state parameter of the login, the callback must come back with it
+	State string `json:"state"`
+	// Nonce is the nonce claim of the id token of the login
+	Nonce string `json:"nonce"`
+	// Verifier is the PKCE code verifier the authorization code is exchanged with
+	Verifier string `json:"verifier"`
+}
+
+// signOidcState returns the signed state of a login
+func (a *Auth) signOidcState(state oidcState) (string, error) {
+	now := time.Now()
+	state.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    a.config.Issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(oidcStateTTL)),
+	}
+	state.TokenUse = oidcStateUse
+	return a.signClaims(state)
+}
+
+// verifyOidcState returns the state of a login signed by signOidcState, unless it has expired
+func (a *Auth) verifyOidcState(rawState string) (oidcState, error) {
+	var state oidcState
+	if err := a.parseClaims(rawState, &state); err != nil {
+		return oidcState{}, err
+	}
+	if state.TokenUse != oidcStateUse {
+		return oidcState{}, fmt.Errorf("%s token used as %s token", state.TokenUse, oidcStateUse)
+	}
+	return state, nil
+}
+
+// oidcProvider is the part of the discovery document of the identity provider the login needs
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// NewOidc returns the Oidc of the configuration, or an error when the configuration is not valid. It does not reach
+// the identity provider, which is discovered on the first login.
+func NewOidc(config OidcConfig) (*Oidc, error) {
+	for name, value := range map[string]string{"issuer": config.Issuer, "redirectUrl": config.RedirectUrl} {
+		parsed, err := url.Parse(value)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("%s %q is not an http or https url", name, value)
+		}
+	}
+	if config.ClientId == "" {
+		return nil, errors.New("clientId is required")
+	}
+	if config.JwksCacheTTL <= 0 {
+		return nil, errors.New("jwksCacheTTL must be positive")
+	}
+	return &Oidc{config: config, client: &http.Client{Timeout: oidcRequestTimeout}, minRefresh: jwksMinRefresh}, nil
+}
+
+// oidcClaims are the claims of the id tokens the server uses
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Nonce string `json:"nonce"`
+	Email string `json:"email"`
+	// EmailVerified is a boolean, some providers send it as a string
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+}
+
+// emailVerified tells whether the identity provider verified that the user owns the email address
+func (c oidcClaims) emailVerified() bool {
+	switch verified := c.EmailVerified.(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	default:
+		return false
+	}
+}
+
+// authCodeUrl returns the url of the identity provider the user logs in at
+func (o *Oidc) authCodeUrl(ctx context.Context, state string, nonce string, challenge string) (string, error) {
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	authUrl, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return "", errIdentityProviderUnavailable(err)
+	}
+	query := authUrl.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", o.config.ClientId)
+	query.Set("redirect_uri", o.config.RedirectUrl)
+	query.Set("scope", strings.Join(append([]string{"openid"}, o.config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+	authUrl.RawQuery = query.Encode()
+	return authUrl.String(), nil
+}
+
+// exchange returns the id token of the authorization code
+func (o *Oidc) exchange(ctx context.Context, code string, verifier string) (string, error) {
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.config.RedirectUrl},
+		"code_verifier": {verifier},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errIdentityProviderUnavailable(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(o.config.ClientId), url.QueryEscape(o.config.ClientSecret))
+
+	response, err := o.client.Do(request)
+	if err != nil {
+		return "", errIdentityProviderUnavailable(err)
+	}
+	defer response.Body.Close()
+	var tokens struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&tokens)
+	switch {
+	case response.StatusCode == http.StatusBadRequest || response.StatusCode == http.StatusUnauthorized:
+		//The code is expired, used already, or the client is not registered as configured
+		return "", errOidcFailed("The identity provider rejected the authorization code",
+			fmt.Errorf("token endpoint answered %d %s: %s", response.StatusCode, tokens.Error, tokens.ErrorDescription))
+	case response.StatusCode != http.StatusOK:
+		return "", errIdentityProviderUnavailable(fmt.Errorf("token endpoint answered %d", response.StatusCode))
+	case err != nil:
+		return "", errIdentityProviderUnavailable(fmt.Errorf("decode the tokens: %w", err))
+	case tokens.IdToken == "":
+		return "", errOidcFailed("The identity provider returned no id token", nil)
+	}
+	return tokens.IdToken, nil
+}
+
+// verify returns the claims of an id token signed by the identity provider for this server and the nonce of the login
+func (o *Oidc) verify(ctx context.Context, rawToken string, nonce string) (oidcClaims, error) {
+	var claims oidcClaims
+	var keyErr error
+	_, err := jwt.ParseWithClaims(rawToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := o.key(ctx, kid)
+		keyErr = err
+		return key, err
+	}, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}), jwt.WithIssuer(o.config.Issuer),
+		jwt.WithAudience(o.config.ClientId), jwt.WithExpirationRequired(), jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute))
+	var apiError *APIError
+	if errors.As(keyErr, &apiError) && apiError.Code == CodeIdentityProviderUnavailable {
+		return oidcClaims{}, apiError
+	}
+	if err != nil {
+		return oidcClaims{}, errOidcFailed("The id token is not valid", err)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return oidcClaims{}, errOidcFailed("The id token is not valid", errors.New("nonce does not match"))
+	}
+	return claims, nil
+}
+
+// discover returns the endpoints of the identity provider, they are fetched once
+func (o *Oidc) discover(ctx context.Context) (oidcProvider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return *o.provider, nil
+	}
+
+	var provider oidcProvider
+	err := o.getJson(ctx, strings.TrimSuffix(o.config.Issuer, "/")+"/.well-known/openid-configuration", &provider)
+	if err != nil {
+		return oidcProvider{}, errIdentityProviderUnavailable(fmt.Errorf("discovery: %w", err))
+	}
+	//The issuer of the document must be the configured one, the id tokens are checked against it
+	if provider.Issuer != o.config.Issuer {
+		return oidcProvider{}, errIdentityProviderUnavailable(fmt.Errorf("discovery: issuer %q is not the configured %q",
+			provider.Issuer, o.config.Issuer))
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JwksUri == "" {
+		return oidcProvider{}, errIdentityProviderUnavailable(errors.New("discovery: endpoints missing"))
+	}
+	o.provider = &provider
+	return provider, nil
+}
+
+// key returns the signing key of the identity provider with the given id. The keys are cached for JwksCacheTTL and
+// fetched again sooner for an unknown key, at most every jwksMinRefresh. The stale keys are used while the identity
+// provider is unreachable.
+func (o *Oidc) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key, known := o.keys[kid]
+	sinceFetch := time.Since(o.keysFetchedAt)
+	if known && o.keysFresh && sinceFetch < o.config.JwksCacheTTL {
+		return key, nil
+	}
+	//The stale keys are not fetched again more than every jwksMinRefresh either
+	if known && !o.keysFresh && sinceFetch < o.minRefresh {
+		return key, nil
+	}
+	if !known && o.keysFetchedAt.After(time.Time{}) && sinceFetch < o.minRefresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	keys, err := o.fetchKeys(ctx, provider.JwksUri)
+	o.keysFetchedAt = time.Now()
+	if err != nil {
+		o.keysFresh = false
+		if known {
+			utils.Log().WithContext(ctx).Warnf("Using the cached signing keys of the identity provider: %v", err)
+			return key, nil
+		}
+		return nil, errIdentityProviderUnavailable(fmt.Errorf("jwks: %w", err))
+	}
+	o.keys = keys
+	o.keysFresh = true
+	key, known = keys[kid]
+	if !known {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// Helper function to fetch the RSA signing keys of the identity provider by their id
+func (o *Oidc) fetchKeys(ctx context.Context, jwksUri string) (map[string]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJson(ctx, jwksUri, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("modulus of key %q: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) > 4 {
+			return nil, fmt.Errorf("exponent of key %q is not valid", jwk.Kid)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// Helper function to get a json document of the identity provider
+func (o *Oidc) getJson(ctx context.Context, documentUrl string, document interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, documentUrl, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := o.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", documentUrl, response.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(document)
+}
+
+// errOidcFailed is the error of a login the identity provider did not complete or whose id token is not valid
+func errOidcFailed(detail string, err error) *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Code: CodeOidcFailed, Detail: detail, Err: err}
+}
+
+// errIdentityProviderUnavailable is the error of a login the identity provider can not be reached for
+func errIdentityProviderUnavailable(err error) *APIError {
+	return &APIError{Status: http.StatusBadGateway, Code: CodeIdentityProviderUnavailable,
+		Detail: "The identity provider can not be reached, retry later", Err: err}
+}
@@ -0,0 +1,269 @@
+package restimpl
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const mockClientId = "blogApp"
+const mockClientSecret = "mock-secret"
+const mockRedirectUrl = "http://blog.example.com/auth/oidc/callback"
+
+// mockIdentityProvider is a local OpenID Connect identity provider, it authorizes every login it is asked to
+type mockIdentityProvider struct {
+	*httptest.Server
+	t *testing.T
+
+	mu  sync.Mutex
+	key *rsa.PrivateKey
+	kid string
+	// jwksRequests counts the fetches of the signing keys
+	jwksRequests int
+	// logins are the claims of the id tokens of the authorization codes not exchanged yet
+	logins map[string]mockLogin
+}
+
+type mockLogin struct {
+	claims    jwt.MapClaims
+	challenge string
+}
+
+func newMockIdentityProvider(t *testing.T) *mockIdentityProvider {
+	idp := &mockIdentityProvider{t: t, logins: make(map[string]mockLogin)}
+	idp.rotateKey()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeMockJson(w, map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.jwksRequests++
+		writeMockJson(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "use": "sig", "alg": "RS256", "kid": idp.kid,
+			"n": base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdentityProvider) config() OidcConfig {
+	config := DefaultOidcConfig()
+	config.Issuer = idp.URL
+	config.ClientId = mockClientId
+	config.ClientSecret = mockClientSecret
+	config.RedirectUrl = mockRedirectUrl
+	return config
+}
+
+// rotateKey makes the identity provider sign with a new key, the old one is no longer published
+func (idp *mockIdentityProvider) rotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		idp.t.Fatalf("Generate key failed %v", err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.key = key
+	idp.kid = fmt.Sprintf("key-%d", time.Now().UnixNano())
+}
+
+// idToken signs an id token for the client, the given claims replace the default ones
+func (idp *mockIdentityProvider) idToken(nonce string, claims jwt.MapClaims) string {
+	now := time.Now()
+	idClaims := jwt.MapClaims{"iss": idp.URL, "sub": "subject-1", "aud": mockClientId, "iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(), "nonce": nonce}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, idClaims)
+	token.Header["kid"] = idp.kid
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Fatalf("Id token signing failed %v", err)
+	}
+	return signed
+}
+
+// authorize logs in a user at the authorization url the server redirected to and returns the authorization code
+func (idp *mockIdentityProvider) authorize(authUrl *url.URL, claims jwt.MapClaims) string {
+	query := authUrl.Query()
+	idClaims := jwt.MapClaims{"nonce": query.Get("nonce")}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.logins[code] = mockLogin{claims: idClaims, challenge: query.Get("code_challenge")}
+	return code
+}
+
+// token exchanges an authorization code once, for the client with the verifier of its challenge
+func (idp *mockIdentityProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientId, clientSecret, _ := r.BasicAuth()
+	if clientId != mockClientId || clientSecret != mockClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeMockJson(w, map[string]string{"error": "invalid_client"})
+		return
+	}
+	idp.mu.Lock()
+	login, ok := idp.logins[r.PostFormValue("code")]
+	delete(idp.logins, r.PostFormValue("code"))
+	idp.mu.Unlock()
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != mockRedirectUrl ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != login.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		writeMockJson(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	nonce, _ := login.claims["nonce"].(string)
+	writeMockJson(w, map[string]string{"access_token": "opaque", "token_type": "Bearer",
+		"id_token": idp.idToken(nonce, login.claims)})
+}
+
+func (idp *mockIdentityProvider) jwksFetches() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.jwksRequests
+}
+
+func writeMockJson(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func TestOidcVerify(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	oidc, err := NewOidc(idp.config())
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	claims, err := oidc.verify(ctx, idp.idToken("nonce-1", jwt.MapClaims{"email": "david@abc.com",
+		"email_verified": true, "name": "David"}), "nonce-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "david@abc.com", claims.Email)
+	assert.True(t, claims.emailVerified())
+	assert.Equal(t, "David", claims.Name)
+
+	//Some providers send email_verified as a string
+	claims, err = oidc.verify(ctx, idp.idToken("nonce-1", jwt.MapClaims{"email_verified": "true"}), "nonce-1")
+	assert.Nil(t, err)
+	assert.True(t, claims.emailVerified())
+	claims, err = oidc.verify(ctx, idp.idToken("nonce-1", jwt.MapClaims{"email": "david@abc.com"}), "nonce-1")
+	assert.Nil(t, err)
+	assert.False(t, claims.emailVerified())
+
+	for name, token := range map[string]string{
+		"other nonce":    idp.idToken("nonce-2", nil),
+		"other issuer":   idp.idToken("nonce-1", jwt.MapClaims{"iss": "https://evil.example.com"}),
+		"other audience": idp.idToken("nonce-1", jwt.MapClaims{"aud": "someone else"}),
+		"expired":        idp.idToken("nonce-1", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"unsigned":       idp.idToken("nonce-1", nil) + "x",
+	} {
+		_, err = oidc.verify(ctx, token, "nonce-1")
+		assert.Error(t, err, name)
+		assert.Contains(t, err.Error(), string(CodeOidcFailed), name)
+	}
+
+	//A token signed with a shared secret is not accepted in place of the keys of the provider
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": idp.URL, "aud": mockClientId,
+		"exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce-1"})
+	signed, err := hmacToken.SignedString([]byte("secret"))
+	assert.Nil(t, err)
+	_, err = oidc.verify(ctx, signed, "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestOidcJwksCache(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	oidc, err := NewOidc(idp.config())
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	//The keys are fetched once
+	for i := 0; i < 3; i++ {
+		_, err = oidc.verify(ctx, idp.idToken("nonce", nil), "nonce")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, idp.jwksFetches())
+
+	//A token of an unknown key fetches them again, but not more than every jwksMinRefresh
+	oidc.minRefresh = 50 * time.Millisecond
+	time.Sleep(oidc.minRefresh)
+	idp.rotateKey()
+	_, err = oidc.verify(ctx, idp.idToken("nonce", nil), "nonce")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, idp.jwksFetches())
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": idp.URL})
+	unknown.Header["kid"] = "made-up"
+	signed, err := unknown.SignedString(idp.key)
+	assert.Nil(t, err)
+	_, err = oidc.verify(ctx, signed, "nonce")
+	assert.Error(t, err)
+	assert.Equal(t, 2, idp.jwksFetches())
+
+	//The keys expire after jwksCacheTTL, the stale keys are used while the provider is unreachable
+	config := idp.config()
+	config.JwksCacheTTL = time.Millisecond
+	oidc, err = NewOidc(config)
+	assert.Nil(t, err)
+	_, err = oidc.verify(ctx, idp.idToken("nonce", nil), "nonce")
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = oidc.verify(ctx, idp.idToken("nonce", nil), "nonce")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, idp.jwksFetches())
+	token := idp.idToken("nonce", nil)
+	idp.Close()
+	time.Sleep(10 * time.Millisecond)
+	_, err = oidc.verify(ctx, token, "nonce")
+	assert.Nil(t, err)
+}
+
+func TestNewOidcValidation(t *testing.T) {
+	config := DefaultOidcConfig()
+	_, err := NewOidc(config)
+	assert.Error(t, err)
+
+	config.Issuer = "https://sso.example.com"
+	config.RedirectUrl = mockRedirectUrl
+	_, err = NewOidc(config)
+	assert.Error(t, err)
+
+	config.ClientId = mockClientId
+	_, err = NewOidc(config)
+	assert.Nil(t, err)
+
+	config.RedirectUrl = "/auth/oidc/callback"
+	_, err = NewOidc(config)
+	assert.Error(t, err)
+}
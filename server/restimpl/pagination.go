@@ -0,0 +1,78 @@
+package restimpl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageToken is the continuation point of a search, sent to the clients base64 encoded.
+// It holds the id and the sort fields of the last record of a page, ids make the order total.
+type pageToken struct {
+	// Sort is the sort order the token was issued for, a token is only valid for the same order.
+	Sort             string     `json:"sort,omitempty"`
+	Id               string     `json:"id"`
+	UserId           string     `json:"userId,omitempty"`
+	Topic            string     `json:"topic,omitempty"`
+	LastModifiedDate *time.Time `json:"lastModifiedDate,omitempty"`
+	// Score is the relevance of the last hit of a full-text search page
+	Score *float64 `json:"score,omitempty"`
+}
+
+func encodePageToken(token pageToken) string {
+	raw, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodePageToken(encoded string) (pageToken, error) {
+	var token pageToken
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return token, fmt.Errorf("invalid pageToken: %s", encoded)
+	}
+	if err := json.Unmarshal(raw, &token); err != nil || token.Id == "" {
+		return token, fmt.Errorf("invalid pageToken: %s", encoded)
+	}
+	return token, nil
+}
+
+// parsePage reads the pageSize and pageToken query parameters of a search request.
+// pageSize defaults to the configured maximum and must be between 1 and that maximum.
+func parsePage(c *gin.Context) (int64, pageToken, error) {
+	maxPageSize := routerConfig(c).MaxPageSize
+	pageSize := maxPageSize
+	if value := c.Query("pageSize"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			return 0, pageToken{}, errInvalidParam("pageSize", "%s is not between 1 and %d", value, maxPageSize)
+		}
+		pageSize = parsed
+	}
+
+	var token pageToken
+	if value := c.Query("pageToken"); value != "" {
+		var err error
+		token, err = decodePageToken(value)
+		if err != nil {
+			return 0, pageToken{}, errInvalidParam("pageToken", "%s is not a page token", value)
+		}
+	}
+	return pageSize, token, nil
+}
+
+// nextPage returns the encoded token of the page following the one ending at last
+// and advertises it in a Link header.
+func nextPage(c *gin.Context, last pageToken) string {
+	token := encodePageToken(last)
+
+	next := *c.Request.URL
+	query := next.Query()
+	query.Set("pageToken", token)
+	next.RawQuery = query.Encode()
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	return token
+}
@@ -0,0 +1,48 @@
+package restimpl
+
+import (
+	"errors"
+	"sync"
+
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt cost of the password hashes
+const passwordCost = bcrypt.DefaultCost
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// hashPassword replaces the password of the user with its hash, a user without password keeps its stored hash
+func hashPassword(user *restimpl.BlogUser) error {
+	if user.Password == "" {
+		user.PasswordHash = ""
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), passwordCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return errInvalidField("password", "max", "must be at most 72 bytes")
+	}
+	if err != nil {
+		return err
+	}
+	user.Password = ""
+	user.PasswordHash = string(hash)
+	return nil
+}
+
+// checkPassword reports whether the password matches the hash. A missing hash is checked against a dummy one, so
+// that the unknown users and the users without password take as long to reject as a wrong password.
+func checkPassword(hash string, password string) bool {
+	if hash == "" {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not the password of anyone"), passwordCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
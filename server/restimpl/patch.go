@@ -0,0 +1,63 @@
+package restimpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+const jsonPatchContentType = "application/json-patch+json"
+
+// patchContentType returns the media type of a PATCH request and whether it is supported
+func patchContentType(c *gin.Context) (string, bool) {
+	contentType, _, err := mime.ParseMediaType(c.Request.Header.Get("Content-type"))
+	if err != nil {
+		return contentType, false
+	}
+	return contentType, contentType == mergePatchContentType || contentType == jsonPatchContentType
+}
+
+// applyPatch applies the JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902) from the request body
+// to the json representation of current, and decodes and validates the result into target.
+func applyPatch(c *gin.Context, contentType string, current interface{}, target interface{}) error {
+	patch, err := c.GetRawData()
+	if err != nil {
+		return errInvalidBody(err)
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	switch contentType {
+	case mergePatchContentType:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+	case jsonPatchContentType:
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = operations.Apply(doc)
+		}
+	default:
+		err = fmt.Errorf("unsupported patch content type: %s", contentType)
+	}
+	if err != nil {
+		return errInvalidPatch(err)
+	}
+
+	if err := json.Unmarshal(patched, target); err != nil {
+		return errInvalidBody(err)
+	}
+	//The patched document must satisfy the same bindings as a full update
+	if err := binding.Validator.ValidateStruct(target); err != nil {
+		return errInvalidBody(err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package restimpl
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+	uuid "github.com/satori/go.uuid"
+)
+
+// postQueryParams lists the query parameters accepted by the blogPosts search
+var postQueryParams = map[string]bool{
+	"userId":      true,
+	"topic":       true,
+	"topicPrefix": true,
+	"content":     true,
+	"since":       true,
+	"until":       true,
+	"sort":        true,
+	"pageSize":    true,
+	"pageToken":   true,
+}
+
+// parsePostQuery validates the query of a blogPosts search and turns it into a filter.
+// userId may be repeated or comma separated, sort is a comma separated list of fields
+// each optionally prefixed with - for descending order. Unknown parameters are rejected.
+func parsePostQuery(c *gin.Context) (store.PostFilter, error) {
+	var filter store.PostFilter
+	query := c.Request.URL.Query()
+
+	if err := checkQueryParams(query, postQueryParams, "userId"); err != nil {
+		return filter, err
+	}
+
+	for _, value := range query["userId"] {
+		for _, userId := range strings.Split(value, ",") {
+			id, err := uuid.FromString(strings.TrimSpace(userId))
+			if err != nil {
+				return filter, errInvalidParam("userId", "%s is not a uuid", userId)
+			}
+			filter.UserIds = append(filter.UserIds, id.String())
+		}
+	}
+
+	filter.Topic = query.Get("topic")
+	filter.TopicPrefix = query.Get("topicPrefix")
+	filter.Content = query.Get("content")
+
+	var err error
+	if filter.Since, err = parseQueryTime(query, "since"); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseQueryTime(query, "until"); err != nil {
+		return filter, err
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return filter, errInvalidParam("since", "must be before until")
+	}
+
+	if filter.Sort, err = parseSort(query.Get("sort")); err != nil {
+		return filter, err
+	}
+
+	pageSize, token, err := parsePage(c)
+	if err != nil {
+		return filter, err
+	}
+	//Fetch one extra document to know whether there is a next page
+	filter.PageSize = pageSize + 1
+	if token.Id != "" {
+		if token.Sort != formatSort(filter.Sort) {
+			return filter, errInvalidParam("pageToken", "was issued for another sort order")
+		}
+		filter.After = &restimpl.BlogPost{Id: token.Id, UserId: token.UserId, Topic: token.Topic}
+		if token.LastModifiedDate != nil {
+			filter.After.LastModifiedDate = *token.LastModifiedDate
+		}
+	}
+	return filter, nil
+}
+
+// textQueryParams lists the query parameters accepted by the blogPosts full-text search
+var textQueryParams = map[string]bool{
+	"q":         true,
+	"pageSize":  true,
+	"pageToken": true,
+}
+
+// parseTextQuery validates the query of a blogPosts full-text search, q holds the search string.
+func parseTextQuery(c *gin.Context) (store.TextQuery, error) {
+	query := c.Request.URL.Query()
+	if err := checkQueryParams(query, textQueryParams); err != nil {
+		return store.TextQuery{}, err
+	}
+
+	textQuery, err := store.ParseTextQuery(query.Get("q"))
+	if err != nil {
+		return textQuery, errInvalidParam("q", "%v", err)
+	}
+
+	pageSize, token, err := parsePage(c)
+	if err != nil {
+		return textQuery, err
+	}
+	//Fetch one extra hit to know whether there is a next page
+	textQuery.PageSize = pageSize + 1
+	if token.Id != "" {
+		if token.Score == nil {
+			return textQuery, errInvalidParam("pageToken", "was not issued by a full-text search")
+		}
+		textQuery.After = &store.TextHit{Post: restimpl.BlogPost{Id: token.Id}, Score: *token.Score}
+	}
+	return textQuery, nil
+}
+
+// postPageToken returns the token resuming the search of the given filter after the post
+func postPageToken(filter store.PostFilter, post restimpl.BlogPost) pageToken {
+	token := pageToken{Sort: formatSort(filter.Sort), Id: post.Id}
+	for _, field := range filter.SortKey() {
+		switch field.Field {
+		case store.SortByUserId:
+			token.UserId = post.UserId
+		case store.SortByTopic:
+			token.Topic = post.Topic
+		case store.SortByLastModifiedDate:
+			lastModifiedDate := post.LastModifiedDate
+			token.LastModifiedDate = &lastModifiedDate
+		}
+	}
+	return token
+}
+
+// Helper function to parse an optional RFC 3339 timestamp query parameter
+func parseQueryTime(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, errInvalidParam(name, "%s is not an RFC 3339 timestamp", value)
+	}
+	return parsed.UTC(), nil
+}
+
+// Helper function to parse the sort parameter, e.g. -lastModifiedDate,topic
+func parseSort(value string) ([]store.SortField, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var fields []store.SortField
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		field := store.SortField{Field: strings.TrimSpace(name)}
+		if strings.HasPrefix(field.Field, "-") {
+			field.Field = field.Field[1:]
+			field.Descending = true
+		}
+		if !isSortField(field.Field) {
+			return nil, errInvalidParam("sort", "%s is not one of %s", name,
+				strings.Join(store.PostSortFields, ", "))
+		}
+		if seen[field.Field] {
+			return nil, errInvalidParam("sort", "%s is given more than once", field.Field)
+		}
+		seen[field.Field] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Helper function to format the sort fields back into their canonical parameter form
+func formatSort(fields []store.SortField) string {
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field.Descending {
+			names = append(names, "-"+field.Field)
+		} else {
+			names = append(names, field.Field)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func isSortField(name string) bool {
+	for _, field := range store.PostSortFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to reject unknown query parameters, only the repeatable ones may be given more than once
+func checkQueryParams(query url.Values, allowed map[string]bool, repeatable ...string) error {
+	for name, values := range query {
+		if !allowed[name] {
+			return errInvalidParam(name, "is not a known parameter")
+		}
+		if len(values) > 1 && !isRepeatable(name, repeatable) {
+			return errInvalidParam(name, "must be given once")
+		}
+	}
+	return nil
+}
+
+func isRepeatable(name string, repeatable []string) bool {
+	for _, r := range repeatable {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
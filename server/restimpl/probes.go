@@ -0,0 +1,86 @@
+package restimpl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+)
+
+// readinessTimeout bounds every check of the readiness probe
+const readinessTimeout = 2 * time.Second
+
+// Lifecycle tells the readiness probe whether the server is shutting down.
+type Lifecycle struct {
+	shuttingDown atomic.Bool
+}
+
+// ShutDown makes the readiness probe fail, so that no new traffic is routed to the server.
+func (l *Lifecycle) ShutDown() {
+	l.shuttingDown.Store(true)
+}
+
+// ShuttingDown tells whether ShutDown was called.
+func (l *Lifecycle) ShuttingDown() bool {
+	return l.shuttingDown.Load()
+}
+
+// Healthz - liveness probe, passes as long as the process serves requests
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, restimpl.Health{Status: checkPass, Checks: []restimpl.HealthCheck{}})
+}
+
+// readyz - readiness probe, passes when the database is reachable and migrated and the server is not shutting down
+func readyz(s store.Store, lifecycle *Lifecycle) gin.HandlerFunc {
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{"database", s.Ping},
+		{"migrations", s.CheckSchema},
+		{"shutdown", func(context.Context) error {
+			if lifecycle.ShuttingDown() {
+				return errors.New("server is shutting down")
+			}
+			return nil
+		}},
+	}
+
+	return func(c *gin.Context) {
+		logEntry := requestLog(c)
+
+		health := restimpl.Health{Status: checkPass}
+		for _, check := range checks {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+			start := time.Now()
+			err := check.check(ctx)
+			cancel()
+
+			result := restimpl.HealthCheck{Name: check.name, Status: checkPass,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				logEntry.Warnf("Readiness check %s failed %v", check.name, err)
+				result.Status = checkFail
+				result.Error = err.Error()
+				health.Status = checkFail
+			}
+			health.Checks = append(health.Checks, result)
+		}
+
+		status := http.StatusOK
+		if health.Status != checkPass {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, health)
+	}
+}
@@ -0,0 +1,311 @@
+package restimpl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	restimpl "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemTypePrefix prefixes the code of a problem to make its type
+const problemTypePrefix = "/problems/"
+
+// ErrorCode is the machine-readable code of an api error, documented in blog-openapi.yaml.
+type ErrorCode string
+
+const (
+	CodeUnsupportedMediaType        ErrorCode = "unsupported_media_type"
+	CodeMalformedBody               ErrorCode = "malformed_body"
+	CodeValidationFailed            ErrorCode = "validation_failed"
+	CodeInvalidParameter            ErrorCode = "invalid_parameter"
+	CodeInvalidPatch                ErrorCode = "invalid_patch"
+	CodeUnauthenticated             ErrorCode = "unauthenticated"
+	CodeInvalidToken                ErrorCode = "invalid_token"
+	CodeInvalidCredentials          ErrorCode = "invalid_credentials"
+	CodeInvalidApiKey               ErrorCode = "invalid_api_key"
+	CodeOidcFailed                  ErrorCode = "oidc_failed"
+	CodeForbidden                   ErrorCode = "forbidden"
+	CodeNotFound                    ErrorCode = "not_found"
+	CodeEmailTaken                  ErrorCode = "email_taken"
+	CodeAccountLinkRequired         ErrorCode = "account_link_required"
+	CodeUserHasPosts                ErrorCode = "user_has_posts"
+	CodeOwnerInTrash                ErrorCode = "owner_in_trash"
+	CodePreconditionFailed          ErrorCode = "precondition_failed"
+	CodeServiceUnavailable          ErrorCode = "service_unavailable"
+	CodeIdentityProviderUnavailable ErrorCode = "identity_provider_unavailable"
+	CodeInternal                    ErrorCode = "internal_error"
+)
+
+// APIError is an error of the apis, answered with a problem+json body by the error handling middleware.
+type APIError struct {
+	Status int
+	Code   ErrorCode
+	// Detail explains the problem to the client, it never holds the messages of the store or the libraries
+	Detail string
+	// Fields lists the invalid fields and parameters of the request
+	Fields []restimpl.FieldError
+	// RetryAfter is sent in the Retry-After header when positive
+	RetryAfter time.Duration
+	// Challenge is sent in the WWW-Authenticate header when not empty
+	Challenge string
+	// Err is the cause of the error, it is logged but not sent to the client
+	Err error
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("%s: %s", e.Code, e.Detail)
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// abortWithProblem stops the request, the error handling middleware answers it with the problem of the error
+func abortWithProblem(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// handleErrors answers the requests that failed with the problem of their last error
+func handleErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		writeProblem(c, problemOf(c.Errors.Last().Err))
+	}
+}
+
+// recoverWithProblem answers the requests whose handler panicked with an internal error
+func recoverWithProblem(c *gin.Context, recovered interface{}) {
+	requestLog(c).Errorf("Request panicked: %v", recovered)
+	writeProblem(c, errInternal(fmt.Errorf("panic: %v", recovered)))
+	c.Abort()
+}
+
+// Helper function to write the problem of an api error
+func writeProblem(c *gin.Context, apiError *APIError) {
+	if apiError.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(apiError.RetryAfter.Seconds()))))
+	}
+	if apiError.Challenge != "" {
+		c.Header("WWW-Authenticate", apiError.Challenge)
+	}
+	problem := restimpl.Problem{
+		Type:     problemTypePrefix + string(apiError.Code),
+		Title:    http.StatusText(apiError.Status),
+		Status:   apiError.Status,
+		Detail:   apiError.Detail,
+		Instance: c.Request.URL.Path,
+		Code:     string(apiError.Code),
+		Errors:   apiError.Fields,
+	}
+	problem.RequestId = c.Writer.Header().Get(RequestIdHeader)
+	body, err := json.Marshal(problem)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(apiError.Status, problemContentType, body)
+}
+
+// problemOf returns the api error answering the error, the errors of the store are mapped to their status and the
+// unexpected ones to an internal error
+func problemOf(err error) *APIError {
+	var apiError *APIError
+	switch {
+	case errors.As(err, &apiError):
+		return apiError
+	case errors.Is(err, store.ErrUnavailable):
+		return errUnavailable(time.Second)
+	case errors.Is(err, store.ErrNotFound):
+		return &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Detail: "The resource was not found", Err: err}
+	case errors.Is(err, store.ErrVersionMismatch):
+		return &APIError{Status: http.StatusPreconditionFailed, Code: CodePreconditionFailed,
+			Detail: "If-Match does not match the current version", Err: err}
+	default:
+		return errInternal(err)
+	}
+}
+
+// errUnsupportedMediaType is the error of a request body of an unsupported media type
+func errUnsupportedMediaType(contentType string, supported ...string) *APIError {
+	if len(supported) == 0 {
+		supported = []string{"application/json"}
+	}
+	return &APIError{Status: http.StatusUnsupportedMediaType, Code: CodeUnsupportedMediaType,
+		Detail: fmt.Sprintf("Content-Type %q is not supported, use %s", contentType, strings.Join(supported, " or "))}
+}
+
+// errInvalidParam is the error of an invalid path or query parameter
+func errInvalidParam(name string, format string, args ...interface{}) *APIError {
+	message := fmt.Sprintf(format, args...)
+	return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidParameter,
+		Detail: fmt.Sprintf("Invalid parameter %s", name),
+		Fields: []restimpl.FieldError{{Field: name, Code: "invalid", Message: message}}}
+}
+
+// errInvalidId is the error of an id parameter that is not a uuid
+func errInvalidId(c *gin.Context) *APIError {
+	return errInvalidParam("id", "%s is not a uuid", c.Param("id"))
+}
+
+// errInvalidField is the error of a field of the request body failing a check of the handler
+func errInvalidField(field string, code string, message string) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: CodeValidationFailed,
+		Detail: "The request body is not valid",
+		Fields: []restimpl.FieldError{{Field: field, Code: code, Message: message}}}
+}
+
+// errInvalidBody is the error of a request body that can not be decoded or fails the validation of its bindings,
+// it tells which fields are invalid without the messages of the decoder and the validator
+func errInvalidBody(err error) *APIError {
+	var validationErrors validator.ValidationErrors
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validationErrors):
+		apiError := &APIError{Status: http.StatusBadRequest, Code: CodeValidationFailed,
+			Detail: "The request body is not valid", Err: err}
+		for _, fieldError := range validationErrors {
+			apiError.Fields = append(apiError.Fields, restimpl.FieldError{Field: jsonName(fieldError.Field()),
+				Code: fieldError.Tag(), Message: validationMessage(fieldError)})
+		}
+		return apiError
+	case errors.As(err, &typeError):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeValidationFailed,
+			Detail: "The request body is not valid", Err: err,
+			Fields: []restimpl.FieldError{{Field: typeError.Field, Code: "type",
+				Message: fmt.Sprintf("must be a %s", typeError.Type.Kind())}}}
+	case errors.As(err, &syntaxError):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeMalformedBody,
+			Detail: fmt.Sprintf("The request body is not valid JSON at offset %d", syntaxError.Offset), Err: err}
+	case errors.Is(err, io.EOF):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeMalformedBody, Detail: "The request body is empty",
+			Err: err}
+	default:
+		return &APIError{Status: http.StatusBadRequest, Code: CodeMalformedBody,
+			Detail: "The request body can not be decoded", Err: err}
+	}
+}
+
+// errInvalidPatch is the error of a patch that can not be decoded or applied
+func errInvalidPatch(err error) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidPatch,
+		Detail: "The patch can not be applied", Err: err}
+}
+
+// errNotFound is the error of a missing resource, other errors of the lookup are returned as is
+func errNotFound(err error, resource string, id string) error {
+	if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return &APIError{Status: http.StatusNotFound, Code: CodeNotFound,
+		Detail: fmt.Sprintf("%s with id: %s not found", resource, id), Err: err}
+}
+
+// errUnknownUser is the error of a post whose userId is not an existing user, other errors of the lookup are
+// returned as is
+func errUnknownUser(err error) error {
+	if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	apiError := errInvalidField("userId", "unknown", "is not an existing user")
+	apiError.Err = err
+	return apiError
+}
+
+// errConflict is the error of a request conflicting with the state of a resource
+func errConflict(code ErrorCode, detail string, err error) *APIError {
+	return &APIError{Status: http.StatusConflict, Code: code, Detail: detail, Err: err}
+}
+
+// errEmailTaken is the error of a user whose email address is already used by another user
+func errEmailTaken(err error) *APIError {
+	return &APIError{Status: http.StatusConflict, Code: CodeEmailTaken, Detail: "Email address is not unique",
+		Fields: []restimpl.FieldError{{Field: "email", Code: "unique", Message: "is already used by another user"}},
+		Err:    err}
+}
+
+// errPreconditionFailed is the error of an If-Match header not matching the version of the resource
+func errPreconditionFailed(id string) *APIError {
+	return &APIError{Status: http.StatusPreconditionFailed, Code: CodePreconditionFailed,
+		Detail: fmt.Sprintf("If-Match does not match the current version of id: %s", id)}
+}
+
+// errUnavailable is the error of a request failing fast while the database is unavailable
+func errUnavailable(retryAfter time.Duration) *APIError {
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return &APIError{Status: http.StatusServiceUnavailable, Code: CodeServiceUnavailable,
+		Detail: "Database unavailable, retry later", RetryAfter: retryAfter}
+}
+
+// errInternal is the error of an unexpected failure, its cause is only logged
+func errInternal(err error) *APIError {
+	return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal,
+		Detail: "The request could not be completed", Err: err}
+}
+
+// Helper function to turn the name of a struct field into its json name, e.g. UserId into userId
+func jsonName(field string) string {
+	runes := []rune(field)
+	if len(runes) > 0 {
+		runes[0] = unicode.ToLower(runes[0])
+	}
+	return string(runes)
+}
+
+// Helper function to explain a failed validation
+func validationMessage(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be an email address"
+	case "min":
+		return boundMessage("at least", fieldError)
+	case "max":
+		return boundMessage("at most", fieldError)
+	default:
+		return fmt.Sprintf("must satisfy %s", fieldError.Tag())
+	}
+}
+
+// Helper function to explain a failed min or max validation by what the bound counts for the kind of the field
+func boundMessage(bound string, fieldError validator.FieldError) string {
+	switch fieldError.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("must have %s %s items", bound, fieldError.Param())
+	case reflect.String:
+		return fmt.Sprintf("must be %s %s characters", bound, fieldError.Param())
+	default:
+		return fmt.Sprintf("must be %s %s", bound, fieldError.Param())
+	}
+}
+
+// noRoute answers the requests matching no Route
+func noRoute(c *gin.Context) {
+	abortWithProblem(c, &APIError{Status: http.StatusNotFound, Code: CodeNotFound,
+		Detail: fmt.Sprintf("No api matches %s %s", c.Request.Method, c.Request.URL.Path)})
+}
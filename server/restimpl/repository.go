@@ -0,0 +1,42 @@
+package restimpl
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/store"
+)
+
+const userRepositoryKey = "userRepository"
+const postRepositoryKey = "postRepository"
+const apiKeyRepositoryKey = "apiKeyRepository"
+const refreshTokenRepositoryKey = "refreshTokenRepository"
+
+// injectStore makes the repositories of the given store available to every handler
+func injectStore(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(userRepositoryKey, s.Users)
+		c.Set(postRepositoryKey, s.Posts)
+		c.Set(apiKeyRepositoryKey, s.ApiKeys)
+		c.Set(refreshTokenRepositoryKey, s.RefreshTokens)
+		c.Next()
+	}
+}
+
+// userRepository returns the UserRepository injected by NewRouter
+func userRepository(c *gin.Context) store.UserRepository {
+	return c.MustGet(userRepositoryKey).(store.UserRepository)
+}
+
+// postRepository returns the PostRepository injected by NewRouter
+func postRepository(c *gin.Context) store.PostRepository {
+	return c.MustGet(postRepositoryKey).(store.PostRepository)
+}
+
+// apiKeyRepository returns the ApiKeyRepository injected by NewRouter
+func apiKeyRepository(c *gin.Context) store.ApiKeyRepository {
+	return c.MustGet(apiKeyRepositoryKey).(store.ApiKeyRepository)
+}
+
+// refreshTokenRepository returns the RefreshTokenRepository injected by NewRouter
+func refreshTokenRepository(c *gin.Context) store.RefreshTokenRepository {
+	return c.MustGet(refreshTokenRepositoryKey).(store.RefreshTokenRepository)
+}
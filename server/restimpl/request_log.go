@@ -0,0 +1,67 @@
+package restimpl
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+// RequestIdHeader carries the id of a request, it is generated unless the caller sends a valid one.
+const RequestIdHeader = "X-Request-ID"
+
+// maxRequestIdLength bounds the request ids accepted from the callers
+const maxRequestIdLength = 128
+
+const requestLogKey = "requestLog"
+
+// identifyRequests accepts or generates the id of every request, returns it in the X-Request-ID header and makes a
+// logger carrying it, along with the route name, the client ip and the trace, available to the handlers
+func identifyRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(RequestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = uuid.NewV4().String()
+		}
+		c.Header(RequestIdHeader, requestId)
+
+		c.Set(requestLogKey, utils.Log().WithContext(c.Request.Context()).WithFields(utils.Fields{
+			"requestId": requestId,
+			"route":     routeName(c),
+			"clientIp":  c.ClientIP(),
+			"url":       c.Request.URL,
+			"Method":    c.Request.Method,
+		}))
+		c.Next()
+	}
+}
+
+// requestLog returns the logger of the request, every log line of a request goes through it
+func requestLog(c *gin.Context) *utils.REntry {
+	if logEntry, ok := c.Get(requestLogKey); ok {
+		return logEntry.(*utils.REntry)
+	}
+	//The probes are served without the middlewares
+	return utils.Log().WithContext(c.Request.Context()).WithFields(utils.Fields{"url": c.Request.URL,
+		"Method": c.Request.Method})
+}
+
+// setRequestLog replaces the logger of the request, e.g. to add the fields learnt while serving it
+func setRequestLog(c *gin.Context, logEntry *utils.REntry) {
+	c.Set(requestLogKey, logEntry)
+}
+
+// Helper function to accept only the request ids that are safe to log and to echo in a header
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for _, r := range requestId {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':', r == '/', r == '+', r == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
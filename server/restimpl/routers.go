@@ -14,6 +14,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/store"
 )
 
 // Route is the information for every URI.
@@ -31,25 +32,62 @@ type Route struct {
 // Routes is the list of the generated Route.
 type Routes []Route
 
-// NewRouter returns a new router.
-func NewRouter() *gin.Engine {
-	router := gin.Default()
+const routeNameKey = "routeName"
+
+// unmatchedRoute is the route name of the requests matching no Route
+const unmatchedRoute = "Unmatched"
+
+// NewRouter returns a new router serving the apis from the given store.
+func NewRouter(s store.Store, options ...Option) *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Logger(), gin.CustomRecovery(recoverWithProblem))
+	config := newConfig(options)
+	//The probes are registered before the middlewares so that they answer while the database is unavailable
+	router.GET("/healthz", Healthz)
+	router.GET("/readyz", readyz(s, config.Lifecycle))
+	router.GET("/metrics", config.Metrics.handler())
+	router.Use(nameRoute(routes), traceRequests(), identifyRequests(), config.Metrics.instrument(), handleErrors(),
+		authenticate(config.Auth, s), failFast(s), injectStore(s), injectConfig(config), authorize())
+	router.NoRoute(noRoute)
 	for _, route := range routes {
 		switch route.Method {
 		case http.MethodGet:
-			router.GET(route.Pattern, route.HandlerFunc)
+			router.GET(route.Pattern, traceHandler(route.HandlerFunc))
 		case http.MethodPost:
-			router.POST(route.Pattern, route.HandlerFunc)
+			router.POST(route.Pattern, traceHandler(route.HandlerFunc))
 		case http.MethodPut:
-			router.PUT(route.Pattern, route.HandlerFunc)
+			router.PUT(route.Pattern, traceHandler(route.HandlerFunc))
+		case http.MethodPatch:
+			router.PATCH(route.Pattern, traceHandler(route.HandlerFunc))
 		case http.MethodDelete:
-			router.DELETE(route.Pattern, route.HandlerFunc)
+			router.DELETE(route.Pattern, traceHandler(route.HandlerFunc))
 		}
 	}
 
 	return router
 }
 
+// nameRoute makes the Name of the Route matching the request available to the middlewares and the handlers
+func nameRoute(routes Routes) gin.HandlerFunc {
+	names := make(map[string]string, len(routes))
+	for _, route := range routes {
+		names[route.Method+" "+route.Pattern] = route.Name
+	}
+	return func(c *gin.Context) {
+		name, ok := names[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			name = unmatchedRoute
+		}
+		c.Set(routeNameKey, name)
+		c.Next()
+	}
+}
+
+// routeName returns the Name of the Route matching the request
+func routeName(c *gin.Context) string {
+	return c.GetString(routeNameKey)
+}
+
 // Index is the index handler.
 func Index(c *gin.Context) {
 	c.String(http.StatusOK, "Hello World!")
@@ -112,6 +150,13 @@ var routes = Routes{
 		SearchblogPosts,
 	},
 
+	{
+		"TextSearchblogPosts",
+		http.MethodGet,
+		"/blogPosts/search",
+		TextSearchblogPosts,
+	},
+
 	{
 		"SearchblogUsers",
 		http.MethodGet,
@@ -132,4 +177,118 @@ var routes = Routes{
 		"/blogPosts/:id",
 		UpdateblogPosts,
 	},
+
+	{
+		"RestoreBlogUsers",
+		http.MethodPost,
+		"/blogUsers/:id/restore",
+		RestoreBlogUsers,
+	},
+
+	{
+		"RestoreblogPosts",
+		http.MethodPost,
+		"/blogPosts/:id/restore",
+		RestoreblogPosts,
+	},
+
+	{
+		"GetTrash",
+		http.MethodGet,
+		"/trash",
+		GetTrash,
+	},
+
+	{
+		"PatchBlogUsers",
+		http.MethodPatch,
+		"/blogUsers/:id",
+		PatchBlogUsers,
+	},
+
+	{
+		"PatchblogPosts",
+		http.MethodPatch,
+		"/blogPosts/:id",
+		PatchblogPosts,
+	},
+
+	{
+		"Login",
+		http.MethodPost,
+		"/auth/login",
+		Login,
+	},
+
+	{
+		"Refresh",
+		http.MethodPost,
+		"/auth/refresh",
+		Refresh,
+	},
+
+	{
+		"AddApiKeys",
+		http.MethodPost,
+		"/blogUsers/:id/apiKeys",
+		AddApiKeys,
+	},
+
+	{
+		"ListApiKeys",
+		http.MethodGet,
+		"/blogUsers/:id/apiKeys",
+		ListApiKeys,
+	},
+
+	{
+		"DeleteApiKeys",
+		http.MethodDelete,
+		"/blogUsers/:id/apiKeys/:keyId",
+		DeleteApiKeys,
+	},
+
+	{
+		"OidcLogin",
+		http.MethodGet,
+		"/auth/oidc/login",
+		OidcLogin,
+	},
+
+	{
+		"OidcCallback",
+		http.MethodGet,
+		"/auth/oidc/callback",
+		OidcCallback,
+	},
+}
+
+// policies tell who may call each Route by its Name, the authors own their posts and every user owns itself and its
+// api keys
+var policies = map[string]policy{
+	"Index":               {Anonymous: true},
+	"AddBlogUsers":        {Anonymous: true},
+	"AddblogPosts":        {Any: RoleAdmin, Own: RoleAuthor},
+	"DeleteBlogPosts":     {Any: RoleAdmin, Own: RoleAuthor, Owner: pathPostOwner},
+	"DeleteBlogUsers":     {Any: RoleAdmin, Own: RoleReader, Owner: pathUser},
+	"GetblogPosts":        {Anonymous: true},
+	"GetblogUsers":        {Anonymous: true},
+	"SearchblogPosts":     {Anonymous: true},
+	"TextSearchblogPosts": {Anonymous: true},
+	"SearchblogUsers":     {Anonymous: true},
+	"UpdateBlogUsers":     {Any: RoleAdmin, Own: RoleReader, Owner: pathUser},
+	"UpdateblogPosts":     {Any: RoleAdmin, Own: RoleAuthor, Owner: pathPostOwner},
+	"RestoreBlogUsers":    {Any: RoleAdmin},
+	"RestoreblogPosts":    {Any: RoleEditor},
+	"GetTrash":            {Any: RoleEditor},
+	"PatchBlogUsers":      {Any: RoleAdmin, Own: RoleReader, Owner: pathUser},
+	"PatchblogPosts":      {Any: RoleAdmin, Own: RoleAuthor, Owner: pathPostOwner},
+	"Login":               {Anonymous: true},
+	"Refresh":             {Anonymous: true},
+	"AddApiKeys":          {Any: RoleAdmin, Own: RoleReader, Owner: pathUser, Interactive: true},
+	"ListApiKeys":         {Any: RoleAdmin, Own: RoleReader, Owner: pathUser, Interactive: true},
+	"DeleteApiKeys":       {Any: RoleAdmin, Own: RoleReader, Owner: pathUser, Interactive: true},
+	"OidcLogin":           {Anonymous: true},
+	"OidcCallback":        {Anonymous: true},
+	unmatchedRoute:        {Anonymous: true},
 }
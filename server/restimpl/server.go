@@ -0,0 +1,55 @@
+package restimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gouthams/blogApp/server/utils"
+)
+
+// Serve serves the requests accepted by the listener until the context is done, then shuts the server down
+// gracefully: the readiness probe of the lifecycle fails for the delay while the requests are still served, then the
+// server stops accepting connections and the in-flight requests have until the timeout to complete. The connections
+// still open after the timeout are closed and an error is returned.
+func Serve(ctx context.Context, server *http.Server, listener net.Listener, lifecycle *Lifecycle,
+	delay time.Duration, timeout time.Duration) error {
+	logEntry := utils.Log()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	select {
+	case err := <-served:
+		return fmt.Errorf("serve on %s: %w", listener.Addr(), err)
+	case <-ctx.Done():
+	}
+
+	logEntry.Infof("Shutting down, not ready anymore for %s", delay)
+	lifecycle.ShutDown()
+	timer := time.NewTimer(delay)
+	select {
+	case err := <-served:
+		timer.Stop()
+		return fmt.Errorf("serve on %s: %w", listener.Addr(), err)
+	case <-timer.C:
+	}
+
+	logEntry.Infof("Draining the in-flight requests for up to %s", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		//Give up on the requests that did not complete in time
+		_ = server.Close()
+		return fmt.Errorf("drain the in-flight requests: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve on %s: %w", listener.Addr(), err)
+	}
+	logEntry.Info("Every in-flight request completed")
+	return nil
+}
@@ -0,0 +1,95 @@
+package restimpl
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to serve a handler blocking until released, returns the url and the outcome of Serve
+func serveBlocking(t *testing.T, ctx context.Context, lifecycle *Lifecycle, delay time.Duration,
+	timeout time.Duration, started chan<- struct{}, release <-chan struct{}) (string, <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, &http.Server{Handler: handler}, listener, lifecycle, delay, timeout)
+	}()
+	return "http://" + listener.Addr().String(), served
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lifecycle := &Lifecycle{}
+	started, release := make(chan struct{}), make(chan struct{})
+	url, served := serveBlocking(t, ctx, lifecycle, 50*time.Millisecond, 5*time.Second, started, release)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, err := http.Get(url)
+		assert.Nil(t, err)
+		responses <- response
+	}()
+	<-started
+
+	//The in-flight request completes after the shutdown started
+	cancel()
+	assert.Eventually(t, lifecycle.ShuttingDown, time.Second, 5*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	response := <-responses
+	if assert.NotNil(t, response) {
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		body, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		assert.Equal(t, "done", string(body))
+	}
+	assert.Nil(t, <-served)
+
+	//No new connection is accepted
+	_, err := http.Get(url)
+	assert.Error(t, err)
+}
+
+func TestServeDrainTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	url, served := serveBlocking(t, ctx, &Lifecycle{}, 0, 50*time.Millisecond, started, release)
+
+	go func() {
+		response, err := http.Get(url)
+		if err == nil {
+			_ = response.Body.Close()
+		}
+	}()
+	<-started
+	cancel()
+	select {
+	case err := <-served:
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "drain the in-flight requests")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not give up on the in-flight request")
+	}
+}
+
+func TestServeListenerError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Nil(t, listener.Close())
+	err = Serve(context.Background(), &http.Server{}, listener, &Lifecycle{}, 0, time.Second)
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package restimpl
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gouthams/blogApp/server/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceRequests starts a server span named after the route of every request, continuing the trace of the W3C
+// traceparent header of the request when it has one
+func traceRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := utils.Tracer().Start(ctx, routeName(c), trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", c.Request.Method),
+				attribute.String("http.route", c.FullPath()),
+				attribute.String("http.target", c.Request.URL.RequestURI()),
+			))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// traceHandler runs the handler of a route in its own span, so that the time spent in the middlewares and in the
+// handler tell apart. The store operations of the handler are children of its span.
+func traceHandler(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, span := utils.Tracer().Start(c.Request.Context(), "handler")
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		setRequestLog(c, requestLog(c).WithContext(ctx))
+		handler(c)
+	}
+}
+
+// TraceOperation runs a store operation in a client span named after its repository and operation, it is the
+// store.Observer of the tracing.
+func TraceOperation(ctx context.Context, repository string, operation string) (context.Context, func(error)) {
+	ctx, span := utils.Tracer().Start(ctx, repository+"."+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.operation", operation)))
+	return ctx, func(err error) {
+		defer span.End()
+		outcome := operationOutcome(err)
+		span.SetAttributes(attribute.String("db.outcome", outcome))
+		//Not found, duplicates and conflicts are answers of the database rather than failures
+		if outcome == outcomeError || outcome == outcomeUnavailable {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+}
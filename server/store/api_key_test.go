@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiKeys(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: name + "@abc.com", Version: 1}
+		assert.Nil(t, s.Users.Insert(ctx, user), name)
+
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		expiresAt := now.Add(time.Hour)
+		first := model.ApiKey{Id: uuid.NewV4().String(), UserId: user.Id, Name: "importer", Prefix: name + "-1",
+			Scopes: []string{"read", "write"}, ExpiresAt: &expiresAt, CreatedAt: now, KeyHash: "hash-1"}
+		second := model.ApiKey{Id: uuid.NewV4().String(), UserId: user.Id, Name: "ci", Prefix: name + "-2",
+			Scopes: []string{"read"}, CreatedAt: now.Add(time.Second), KeyHash: "hash-2"}
+		assert.Nil(t, s.ApiKeys.Insert(ctx, second), name)
+		assert.Nil(t, s.ApiKeys.Insert(ctx, first), name)
+
+		//The prefixes identify the keys
+		duplicate := first
+		duplicate.Id = uuid.NewV4().String()
+		assert.Equal(t, ErrDuplicate, s.ApiKeys.Insert(ctx, duplicate), name)
+		stored, err := s.ApiKeys.GetByPrefix(ctx, first.Prefix)
+		assert.Nil(t, err, name)
+		assert.Equal(t, first.KeyHash, stored.KeyHash, name)
+		assert.Equal(t, first.Scopes, stored.Scopes, name)
+		assert.True(t, expiresAt.Equal(*stored.ExpiresAt), name)
+		assert.Nil(t, stored.LastUsedAt, name)
+		_, err = s.ApiKeys.GetByPrefix(ctx, "unknown")
+		assert.Equal(t, ErrNotFound, err, name)
+
+		usedAt := now.Add(time.Minute)
+		assert.Nil(t, s.ApiKeys.Touch(ctx, first.Id, usedAt), name)
+		assert.Equal(t, ErrNotFound, s.ApiKeys.Touch(ctx, uuid.NewV4().String(), usedAt), name)
+
+		keys, err := s.ApiKeys.ListByUser(ctx, user.Id)
+		assert.Nil(t, err, name)
+		if assert.Len(t, keys, 2, name) {
+			assert.Equal(t, first.Id, keys[0].Id, name)
+			assert.True(t, usedAt.Equal(*keys[0].LastUsedAt), name)
+			assert.Equal(t, second.Id, keys[1].Id, name)
+			assert.Nil(t, keys[1].ExpiresAt, name)
+		}
+
+		//A key is only deleted by its user
+		assert.Equal(t, ErrNotFound, s.ApiKeys.Delete(ctx, uuid.NewV4().String(), first.Id), name)
+		assert.Nil(t, s.ApiKeys.Delete(ctx, user.Id, first.Id), name)
+		assert.Equal(t, ErrNotFound, s.ApiKeys.Delete(ctx, user.Id, first.Id), name)
+
+		//The keys are purged along with their user
+		assert.Nil(t, s.Users.DeleteById(ctx, user.Id, AnyVersion, OnPostsReject), name)
+		assert.Nil(t, s.Purge(ctx, time.Now().UTC().Add(time.Second)), name)
+		keys, err = s.ApiKeys.ListByUser(ctx, user.Id)
+		assert.Nil(t, err, name)
+		assert.Empty(t, keys, name)
+	}
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed lets every operation through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails every operation fast with ErrUnavailable until the cooldown is over.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single trial operation through, its outcome closes or reopens the breaker.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// Breaker is a circuit breaker that opens after a number of consecutive failures,
+// so that the operations fail fast while the database is down instead of piling up.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	// now is replaced by the tests
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewBreaker returns a closed breaker opening after threshold consecutive failures for the cooldown period.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now, state: BreakerClosed}
+}
+
+// Allow returns ErrUnavailable when the operation must fail fast. Otherwise the outcome of the
+// operation must be reported with Success or Failure.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrUnavailable
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return nil
+	case BreakerHalfOpen:
+		//Only one trial at a time
+		if b.trial {
+			return ErrUnavailable
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Success closes the breaker.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure counts a failed operation, the breaker opens once the threshold is reached or when the trial failed.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+// Abandon gives up an operation whose outcome says nothing about the database, e.g. canceled by its caller.
+func (b *Breaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Open opens the breaker right away, e.g. when the database is known to be down.
+func (b *Breaker) Open() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open()
+}
+
+func (b *Breaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.trial = false
+}
+
+// State returns the current state of the breaker and, when open, how long until it lets a trial through.
+// An open breaker whose cooldown is over is half-open, the next operation is its trial.
+func (b *Breaker) State() (BreakerState, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerOpen {
+		return b.state, 0
+	}
+	retryAfter := b.cooldown - b.now().Sub(b.openedAt)
+	if retryAfter <= 0 {
+		return BreakerHalfOpen, 0
+	}
+	return b.state, retryAfter
+}
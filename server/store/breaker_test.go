@@ -0,0 +1,53 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	//Successes reset the consecutive failures
+	assert.Nil(t, breaker.Allow())
+	breaker.Failure()
+	assert.Nil(t, breaker.Allow())
+	breaker.Success()
+	assert.Nil(t, breaker.Allow())
+	breaker.Failure()
+	state, _ := breaker.State()
+	assert.Equal(t, BreakerClosed, state)
+
+	breaker.Failure()
+	state, retryAfter := breaker.State()
+	assert.Equal(t, BreakerOpen, state)
+	assert.Equal(t, time.Minute, retryAfter)
+	assert.Equal(t, ErrUnavailable, breaker.Allow())
+
+	//A single trial is let through once the cooldown is over, its failure reopens the breaker
+	now = now.Add(time.Minute)
+	state, retryAfter = breaker.State()
+	assert.Equal(t, BreakerHalfOpen, state)
+	assert.Equal(t, time.Duration(0), retryAfter)
+	assert.Nil(t, breaker.Allow())
+	assert.Equal(t, ErrUnavailable, breaker.Allow())
+	breaker.Failure()
+	state, _ = breaker.State()
+	assert.Equal(t, BreakerOpen, state)
+
+	//An abandoned trial lets another one through, a successful one closes the breaker
+	now = now.Add(time.Minute)
+	assert.Nil(t, breaker.Allow())
+	breaker.Abandon()
+	assert.Nil(t, breaker.Allow())
+	breaker.Success()
+	assert.Nil(t, breaker.Allow())
+	assert.Nil(t, breaker.Allow())
+
+	breaker.Open()
+	assert.Equal(t, ErrUnavailable, breaker.Allow())
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHashIsKept(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: name + "@abc.com",
+			PasswordHash: "first", Version: 1}
+		assert.Nil(t, s.Users.Insert(ctx, user), name)
+
+		//A user without a hash keeps the stored one
+		user.PasswordHash = ""
+		user.Name = "Dave"
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		stored, err := s.Users.GetByEmail(ctx, user.Email)
+		assert.Nil(t, err, name)
+		assert.Equal(t, "first", stored.PasswordHash, name)
+		assert.Equal(t, "Dave", stored.Name, name)
+
+		user.PasswordHash = "second"
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		stored, err = s.Users.GetById(ctx, user.Id)
+		assert.Nil(t, err, name)
+		assert.Equal(t, "second", stored.PasswordHash, name)
+	}
+}
+
+func TestOidcLinkIsKeptWithTheEmail(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: name + "@abc.com", Version: 1}
+		assert.Nil(t, s.Users.Insert(ctx, user), name)
+
+		user.OidcLinked = true
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		stored, err := s.Users.GetById(ctx, user.Id)
+		assert.Nil(t, err, name)
+		assert.True(t, stored.OidcLinked, name)
+
+		//An update keeping the email never unlinks the user
+		user.OidcLinked = false
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		stored, err = s.Users.GetByEmail(ctx, user.Email)
+		assert.Nil(t, err, name)
+		assert.True(t, stored.OidcLinked, name)
+
+		//The identity provider did not verify a new email, the user is unlinked
+		user.Email = name + "@xyz.com"
+		user.OidcLinked = true
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		stored, err = s.Users.GetByEmail(ctx, user.Email)
+		assert.Nil(t, err, name)
+		assert.False(t, stored.OidcLinked, name)
+	}
+}
+
+func TestTokenGenerationCountsCredentialChanges(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: name + "@abc.com",
+			PasswordHash: "first", Version: 1}
+		assert.Nil(t, s.Users.Insert(ctx, user), name)
+		generation := func() int64 {
+			stored, err := s.Users.GetById(ctx, user.Id)
+			assert.Nil(t, err, name)
+			return stored.TokenGeneration
+		}
+
+		//Neither the stored hash nor no hash changes the credentials
+		user.Name = "Dave"
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		user.PasswordHash = ""
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		assert.Equal(t, int64(0), generation(), name)
+
+		user.PasswordHash = "second"
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		assert.Equal(t, int64(1), generation(), name)
+		user.PasswordHash = ""
+		user.Email = name + "@xyz.com"
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		assert.Equal(t, int64(2), generation(), name)
+
+		//The generation is kept by the store, not taken from the update
+		user.TokenGeneration = 0
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		assert.Equal(t, int64(2), generation(), name)
+	}
+}
+
+func TestRefreshTokenIsRedeemedOnce(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		assert.Nil(t, s.RefreshTokens.Redeem(ctx, "expired", now.Add(-time.Hour)), name)
+		assert.Nil(t, s.RefreshTokens.Redeem(ctx, "valid", now.Add(time.Hour)), name)
+		assert.Equal(t, ErrDuplicate, s.RefreshTokens.Redeem(ctx, "valid", now.Add(time.Hour)), name)
+
+		//Only the expired tokens are purged, a valid one stays redeemed
+		count, err := s.RefreshTokens.Purge(ctx, now)
+		assert.Nil(t, err, name)
+		assert.Equal(t, int64(1), count, name)
+		assert.Equal(t, ErrDuplicate, s.RefreshTokens.Redeem(ctx, "valid", now.Add(time.Hour)), name)
+		assert.Nil(t, s.RefreshTokens.Redeem(ctx, "expired", now.Add(-time.Hour)), name)
+	}
+}
+
+func TestRoleIsKept(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: name + "@abc.com", Role: "author",
+			Version: 1}
+		assert.Nil(t, s.Users.Insert(ctx, user), name)
+
+		//A user without a role keeps the stored one
+		user.Role = ""
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		stored, err := s.Users.GetById(ctx, user.Id)
+		assert.Nil(t, err, name)
+		assert.Equal(t, "author", stored.Role, name)
+
+		user.Role = "admin"
+		assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion), name)
+		stored, err = s.Users.GetByEmail(ctx, user.Email)
+		assert.Nil(t, err, name)
+		assert.Equal(t, "admin", stored.Role, name)
+	}
+}
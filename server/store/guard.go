@@ -0,0 +1,248 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+)
+
+// guard runs every operation of the repositories with a deadline, through a circuit breaker
+type guard struct {
+	// timeout bounds every operation on top of the deadline of its caller
+	timeout time.Duration
+	breaker *Breaker
+	// ready tells whether the database is set up, the operations fail fast with ErrUnavailable until then
+	ready func() bool
+	// unavailable tells apart the errors of an unreachable database from the errors of an operation
+	unavailable func(error) bool
+}
+
+// Helper function to guard the repositories of the store
+func (g *guard) wrap(s Store) Store {
+	s.Users = &guardedUserRepository{users: s.Users, guard: g}
+	s.Posts = &guardedPostRepository{posts: s.Posts, guard: g}
+	s.ApiKeys = &guardedApiKeyRepository{apiKeys: s.ApiKeys, guard: g}
+	s.RefreshTokens = &guardedRefreshTokenRepository{refreshTokens: s.RefreshTokens, guard: g}
+	return s
+}
+
+func (g *guard) do(ctx context.Context, operation func(ctx context.Context) error) error {
+	if !g.ready() {
+		return ErrUnavailable
+	}
+	if err := g.breaker.Allow(); err != nil {
+		return err
+	}
+
+	operationCtx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	err := operation(operationCtx)
+	switch {
+	case ctx.Err() != nil:
+		g.breaker.Abandon()
+	case err != nil && g.unavailable(err):
+		g.breaker.Failure()
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	default:
+		g.breaker.Success()
+	}
+	return err
+}
+
+type guardedUserRepository struct {
+	users UserRepository
+	guard *guard
+}
+
+func (r *guardedUserRepository) Insert(ctx context.Context, user model.BlogUser) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.users.Insert(ctx, user)
+	})
+}
+
+func (r *guardedUserRepository) GetById(ctx context.Context, id string) (user model.BlogUser, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		user, err = r.users.GetById(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (r *guardedUserRepository) GetByEmail(ctx context.Context, email string) (user model.BlogUser, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		user, err = r.users.GetByEmail(ctx, email)
+		return err
+	})
+	return user, err
+}
+
+func (r *guardedUserRepository) Search(ctx context.Context, filter UserFilter) (users []model.BlogUser, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		users, err = r.users.Search(ctx, filter)
+		return err
+	})
+	return users, err
+}
+
+func (r *guardedUserRepository) Replace(ctx context.Context, user model.BlogUser, version int64) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.users.Replace(ctx, user, version)
+	})
+}
+
+func (r *guardedUserRepository) DeleteById(ctx context.Context, id string, version int64, onPosts OnPosts) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.users.DeleteById(ctx, id, version, onPosts)
+	})
+}
+
+func (r *guardedUserRepository) Trash(ctx context.Context) (users []model.BlogUser, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		users, err = r.users.Trash(ctx)
+		return err
+	})
+	return users, err
+}
+
+func (r *guardedUserRepository) Restore(ctx context.Context, id string) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.users.Restore(ctx, id)
+	})
+}
+
+func (r *guardedUserRepository) Purge(ctx context.Context, before time.Time) (count int64, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		count, err = r.users.Purge(ctx, before)
+		return err
+	})
+	return count, err
+}
+
+type guardedPostRepository struct {
+	posts PostRepository
+	guard *guard
+}
+
+func (r *guardedPostRepository) Insert(ctx context.Context, post model.BlogPost) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.posts.Insert(ctx, post)
+	})
+}
+
+func (r *guardedPostRepository) GetById(ctx context.Context, id string) (post model.BlogPost, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		post, err = r.posts.GetById(ctx, id)
+		return err
+	})
+	return post, err
+}
+
+func (r *guardedPostRepository) Search(ctx context.Context, filter PostFilter) (posts []model.BlogPost, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		posts, err = r.posts.Search(ctx, filter)
+		return err
+	})
+	return posts, err
+}
+
+func (r *guardedPostRepository) TextSearch(ctx context.Context, query TextQuery) (hits []TextHit, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		hits, err = r.posts.TextSearch(ctx, query)
+		return err
+	})
+	return hits, err
+}
+
+func (r *guardedPostRepository) Replace(ctx context.Context, post model.BlogPost, version int64) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.posts.Replace(ctx, post, version)
+	})
+}
+
+func (r *guardedPostRepository) DeleteById(ctx context.Context, id string, version int64) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.posts.DeleteById(ctx, id, version)
+	})
+}
+
+func (r *guardedPostRepository) Trash(ctx context.Context) (posts []model.BlogPost, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		posts, err = r.posts.Trash(ctx)
+		return err
+	})
+	return posts, err
+}
+
+func (r *guardedPostRepository) Restore(ctx context.Context, id string) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.posts.Restore(ctx, id)
+	})
+}
+
+func (r *guardedPostRepository) Purge(ctx context.Context, before time.Time) (count int64, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		count, err = r.posts.Purge(ctx, before)
+		return err
+	})
+	return count, err
+}
+
+type guardedApiKeyRepository struct {
+	apiKeys ApiKeyRepository
+	guard   *guard
+}
+
+func (r *guardedApiKeyRepository) Insert(ctx context.Context, key model.ApiKey) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.apiKeys.Insert(ctx, key)
+	})
+}
+
+func (r *guardedApiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (key model.ApiKey, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		key, err = r.apiKeys.GetByPrefix(ctx, prefix)
+		return err
+	})
+	return key, err
+}
+
+func (r *guardedApiKeyRepository) ListByUser(ctx context.Context, userId string) (keys []model.ApiKey, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		keys, err = r.apiKeys.ListByUser(ctx, userId)
+		return err
+	})
+	return keys, err
+}
+
+func (r *guardedApiKeyRepository) Delete(ctx context.Context, userId string, id string) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.apiKeys.Delete(ctx, userId, id)
+	})
+}
+
+func (r *guardedApiKeyRepository) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.apiKeys.Touch(ctx, id, usedAt)
+	})
+}
+
+type guardedRefreshTokenRepository struct {
+	refreshTokens RefreshTokenRepository
+	guard         *guard
+}
+
+func (r *guardedRefreshTokenRepository) Redeem(ctx context.Context, id string, expiresAt time.Time) error {
+	return r.guard.do(ctx, func(ctx context.Context) error {
+		return r.refreshTokens.Redeem(ctx, id, expiresAt)
+	})
+}
+
+func (r *guardedRefreshTokenRepository) Purge(ctx context.Context, before time.Time) (count int64, err error) {
+	err = r.guard.do(ctx, func(ctx context.Context) error {
+		count, err = r.refreshTokens.Purge(ctx, before)
+		return err
+	})
+	return count, err
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// failingUserRepository fails GetById with err and counts the calls
+type failingUserRepository struct {
+	UserRepository
+	err   error
+	calls int
+}
+
+func (r *failingUserRepository) GetById(ctx context.Context, id string) (model.BlogUser, error) {
+	r.calls++
+	if _, ok := ctx.Deadline(); !ok {
+		return model.BlogUser{}, errors.New("no deadline")
+	}
+	return model.BlogUser{Id: id}, r.err
+}
+
+func TestGuard(t *testing.T) {
+	ready := false
+	users := &failingUserRepository{}
+	g := &guard{
+		timeout:     time.Second,
+		breaker:     NewBreaker(2, time.Minute),
+		ready:       func() bool { return ready },
+		unavailable: func(err error) bool { return err == errUnreachable },
+	}
+	s := g.wrap(Store{Users: users})
+	ctx := context.Background()
+
+	_, err := s.Users.GetById(ctx, "id")
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Equal(t, 0, users.calls)
+
+	ready = true
+	user, err := s.Users.GetById(ctx, "id")
+	assert.Nil(t, err)
+	assert.Equal(t, "id", user.Id)
+
+	//The errors of the operations do not open the breaker
+	users.err = ErrNotFound
+	for i := 0; i < 3; i++ {
+		_, err = s.Users.GetById(ctx, "id")
+		assert.Equal(t, ErrNotFound, err)
+	}
+
+	users.err = errUnreachable
+	for i := 0; i < 2; i++ {
+		_, err = s.Users.GetById(ctx, "id")
+		assert.True(t, errors.Is(err, ErrUnavailable))
+	}
+	calls := users.calls
+	_, err = s.Users.GetById(ctx, "id")
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Equal(t, calls, users.calls)
+
+	//Operations canceled by their caller do not count
+	g.breaker.Success()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	for i := 0; i < 3; i++ {
+		_, _ = s.Users.GetById(canceled, "id")
+	}
+	state, _ := g.breaker.State()
+	assert.Equal(t, BreakerClosed, state)
+}
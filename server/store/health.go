@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// ConnectionState is the state of the database connection.
+type ConnectionState string
+
+const (
+	// ConnectionConnecting is the state until the first connection check succeeds.
+	ConnectionConnecting ConnectionState = "connecting"
+	// ConnectionUp is the state while the last connection check succeeded.
+	ConnectionUp ConnectionState = "up"
+	// ConnectionDown is the state while the last connection check failed, it is retried with backoff.
+	ConnectionDown ConnectionState = "down"
+)
+
+// Health is the state of the database connection as seen by the store.
+type Health struct {
+	State   ConnectionState `json:"state"`
+	Breaker BreakerState    `json:"breaker"`
+	// RetryAfter is how long the open breaker keeps failing the operations fast
+	RetryAfter time.Duration `json:"-"`
+	// Error is the error of the last connection check, empty when it succeeded
+	Error string `json:"error,omitempty"`
+	// CheckedAt is the time of the last connection check, zero when the connection is not checked
+	CheckedAt time.Time `json:"checkedAt,omitempty"`
+}
+
+// Available tells whether the store lets the operations through.
+func (h Health) Available() bool {
+	return h.State == ConnectionUp && h.Breaker != BreakerOpen
+}
+
+// Health reports the state of the database connection of the store.
+func (s Store) Health() Health {
+	if s.health == nil {
+		return Health{State: ConnectionUp, Breaker: BreakerClosed}
+	}
+	return s.health()
+}
+
+// Ping checks that the database of the store is reachable.
+func (s Store) Ping(ctx context.Context) error {
+	if s.ping == nil {
+		return nil
+	}
+	return s.ping(ctx)
+}
+
+// CheckSchema checks that the migrations of the database of the store are applied.
+func (s Store) CheckSchema(ctx context.Context) error {
+	if s.checkSchema == nil {
+		return nil
+	}
+	return s.checkSchema(ctx)
+}
+
+// WithHealth returns the store reporting the state of its database connection with the given function.
+func (s Store) WithHealth(health func() Health) Store {
+	s.health = health
+	return s
+}
@@ -0,0 +1,553 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+)
+
+// NewMemoryStore returns a Store keeping all the documents in process memory.
+// It is safe for concurrent use and is meant for tests and local development.
+func NewMemoryStore() Store {
+	users := &memoryUserRepository{users: map[string]model.BlogUser{}}
+	posts := &memoryPostRepository{posts: map[string]model.BlogPost{}, index: map[string]map[string]bool{}, users: users}
+	apiKeys := &memoryApiKeyRepository{keys: map[string]model.ApiKey{}}
+	users.posts = posts
+	users.apiKeys = apiKeys
+	return Store{Users: users, Posts: posts, ApiKeys: apiKeys,
+		RefreshTokens: &memoryRefreshTokenRepository{redeemed: map[string]time.Time{}}}
+}
+
+type memoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[string]model.BlogUser
+	// posts is locked after mu when deleting a user along with their posts
+	posts *memoryPostRepository
+	// apiKeys is locked after posts when purging a user along with their keys
+	apiKeys *memoryApiKeyRepository
+}
+
+func (r *memoryUserRepository) Insert(_ context.Context, user model.BlogUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.Id]; ok {
+		return ErrDuplicate
+	}
+	for _, existing := range r.users {
+		if existing.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+	r.users[user.Id] = user
+	return nil
+}
+
+func (r *memoryUserRepository) GetById(_ context.Context, id string) (model.BlogUser, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return model.BlogUser{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r *memoryUserRepository) GetByEmail(_ context.Context, email string) (model.BlogUser, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email && user.DeletedAt == nil {
+			return user, nil
+		}
+	}
+	return model.BlogUser{}, ErrNotFound
+}
+
+func (r *memoryUserRepository) Search(_ context.Context, filter UserFilter) ([]model.BlogUser, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := []model.BlogUser{}
+	for _, user := range r.users {
+		if user.DeletedAt != nil {
+			continue
+		}
+		if filter.Name != "" && user.Name != filter.Name {
+			continue
+		}
+		if filter.After != "" && user.Id <= filter.After {
+			continue
+		}
+		res = append(res, user)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	if filter.PageSize > 0 && int64(len(res)) > filter.PageSize {
+		res = res[:filter.PageSize]
+	}
+	return res, nil
+}
+
+func (r *memoryUserRepository) Replace(_ context.Context, user model.BlogUser, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.users[user.Id]
+	if !ok || current.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if !versionMatches(current.Version, version) {
+		return ErrVersionMismatch
+	}
+	for id, existing := range r.users {
+		if id != user.Id && existing.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+	user.Version = current.Version + 1
+	user.TokenGeneration = current.TokenGeneration
+	if credentialsChanged(current, user) {
+		user.TokenGeneration++
+	}
+	if user.PasswordHash == "" {
+		user.PasswordHash = current.PasswordHash
+	}
+	if user.Role == "" {
+		user.Role = current.Role
+	}
+	//The link to the identity provider is only kept with the email it verified
+	user.OidcLinked = user.Email == current.Email && (user.OidcLinked || current.OidcLinked)
+	r.users[user.Id] = user
+	return nil
+}
+
+func (r *memoryUserRepository) DeleteById(_ context.Context, id string, version int64, onPosts OnPosts) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.posts.mu.Lock()
+	defer r.posts.mu.Unlock()
+
+	current, ok := r.users[id]
+	if !ok || current.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if !versionMatches(current.Version, version) {
+		return ErrVersionMismatch
+	}
+
+	//Trashed posts only matter when reassigning, so that they can still be restored
+	var owned []model.BlogPost
+	policy := effectiveOnPosts(id, onPosts)
+	for _, post := range r.posts.posts {
+		if post.UserId == id && (post.DeletedAt == nil || policy == OnPostsReassign) {
+			owned = append(owned, post)
+		}
+	}
+	now := time.Now().UTC()
+	if len(owned) > 0 {
+		switch policy {
+		case OnPostsCascade:
+			for _, post := range owned {
+				post.DeletedAt = &now
+				post.Version++
+				r.posts.posts[post.Id] = post
+			}
+		case OnPostsReassign:
+			if _, ok := r.users[TombstoneUser.Id]; !ok {
+				r.users[TombstoneUser.Id] = newTombstoneUser()
+			}
+			for _, post := range owned {
+				post.UserId = TombstoneUser.Id
+				post.LastModifiedDate = now
+				post.Version++
+				r.posts.posts[post.Id] = post
+			}
+		default:
+			return ErrHasPosts
+		}
+	}
+	current.DeletedAt = &now
+	current.Version++
+	r.users[id] = current
+	return nil
+}
+
+func (r *memoryUserRepository) Trash(_ context.Context) ([]model.BlogUser, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := []model.BlogUser{}
+	for _, user := range r.users {
+		if user.DeletedAt != nil {
+			res = append(res, user)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return trashedBefore(*res[i].DeletedAt, res[i].Id, *res[j].DeletedAt, res[j].Id)
+	})
+	return res, nil
+}
+
+func (r *memoryUserRepository) Restore(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.posts.mu.Lock()
+	defer r.posts.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt == nil {
+		return ErrNotFound
+	}
+	for _, post := range r.posts.posts {
+		if post.UserId == id && post.DeletedAt != nil && post.DeletedAt.Equal(*user.DeletedAt) {
+			post.DeletedAt = nil
+			post.Version++
+			r.posts.posts[post.Id] = post
+		}
+	}
+	user.DeletedAt = nil
+	user.Version++
+	r.users[id] = user
+	return nil
+}
+
+func (r *memoryUserRepository) Purge(_ context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.posts.mu.Lock()
+	defer r.posts.mu.Unlock()
+	r.apiKeys.mu.Lock()
+	defer r.apiKeys.mu.Unlock()
+
+	var count int64
+	for id, user := range r.users {
+		if user.DeletedAt == nil || !user.DeletedAt.Before(before) {
+			continue
+		}
+		for _, post := range r.posts.posts {
+			if post.UserId == id {
+				delete(r.posts.posts, post.Id)
+				r.posts.unindexPost(post)
+			}
+		}
+		for _, key := range r.apiKeys.keys {
+			if key.UserId == id {
+				delete(r.apiKeys.keys, key.Id)
+			}
+		}
+		delete(r.users, id)
+		count++
+	}
+	return count, nil
+}
+
+type memoryPostRepository struct {
+	mu    sync.RWMutex
+	posts map[string]model.BlogPost
+	// index is the inverted index of the topic and content words to the ids of the posts containing them
+	index map[string]map[string]bool
+	// users is locked before mu when restoring a post
+	users *memoryUserRepository
+}
+
+func (r *memoryPostRepository) Insert(_ context.Context, post model.BlogPost) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.posts[post.Id]; ok {
+		return ErrDuplicate
+	}
+	r.posts[post.Id] = post
+	r.indexPost(post)
+	return nil
+}
+
+func (r *memoryPostRepository) GetById(_ context.Context, id string) (model.BlogPost, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	post, ok := r.posts[id]
+	if !ok || post.DeletedAt != nil {
+		return model.BlogPost{}, ErrNotFound
+	}
+	return post, nil
+}
+
+func (r *memoryPostRepository) Search(_ context.Context, filter PostFilter) ([]model.BlogPost, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key := filter.SortKey()
+	res := []model.BlogPost{}
+	for _, post := range r.posts {
+		if post.DeletedAt != nil || !filter.Matches(post) {
+			continue
+		}
+		if filter.After != nil && ComparePosts(post, *filter.After, key) <= 0 {
+			continue
+		}
+		res = append(res, post)
+	}
+	sort.Slice(res, func(i, j int) bool { return ComparePosts(res[i], res[j], key) < 0 })
+	if filter.PageSize > 0 && int64(len(res)) > filter.PageSize {
+		res = res[:filter.PageSize]
+	}
+	return res, nil
+}
+
+func (r *memoryPostRepository) Replace(_ context.Context, post model.BlogPost, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.posts[post.Id]
+	if !ok || current.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if !versionMatches(current.Version, version) {
+		return ErrVersionMismatch
+	}
+	post.Version = current.Version + 1
+	r.unindexPost(current)
+	r.posts[post.Id] = post
+	r.indexPost(post)
+	return nil
+}
+
+func (r *memoryPostRepository) DeleteById(_ context.Context, id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.posts[id]
+	if !ok || current.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if !versionMatches(current.Version, version) {
+		return ErrVersionMismatch
+	}
+	now := time.Now().UTC()
+	current.DeletedAt = &now
+	current.Version++
+	r.posts[id] = current
+	return nil
+}
+
+func (r *memoryPostRepository) Trash(_ context.Context) ([]model.BlogPost, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := []model.BlogPost{}
+	for _, post := range r.posts {
+		if post.DeletedAt != nil {
+			res = append(res, post)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return trashedBefore(*res[i].DeletedAt, res[i].Id, *res[j].DeletedAt, res[j].Id)
+	})
+	return res, nil
+}
+
+func (r *memoryPostRepository) Restore(_ context.Context, id string) error {
+	r.users.mu.RLock()
+	defer r.users.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	post, ok := r.posts[id]
+	if !ok || post.DeletedAt == nil {
+		return ErrNotFound
+	}
+	if user, ok := r.users.users[post.UserId]; !ok || user.DeletedAt != nil {
+		return ErrOwnerDeleted
+	}
+	post.DeletedAt = nil
+	post.Version++
+	r.posts[id] = post
+	return nil
+}
+
+func (r *memoryPostRepository) Purge(_ context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for id, post := range r.posts {
+		if post.DeletedAt != nil && post.DeletedAt.Before(before) {
+			delete(r.posts, id)
+			r.unindexPost(post)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryPostRepository) TextSearch(_ context.Context, query TextQuery) ([]TextHit, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	//Every match contains at least one of the query words, so only their postings need scoring
+	candidates := map[string]bool{}
+	for _, word := range query.Words() {
+		for id := range r.index[word] {
+			candidates[id] = true
+		}
+	}
+
+	res := []TextHit{}
+	for id := range candidates {
+		if r.posts[id].DeletedAt != nil {
+			continue
+		}
+		score, ok := query.Score(r.posts[id])
+		if !ok {
+			continue
+		}
+		hit := TextHit{Post: r.posts[id], Score: score}
+		if query.After != nil && CompareHits(hit, *query.After) <= 0 {
+			continue
+		}
+		res = append(res, hit)
+	}
+	sort.Slice(res, func(i, j int) bool { return CompareHits(res[i], res[j]) < 0 })
+	if query.PageSize > 0 && int64(len(res)) > query.PageSize {
+		res = res[:query.PageSize]
+	}
+	return res, nil
+}
+
+// Helper function to add the words of the post to the inverted index
+func (r *memoryPostRepository) indexPost(post model.BlogPost) {
+	for _, word := range postWords(post) {
+		if r.index[word] == nil {
+			r.index[word] = map[string]bool{}
+		}
+		r.index[word][post.Id] = true
+	}
+}
+
+// Helper function to remove the words of the post from the inverted index
+func (r *memoryPostRepository) unindexPost(post model.BlogPost) {
+	for _, word := range postWords(post) {
+		delete(r.index[word], post.Id)
+		if len(r.index[word]) == 0 {
+			delete(r.index, word)
+		}
+	}
+}
+
+type memoryApiKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]model.ApiKey
+}
+
+func (r *memoryApiKeyRepository) Insert(_ context.Context, key model.ApiKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.keys {
+		if existing.Id == key.Id || existing.Prefix == key.Prefix {
+			return ErrDuplicate
+		}
+	}
+	//The scopes of the caller must not alias the stored ones
+	key.Scopes = append([]string(nil), key.Scopes...)
+	r.keys[key.Id] = key
+	return nil
+}
+
+func (r *memoryApiKeyRepository) GetByPrefix(_ context.Context, prefix string) (model.ApiKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+	return model.ApiKey{}, ErrNotFound
+}
+
+func (r *memoryApiKeyRepository) ListByUser(_ context.Context, userId string) ([]model.ApiKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := []model.ApiKey{}
+	for _, key := range r.keys {
+		if key.UserId == userId {
+			res = append(res, key)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].CreatedAt.Before(res[j].CreatedAt)
+		}
+		return res[i].Id < res[j].Id
+	})
+	return res, nil
+}
+
+func (r *memoryApiKeyRepository) Delete(_ context.Context, userId string, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok || key.UserId != userId {
+		return ErrNotFound
+	}
+	delete(r.keys, id)
+	return nil
+}
+
+func (r *memoryApiKeyRepository) Touch(_ context.Context, id string, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	key.LastUsedAt = &usedAt
+	r.keys[id] = key
+	return nil
+}
+
+type memoryRefreshTokenRepository struct {
+	mu sync.Mutex
+	// redeemed holds the expiry of the redeemed tokens by id
+	redeemed map[string]time.Time
+}
+
+func (r *memoryRefreshTokenRepository) Redeem(_ context.Context, id string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.redeemed[id]; ok {
+		return ErrDuplicate
+	}
+	r.redeemed[id] = expiresAt
+	return nil
+}
+
+func (r *memoryRefreshTokenRepository) Purge(_ context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for id, expiresAt := range r.redeemed {
+		if expiresAt.Before(before) {
+			delete(r.redeemed, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Helper function to check the stored version against the expected one
+func versionMatches(stored, expected int64) bool {
+	return expected == AnyVersion || stored == expected
+}
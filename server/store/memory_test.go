@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	model "github.com/gouthams/blogApp/server/model"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryUserEmailIsUnique(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	err := s.Users.Insert(ctx, model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: "david@abc.com"})
+	assert.Nil(t, err)
+
+	err = s.Users.Insert(ctx, model.BlogUser{Id: uuid.NewV4().String(), Name: "Dave", Email: "david@abc.com"})
+	assert.Equal(t, ErrDuplicate, err)
+
+	user, err := s.Users.GetByEmail(ctx, "david@abc.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "David", user.Name)
+}
+
+func TestMemoryConcurrentUserInsert(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Users.Insert(ctx, model.BlogUser{Id: uuid.NewV4().String(), Name: "Matt", Email: "matt@abc.com"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	inserted := 0
+	for err := range errs {
+		if err == nil {
+			inserted++
+		}
+	}
+	assert.Equal(t, 1, inserted)
+}
+
+func TestMemoryPostSearch(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	userId := uuid.NewV4().String()
+
+	for i := 0; i < 5; i++ {
+		owner := userId
+		if i%2 == 1 {
+			owner = uuid.NewV4().String()
+		}
+		post := model.BlogPost{Id: uuid.NewV4().String(), UserId: owner, Topic: fmt.Sprintf("Topic%d", i), Content: "Content"}
+		assert.Nil(t, s.Posts.Insert(ctx, post))
+	}
+
+	posts, err := s.Posts.Search(ctx, PostFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, posts, 5)
+
+	posts, err = s.Posts.Search(ctx, PostFilter{UserIds: []string{userId}})
+	assert.Nil(t, err)
+	assert.Len(t, posts, 3)
+	assert.True(t, posts[0].Id < posts[1].Id)
+
+	posts, err = s.Posts.Search(ctx, PostFilter{UserIds: []string{userId}, PageSize: 2})
+	assert.Nil(t, err)
+	assert.Len(t, posts, 2)
+
+	rest, err := s.Posts.Search(ctx, PostFilter{UserIds: []string{userId}, PageSize: 2, After: &posts[1]})
+	assert.Nil(t, err)
+	assert.Len(t, rest, 1)
+	assert.True(t, posts[1].Id < rest[0].Id)
+
+	assert.Nil(t, s.Posts.DeleteById(ctx, posts[0].Id, AnyVersion))
+	assert.Equal(t, ErrNotFound, s.Posts.DeleteById(ctx, posts[0].Id, AnyVersion))
+	_, err = s.Posts.GetById(ctx, posts[0].Id)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryReplace(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: "david@abc.com"}
+	assert.Equal(t, ErrNotFound, s.Users.Replace(ctx, user, AnyVersion))
+	assert.Nil(t, s.Users.Insert(ctx, user))
+
+	other := model.BlogUser{Id: uuid.NewV4().String(), Name: "Matt", Email: "matt@abc.com"}
+	assert.Nil(t, s.Users.Insert(ctx, other))
+
+	other.Email = user.Email
+	assert.Equal(t, ErrDuplicate, s.Users.Replace(ctx, other, AnyVersion))
+
+	user.Name = "Dave"
+	assert.Nil(t, s.Users.Replace(ctx, user, AnyVersion))
+	stored, err := s.Users.GetById(ctx, user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Dave", stored.Name)
+
+	users, err := s.Users.Search(ctx, UserFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, users, 2)
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/utils"
+)
+
+// migration is a single versioned schema change of the sql store.
+type migration struct {
+	Version     int
+	Description string
+	Statements  []string
+	// Apply optionally migrates the data once the Statements are executed
+	Apply func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations must be appended in increasing Version order and never edited once released.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "create blogUser and blogPost tables",
+		Statements: []string{
+			`CREATE TABLE blog_user (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				email TEXT NOT NULL,
+				last_modified_date TEXT NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX blog_user_email_idx ON blog_user (email)`,
+			`CREATE TABLE blog_post (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES blog_user (id),
+				topic TEXT NOT NULL,
+				content TEXT NOT NULL,
+				last_modified_date TEXT NOT NULL
+			)`,
+			`CREATE INDEX blog_post_user_id_idx ON blog_post (user_id)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "add optimistic concurrency version columns",
+		Statements: []string{
+			`ALTER TABLE blog_user ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+			`ALTER TABLE blog_post ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "add full-text inverted index of the blogPost words",
+		Statements: []string{
+			`CREATE TABLE blog_post_term (
+				term TEXT NOT NULL,
+				post_id TEXT NOT NULL REFERENCES blog_post (id) ON DELETE CASCADE,
+				PRIMARY KEY (term, post_id)
+			)`,
+			`CREATE INDEX blog_post_term_post_id_idx ON blog_post_term (post_id)`,
+		},
+		Apply: indexExistingPosts,
+	},
+	{
+		Version:     4,
+		Description: "add soft delete timestamps",
+		Statements: []string{
+			`ALTER TABLE blog_user ADD COLUMN deleted_at TEXT`,
+			`ALTER TABLE blog_post ADD COLUMN deleted_at TEXT`,
+			`CREATE INDEX blog_user_deleted_at_idx ON blog_user (deleted_at)`,
+			`CREATE INDEX blog_post_deleted_at_idx ON blog_post (deleted_at)`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "add the password hash of the blogUsers",
+		Statements: []string{
+			`ALTER TABLE blog_user ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "add the role of the blogUsers",
+		Statements: []string{
+			`ALTER TABLE blog_user ADD COLUMN role TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "create the api keys of the blogUsers",
+		Statements: []string{
+			`CREATE TABLE api_key (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES blog_user (id),
+				name TEXT NOT NULL,
+				prefix TEXT NOT NULL,
+				key_hash TEXT NOT NULL,
+				scopes TEXT NOT NULL,
+				expires_at TEXT,
+				created_at TEXT NOT NULL,
+				last_used_at TEXT
+			)`,
+			`CREATE UNIQUE INDEX api_key_prefix_idx ON api_key (prefix)`,
+			`CREATE INDEX api_key_user_id_idx ON api_key (user_id)`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "add the oidc link of the blogUsers",
+		Statements: []string{
+			`ALTER TABLE blog_user ADD COLUMN oidc_linked INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "add the token generation of the blogUsers and the redeemed refresh tokens",
+		Statements: []string{
+			`ALTER TABLE blog_user ADD COLUMN token_generation INTEGER NOT NULL DEFAULT 0`,
+			`CREATE TABLE refresh_token (
+				id TEXT PRIMARY KEY,
+				expires_at TEXT NOT NULL
+			)`,
+			`CREATE INDEX refresh_token_expires_at_idx ON refresh_token (expires_at)`,
+		},
+	},
+}
+
+// Migrate applies every pending migration in order, each one in its own transaction,
+// and records the applied versions in the schema_migrations table.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	logEntry := utils.Log()
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations table: %w", err)
+	}
+
+	current, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		logEntry.Infof("Applying migration %d: %s", m.Version, m.Description)
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migration %d failed: %w", m.Version, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the latest applied migration version, 0 if none has been applied.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// LatestSchemaVersion returns the version the schema has once every migration is applied.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.Statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if m.Apply != nil {
+		if err := m.Apply(ctx, tx); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Description, time.Now().UTC().Format(sqlTimeLayout))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// indexExistingPosts fills the inverted index with the posts stored before it existed
+func indexExistingPosts(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, topic, content FROM blog_post`)
+	if err != nil {
+		return err
+	}
+	var posts []model.BlogPost
+	for rows.Next() {
+		var post model.BlogPost
+		if err := rows.Scan(&post.Id, &post.Topic, &post.Content); err != nil {
+			rows.Close()
+			return err
+		}
+		posts = append(posts, post)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		if err := indexPostTerms(ctx, tx, post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
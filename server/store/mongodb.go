@@ -0,0 +1,768 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// replaceAttempts bounds the reads of an unconditional replace of a user updated concurrently
+const replaceAttempts = 3
+
+// MongoConfig configures the connection to mongoDB.
+type MongoConfig struct {
+	// URI is the connection string, credentials in it are overridden by Username and Password.
+	URI string `yaml:"uri"`
+	// Username and Password authenticate against AuthSource, no authentication when Username is empty.
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	AuthSource string `yaml:"authSource"`
+	// Database holds the UserCollection, PostCollection, ApiKeyCollection and RefreshTokenCollection collections.
+	Database               string `yaml:"database"`
+	UserCollection         string `yaml:"userCollection"`
+	PostCollection         string `yaml:"postCollection"`
+	ApiKeyCollection       string `yaml:"apiKeyCollection"`
+	RefreshTokenCollection string `yaml:"refreshTokenCollection"`
+	// ConnectTimeout bounds every connection check, along with the index creation of the first one.
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// ServerSelectionTimeout bounds the wait for a suitable server of every operation.
+	ServerSelectionTimeout time.Duration `yaml:"serverSelectionTimeout"`
+	// SocketTimeout bounds every read and write on a connection, 0 means no timeout.
+	SocketTimeout time.Duration `yaml:"socketTimeout"`
+	// MaxPoolSize and MinPoolSize bound the connections kept per server.
+	MaxPoolSize uint64 `yaml:"maxPoolSize"`
+	MinPoolSize uint64 `yaml:"minPoolSize"`
+	// OperationTimeout bounds every store operation on top of the deadline of its request.
+	OperationTimeout time.Duration `yaml:"operationTimeout"`
+	// HeartbeatInterval is how often the connection is checked while it is up.
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval"`
+	// ReconnectMinBackoff and ReconnectMaxBackoff bound the exponential backoff between the checks
+	// of a connection that is down.
+	ReconnectMinBackoff time.Duration `yaml:"reconnectMinBackoff"`
+	ReconnectMaxBackoff time.Duration `yaml:"reconnectMaxBackoff"`
+	// BreakerThreshold consecutive failed operations open the circuit breaker, the operations then fail fast
+	// with ErrUnavailable for BreakerCooldown.
+	BreakerThreshold int           `yaml:"breakerThreshold"`
+	BreakerCooldown  time.Duration `yaml:"breakerCooldown"`
+}
+
+// DefaultMongoConfig connects to a local mongoDB without authentication.
+func DefaultMongoConfig() MongoConfig {
+	return MongoConfig{
+		URI:                    "mongodb://localhost:27017",
+		Database:               "blogDB",
+		UserCollection:         "blogUser",
+		PostCollection:         "blogPost",
+		ApiKeyCollection:       "apiKey",
+		RefreshTokenCollection: "refreshToken",
+		ConnectTimeout:         60 * time.Second,
+		ServerSelectionTimeout: 30 * time.Second,
+		MaxPoolSize:            100,
+		OperationTimeout:       10 * time.Second,
+		HeartbeatInterval:      10 * time.Second,
+		ReconnectMinBackoff:    500 * time.Millisecond,
+		ReconnectMaxBackoff:    30 * time.Second,
+		BreakerThreshold:       5,
+		BreakerCooldown:        10 * time.Second,
+	}
+}
+
+// clientOptions builds the driver options of the configuration
+func (c MongoConfig) clientOptions() *options.ClientOptions {
+	clientOptions := options.Client().ApplyURI(c.URI).
+		SetConnectTimeout(c.ConnectTimeout).
+		SetServerSelectionTimeout(c.ServerSelectionTimeout).
+		SetMaxPoolSize(c.MaxPoolSize).
+		SetMinPoolSize(c.MinPoolSize)
+	if c.SocketTimeout > 0 {
+		clientOptions.SetSocketTimeout(c.SocketTimeout)
+	}
+	if c.Username != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username:   c.Username,
+			Password:   c.Password,
+			AuthSource: c.AuthSource,
+		})
+	}
+	return clientOptions
+}
+
+// FlushCollections drops the configured user, post, api key and refresh token collections of the given database.
+func FlushCollections(db *mongo.Database, config MongoConfig) error {
+	logEntry := utils.Log()
+	ctx := context.Background()
+	err := db.Collection(config.UserCollection).Drop(ctx)
+	if err != nil {
+		logEntry.Errorf("Drop on user collection failed %v", err)
+		return err
+	}
+
+	err = db.Collection(config.PostCollection).Drop(ctx)
+	if err != nil {
+		logEntry.Errorf("Drop on blogPost collection failed %v", err)
+		return err
+	}
+
+	err = db.Collection(config.ApiKeyCollection).Drop(ctx)
+	if err != nil {
+		logEntry.Errorf("Drop on apiKey collection failed %v", err)
+		return err
+	}
+
+	err = db.Collection(config.RefreshTokenCollection).Drop(ctx)
+	if err != nil {
+		logEntry.Errorf("Drop on refreshToken collection failed %v", err)
+		return err
+	}
+
+	return nil
+}
+
+type mongoUserRepository struct {
+	collection *mongo.Collection
+	// posts is updated along with the users deleted with an OnPosts policy
+	posts *mongo.Collection
+	// apiKeys is purged along with the users
+	apiKeys *mongo.Collection
+	// transactions tells whether the deployment runs multi-document transactions
+	transactions func() bool
+}
+
+func (r *mongoUserRepository) Insert(ctx context.Context, user model.BlogUser) error {
+	_, err := r.collection.InsertOne(ctx, user)
+	return mongoError(err)
+}
+
+func (r *mongoUserRepository) GetById(ctx context.Context, id string) (model.BlogUser, error) {
+	return r.findOne(ctx, live(bson.D{{Key: "id", Value: id}}))
+}
+
+func (r *mongoUserRepository) GetByEmail(ctx context.Context, email string) (model.BlogUser, error) {
+	return r.findOne(ctx, live(bson.D{{Key: "email", Value: email}}))
+}
+
+func (r *mongoUserRepository) findOne(ctx context.Context, filter bson.D) (model.BlogUser, error) {
+	var user model.BlogUser
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return model.BlogUser{}, ErrNotFound
+	}
+	if err != nil {
+		return model.BlogUser{}, err
+	}
+	return user, nil
+}
+
+func (r *mongoUserRepository) Search(ctx context.Context, filter UserFilter) ([]model.BlogUser, error) {
+	query := live(bson.D{})
+	if filter.Name != "" {
+		query = append(query, bson.E{Key: "name", Value: filter.Name})
+	}
+	if filter.After != "" {
+		query = append(query, bson.E{Key: "id", Value: bson.D{{Key: "$gt", Value: filter.After}}})
+	}
+	return r.find(ctx, query, pageOptions(filter.PageSize))
+}
+
+func (r *mongoUserRepository) find(ctx context.Context, query bson.D, findOptions *options.FindOptions) ([]model.BlogUser, error) {
+	cursor, err := r.collection.Find(ctx, query, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	//Explicitly initialize the slice with empty value to return if none found
+	res := []model.BlogUser{}
+	for cursor.Next(ctx) {
+		var user model.BlogUser
+		//If the is issue with one user log the error and continue
+		if err := cursor.Decode(&user); err != nil {
+			utils.Log().Errorf("Unable to decode user: %v", err)
+			continue
+		}
+		res = append(res, user)
+	}
+	return res, cursor.Err()
+}
+
+// Replace reads the stored user to tell whether the credentials change, then writes the version it read. An
+// unconditional replace reads again when the user changed meanwhile, up to replaceAttempts times.
+func (r *mongoUserRepository) Replace(ctx context.Context, user model.BlogUser, version int64) error {
+	for attempt := 1; ; attempt++ {
+		var current model.BlogUser
+		err := r.collection.FindOne(ctx, versionFilter(user.Id, version)).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			return missedWrite(ctx, r.collection, user.Id)
+		}
+		if err != nil {
+			return err
+		}
+
+		generation := current.TokenGeneration
+		if credentialsChanged(current, user) {
+			generation++
+		}
+		fields := bson.D{
+			{Key: "name", Value: user.Name},
+			{Key: "email", Value: user.Email},
+			{Key: "lastmodifieddate", Value: user.LastModifiedDate},
+			//The link to the identity provider is only kept with the email it verified
+			{Key: "oidclinked", Value: user.Email == current.Email && (user.OidcLinked || current.OidcLinked)},
+			{Key: "tokengeneration", Value: generation},
+		}
+		if user.PasswordHash != "" {
+			fields = append(fields, bson.E{Key: "passwordhash", Value: user.PasswordHash})
+		}
+		if user.Role != "" {
+			fields = append(fields, bson.E{Key: "role", Value: user.Role})
+		}
+		err = updateOne(ctx, r.collection, user.Id, current.Version, fields)
+		if err == ErrVersionMismatch && version == AnyVersion && attempt < replaceAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+// DeleteById runs in a multi-document transaction, which needs MongoDB 4.0 or later deployed as a replica set.
+// Other deployments move the user to the trash with a versioned write before touching the posts, so that it is
+// neither updated nor given posts meanwhile, and put it back with its trashed posts when the policy fails.
+func (r *mongoUserRepository) DeleteById(ctx context.Context, id string, version int64, onPosts OnPosts) error {
+	return r.inTransaction(ctx, func(ctx context.Context) error {
+		return r.deleteWithPosts(ctx, id, version, onPosts)
+	})
+}
+
+func (r *mongoUserRepository) deleteWithPosts(ctx context.Context, id string, version int64, onPosts OnPosts) error {
+	//Trash the user first, the posts must be left alone when it does not match
+	now := time.Now().UTC()
+	err := updateOne(ctx, r.collection, id, version, bson.D{{Key: "deletedat", Value: now}})
+	if err != nil {
+		return err
+	}
+
+	//Trashed posts only matter when reassigning, so that they can still be restored
+	owned := bson.D{{Key: "userid", Value: id}}
+	switch effectiveOnPosts(id, onPosts) {
+	case OnPostsCascade:
+		_, err = r.posts.UpdateMany(ctx, live(owned), bson.D{
+			{Key: "$set", Value: bson.D{{Key: "deletedat", Value: now}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		})
+	case OnPostsReassign:
+		tombstone := newTombstoneUser()
+		_, err = r.collection.UpdateOne(ctx, bson.D{{Key: "id", Value: tombstone.Id}},
+			bson.D{{Key: "$setOnInsert", Value: bson.D{
+				{Key: "name", Value: tombstone.Name},
+				{Key: "email", Value: tombstone.Email},
+				{Key: "lastmodifieddate", Value: tombstone.LastModifiedDate},
+				{Key: "version", Value: tombstone.Version},
+			}}}, options.Update().SetUpsert(true))
+		if err == nil {
+			_, err = r.posts.UpdateMany(ctx, owned, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "userid", Value: tombstone.Id},
+					{Key: "lastmodifieddate", Value: tombstone.LastModifiedDate},
+				}},
+				{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+			})
+		}
+	default:
+		var count int64
+		count, err = r.posts.CountDocuments(ctx, live(owned))
+		if err == nil && count > 0 {
+			err = ErrHasPosts
+		}
+	}
+	//A transaction is rolled back as a whole
+	if err != nil && !r.transactions() {
+		if undoErr := r.undoDelete(ctx, id, now); undoErr != nil {
+			utils.Log().Errorf("Unable to put back the user with id: %s: %v", id, undoErr)
+		}
+	}
+	return err
+}
+
+// Helper function to put back the user trashed at the given time along with its posts, the user gets its version
+// back as nothing changed it in the trash. The posts reassigned meanwhile stay with the tombstone user.
+func (r *mongoUserRepository) undoDelete(ctx context.Context, id string, deletedAt time.Time) error {
+	_, err := r.posts.UpdateMany(ctx, bson.D{{Key: "userid", Value: id}, {Key: "deletedat", Value: deletedAt}},
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: "deletedat", Value: nil}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		})
+	if err != nil {
+		return err
+	}
+	_, err = r.collection.UpdateOne(ctx, bson.D{{Key: "id", Value: id}, {Key: "deletedat", Value: deletedAt}},
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: "deletedat", Value: nil}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: -1}}},
+		})
+	return err
+}
+
+func (r *mongoUserRepository) Trash(ctx context.Context) ([]model.BlogUser, error) {
+	return r.find(ctx, trashed(bson.D{}), trashOptions())
+}
+
+func (r *mongoUserRepository) Restore(ctx context.Context, id string) error {
+	return r.inTransaction(ctx, func(ctx context.Context) error {
+		var user model.BlogUser
+		err := r.collection.FindOne(ctx, trashed(bson.D{{Key: "id", Value: id}})).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		//The posts trashed along with the user share its deletion time
+		restore := bson.D{
+			{Key: "$set", Value: bson.D{{Key: "deletedat", Value: nil}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}
+		_, err = r.posts.UpdateMany(ctx, bson.D{{Key: "userid", Value: id}, {Key: "deletedat", Value: user.DeletedAt}}, restore)
+		if err != nil {
+			return err
+		}
+		_, err = r.collection.UpdateOne(ctx, bson.D{{Key: "id", Value: id}}, restore)
+		return err
+	})
+}
+
+func (r *mongoUserRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := r.inTransaction(ctx, func(ctx context.Context) error {
+		expired := bson.D{{Key: "deletedat", Value: bson.D{{Key: "$lt", Value: before}}}}
+		users, err := r.find(ctx, expired, options.Find())
+		if err != nil || len(users) == 0 {
+			return err
+		}
+		ids := bson.A{}
+		for _, user := range users {
+			ids = append(ids, user.Id)
+		}
+		_, err = r.posts.DeleteMany(ctx, bson.D{{Key: "userid", Value: bson.D{{Key: "$in", Value: ids}}}})
+		if err != nil {
+			return err
+		}
+		_, err = r.apiKeys.DeleteMany(ctx, bson.D{{Key: "userid", Value: bson.D{{Key: "$in", Value: ids}}}})
+		if err != nil {
+			return err
+		}
+		res, err := r.collection.DeleteMany(ctx, bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}}})
+		if err != nil {
+			return err
+		}
+		count = res.DeletedCount
+		return nil
+	})
+	return count, err
+}
+
+// inTransaction runs fn in a multi-document transaction when the deployment supports them
+func (r *mongoUserRepository) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !r.transactions() {
+		return fn(ctx)
+	}
+	return r.collection.Database().Client().UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
+	})
+}
+
+type mongoPostRepository struct {
+	collection *mongo.Collection
+	// users is checked when restoring a post, its user must not be in the trash
+	users *mongo.Collection
+}
+
+func (r *mongoPostRepository) Insert(ctx context.Context, post model.BlogPost) error {
+	_, err := r.collection.InsertOne(ctx, post)
+	return mongoError(err)
+}
+
+func (r *mongoPostRepository) GetById(ctx context.Context, id string) (model.BlogPost, error) {
+	var post model.BlogPost
+	err := r.collection.FindOne(ctx, live(bson.D{{Key: "id", Value: id}})).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		return model.BlogPost{}, ErrNotFound
+	}
+	if err != nil {
+		return model.BlogPost{}, err
+	}
+	return post, nil
+}
+
+func (r *mongoPostRepository) Search(ctx context.Context, filter PostFilter) ([]model.BlogPost, error) {
+	key := filter.SortKey()
+	findOptions := options.Find().SetSort(mongoSort(key)).SetLimit(filter.PageSize)
+	return r.find(ctx, mongoPostQuery(filter, key), findOptions)
+}
+
+func (r *mongoPostRepository) find(ctx context.Context, query bson.D, findOptions *options.FindOptions) ([]model.BlogPost, error) {
+	cursor, err := r.collection.Find(ctx, query, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	//Explicitly initialize the slice with empty value to return if none found
+	res := []model.BlogPost{}
+	for cursor.Next(ctx) {
+		var post model.BlogPost
+		//If the is issue with one post log the error and continue
+		if err := cursor.Decode(&post); err != nil {
+			utils.Log().Errorf("Unable to decode post: %v", err)
+			continue
+		}
+		res = append(res, post)
+	}
+	return res, cursor.Err()
+}
+
+func (r *mongoPostRepository) TextSearch(ctx context.Context, query TextQuery) ([]TextHit, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: live(bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: query.Raw}}}})}},
+		{{Key: "$addFields", Value: bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}}},
+	}
+	if query.After != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "score", Value: bson.D{{Key: "$lt", Value: query.After.Score}}}},
+			bson.D{{Key: "score", Value: query.After.Score}, {Key: "id", Value: bson.D{{Key: "$gt", Value: query.After.Post.Id}}}},
+		}}}}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "id", Value: 1}}}})
+	if query.PageSize > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: query.PageSize}})
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	res := []TextHit{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			model.BlogPost `bson:",inline"`
+			Score          float64 `bson:"score"`
+		}
+		//If the is issue with one post log the error and continue
+		if err := cursor.Decode(&doc); err != nil {
+			utils.Log().Errorf("Unable to decode post: %v", err)
+			continue
+		}
+		res = append(res, TextHit{Post: doc.BlogPost, Score: doc.Score})
+	}
+	return res, cursor.Err()
+}
+
+func (r *mongoPostRepository) Replace(ctx context.Context, post model.BlogPost, version int64) error {
+	return updateOne(ctx, r.collection, post.Id, version, bson.D{
+		{Key: "userid", Value: post.UserId},
+		{Key: "topic", Value: post.Topic},
+		{Key: "content", Value: post.Content},
+		{Key: "lastmodifieddate", Value: post.LastModifiedDate},
+	})
+}
+
+func (r *mongoPostRepository) DeleteById(ctx context.Context, id string, version int64) error {
+	return updateOne(ctx, r.collection, id, version, bson.D{{Key: "deletedat", Value: time.Now().UTC()}})
+}
+
+func (r *mongoPostRepository) Trash(ctx context.Context) ([]model.BlogPost, error) {
+	return r.find(ctx, trashed(bson.D{}), trashOptions())
+}
+
+func (r *mongoPostRepository) Restore(ctx context.Context, id string) error {
+	var post model.BlogPost
+	err := r.collection.FindOne(ctx, trashed(bson.D{{Key: "id", Value: id}})).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	owners, err := r.users.CountDocuments(ctx, live(bson.D{{Key: "id", Value: post.UserId}}))
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrOwnerDeleted
+	}
+
+	//Only restore the post as it was found, a concurrent restore or purge wins
+	res, err := r.collection.UpdateOne(ctx, bson.D{{Key: "id", Value: id}, {Key: "deletedat", Value: post.DeletedAt}},
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: "deletedat", Value: nil}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoPostRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.collection.DeleteMany(ctx, bson.D{{Key: "deletedat", Value: bson.D{{Key: "$lt", Value: before}}}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// mongoPostFields maps the sortable post fields to their document keys
+var mongoPostFields = map[string]string{
+	SortById:               "id",
+	SortByUserId:           "userid",
+	SortByTopic:            "topic",
+	SortByLastModifiedDate: "lastmodifieddate",
+}
+
+// Helper function to translate the post filter into a query document
+func mongoPostQuery(filter PostFilter, key []SortField) bson.D {
+	query := live(bson.D{})
+	if len(filter.UserIds) > 0 {
+		query = append(query, bson.E{Key: "userid", Value: bson.D{{Key: "$in", Value: filter.UserIds}}})
+	}
+	topic := bson.D{}
+	if filter.Topic != "" {
+		topic = append(topic, bson.E{Key: "$eq", Value: filter.Topic})
+	}
+	if filter.TopicPrefix != "" {
+		topic = append(topic, bson.E{Key: "$regex", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(filter.TopicPrefix)}})
+	}
+	if len(topic) > 0 {
+		query = append(query, bson.E{Key: "topic", Value: topic})
+	}
+	if filter.Content != "" {
+		query = append(query, bson.E{Key: "content", Value: primitive.Regex{Pattern: regexp.QuoteMeta(filter.Content)}})
+	}
+	dateRange := bson.D{}
+	if !filter.Since.IsZero() {
+		dateRange = append(dateRange, bson.E{Key: "$gte", Value: filter.Since})
+	}
+	if !filter.Until.IsZero() {
+		dateRange = append(dateRange, bson.E{Key: "$lt", Value: filter.Until})
+	}
+	if len(dateRange) > 0 {
+		query = append(query, bson.E{Key: "lastmodifieddate", Value: dateRange})
+	}
+	if filter.After != nil {
+		query = append(query, bson.E{Key: "$or", Value: mongoAfter(*filter.After, key)})
+	}
+	return query
+}
+
+// Helper function to match the posts following the cursor in sort order:
+// (k0 > v0) or (k0 = v0 and k1 > v1) or ... with the comparison flipped for descending fields
+func mongoAfter(after model.BlogPost, key []SortField) bson.A {
+	values := map[string]interface{}{
+		SortById:               after.Id,
+		SortByUserId:           after.UserId,
+		SortByTopic:            after.Topic,
+		SortByLastModifiedDate: after.LastModifiedDate,
+	}
+	or := bson.A{}
+	for i, field := range key {
+		clause := bson.D{}
+		for _, previous := range key[:i] {
+			clause = append(clause, bson.E{Key: mongoPostFields[previous.Field], Value: values[previous.Field]})
+		}
+		op := "$gt"
+		if field.Descending {
+			op = "$lt"
+		}
+		clause = append(clause, bson.E{Key: mongoPostFields[field.Field], Value: bson.D{{Key: op, Value: values[field.Field]}}})
+		or = append(or, clause)
+	}
+	return or
+}
+
+// Helper function to build the sort document of the given sort key
+func mongoSort(key []SortField) bson.D {
+	sort := bson.D{}
+	for _, field := range key {
+		direction := 1
+		if field.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: mongoPostFields[field.Field], Value: direction})
+	}
+	return sort
+}
+
+// Helper function to find out whether the deployment supports multi-document transactions,
+// that is a replica set or sharded cluster of MongoDB 4.0 or later
+func supportsTransactions(ctx context.Context, db *mongo.Database) (bool, error) {
+	var isMaster struct {
+		SetName        string `bson:"setName"`
+		Msg            string `bson:"msg"`
+		MaxWireVersion int    `bson:"maxWireVersion"`
+	}
+	err := db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&isMaster)
+	if err != nil {
+		return false, err
+	}
+	//Wire version 7 is MongoDB 4.0
+	supported := (isMaster.SetName != "" || isMaster.Msg == "isdbgrid") && isMaster.MaxWireVersion >= 7
+	if !supported {
+		utils.Log().Warn("Db does not support transactions, a user is trashed before its posts and put back on failure")
+	}
+	return supported, nil
+}
+
+// Helper function to build the find options returning a page of documents ordered by id
+func pageOptions(pageSize int64) *options.FindOptions {
+	return options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetLimit(pageSize)
+}
+
+// Helper function to build the filter matching the document with the given id and version
+func versionFilter(id string, version int64) bson.D {
+	filter := live(bson.D{{Key: "id", Value: id}})
+	if version != AnyVersion {
+		filter = append(filter, bson.E{Key: "version", Value: version})
+	}
+	return filter
+}
+
+// Helper function to tell apart a missing document from a version mismatch
+// when a conditional write did not match anything
+func missedWrite(ctx context.Context, collection *mongo.Collection, id string) error {
+	count, err := collection.CountDocuments(ctx, live(bson.D{{Key: "id", Value: id}}))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionMismatch
+}
+
+type mongoApiKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoApiKeyRepository) Insert(ctx context.Context, key model.ApiKey) error {
+	_, err := r.collection.InsertOne(ctx, key)
+	return mongoError(err)
+}
+
+func (r *mongoApiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (model.ApiKey, error) {
+	var key model.ApiKey
+	err := r.collection.FindOne(ctx, bson.D{{Key: "prefix", Value: prefix}}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return model.ApiKey{}, ErrNotFound
+	}
+	if err != nil {
+		return model.ApiKey{}, err
+	}
+	return key, nil
+}
+
+func (r *mongoApiKeyRepository) ListByUser(ctx context.Context, userId string) ([]model.ApiKey, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "userid", Value: userId}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	res := []model.ApiKey{}
+	for cursor.Next(ctx) {
+		var key model.ApiKey
+		if err := cursor.Decode(&key); err != nil {
+			utils.Log().Errorf("Unable to decode api key: %v", err)
+			continue
+		}
+		res = append(res, key)
+	}
+	return res, cursor.Err()
+}
+
+func (r *mongoApiKeyRepository) Delete(ctx context.Context, userId string, id string) error {
+	res, err := r.collection.DeleteOne(ctx, bson.D{{Key: "id", Value: id}, {Key: "userid", Value: userId}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoApiKeyRepository) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	res, err := r.collection.UpdateOne(ctx, bson.D{{Key: "id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lastusedat", Value: usedAt}}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Helper function to update a single document in place by the given id and version, without upserting.
+// The version is incremented in the same operation.
+func updateOne(ctx context.Context, collection *mongo.Collection, id string, version int64, fields bson.D) error {
+	update := bson.D{
+		{Key: "$set", Value: fields},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+	res, err := collection.UpdateOne(ctx, versionFilter(id, version), update)
+	if err != nil {
+		return mongoError(err)
+	}
+	if res.MatchedCount == 0 {
+		return missedWrite(ctx, collection, id)
+	}
+	return nil
+}
+
+// Helper function to restrict the filter to the documents that are not in the trash
+func live(filter bson.D) bson.D {
+	return append(filter, bson.E{Key: "deletedat", Value: nil})
+}
+
+// Helper function to restrict the filter to the documents in the trash
+func trashed(filter bson.D) bson.D {
+	return append(filter, bson.E{Key: "deletedat", Value: bson.D{{Key: "$ne", Value: nil}}})
+}
+
+// Helper function to order the trash by most recent deletion first, then by id
+func trashOptions() *options.FindOptions {
+	return options.Find().SetSort(bson.D{{Key: "deletedat", Value: -1}, {Key: "id", Value: 1}})
+}
+
+type mongoRefreshTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoRefreshTokenRepository) Redeem(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := r.collection.InsertOne(ctx, bson.D{{Key: "id", Value: id}, {Key: "expiresat", Value: expiresAt}})
+	return mongoError(err)
+}
+
+func (r *mongoRefreshTokenRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.collection.DeleteMany(ctx, bson.D{{Key: "expiresat", Value: bson.D{{Key: "$lt", Value: before}}}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
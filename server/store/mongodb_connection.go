@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gouthams/blogApp/server/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// mongoConnection checks the connection to mongoDB in the background, with an exponential backoff while it is down,
+// and sets the database up on the first successful check
+type mongoConnection struct {
+	config  MongoConfig
+	client  *mongo.Client
+	db      *mongo.Database
+	breaker *Breaker
+	stop    context.CancelFunc
+	done    chan struct{}
+
+	mu           sync.Mutex
+	state        ConnectionState
+	lastError    error
+	checkedAt    time.Time
+	initialized  bool
+	transactions bool
+}
+
+// OpenMongo returns a Store backed by the configured collections of mongoDB. It does not wait for mongoDB to be
+// reachable: the operations fail fast with ErrUnavailable until the connection is up and the indexes are created,
+// and whenever the circuit breaker is open. Every operation is bounded by the OperationTimeout.
+func OpenMongo(config MongoConfig) (Store, error) {
+	client, err := mongo.NewClient(config.clientOptions())
+	if err != nil {
+		return Store{}, fmt.Errorf("create mongo client: %w", err)
+	}
+	//Connect only starts monitoring the servers, it does not wait for them
+	ctx, cancel := context.WithTimeout(context.Background(), config.ConnectTimeout)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		return Store{}, fmt.Errorf("connect to mongo: %w", err)
+	}
+
+	monitorCtx, stop := context.WithCancel(context.Background())
+	conn := &mongoConnection{
+		config:  config,
+		client:  client,
+		db:      client.Database(config.Database),
+		breaker: NewBreaker(config.BreakerThreshold, config.BreakerCooldown),
+		stop:    stop,
+		done:    make(chan struct{}),
+		state:   ConnectionConnecting,
+	}
+	go conn.monitor(monitorCtx)
+
+	users := conn.db.Collection(config.UserCollection)
+	posts := conn.db.Collection(config.PostCollection)
+	apiKeys := conn.db.Collection(config.ApiKeyCollection)
+	refreshTokens := conn.db.Collection(config.RefreshTokenCollection)
+	s := Store{
+		Users: &mongoUserRepository{collection: users, posts: posts, apiKeys: apiKeys,
+			transactions: conn.supportsTransactions},
+		Posts:         &mongoPostRepository{collection: posts, users: users},
+		ApiKeys:       &mongoApiKeyRepository{collection: apiKeys},
+		RefreshTokens: &mongoRefreshTokenRepository{collection: refreshTokens},
+		close:         conn.close,
+		health:        conn.health,
+		ping:          conn.ping,
+		checkSchema:   conn.checkSchema,
+	}
+	g := &guard{timeout: config.OperationTimeout, breaker: conn.breaker, ready: conn.ready, unavailable: mongoUnavailable}
+	return g.wrap(s), nil
+}
+
+// monitor checks the connection until the context is done
+func (m *mongoConnection) monitor(ctx context.Context) {
+	defer close(m.done)
+	backoff := m.config.ReconnectMinBackoff
+	for {
+		wait := m.config.HeartbeatInterval
+		if err := m.check(ctx); err != nil {
+			wait = backoff
+			backoff *= 2
+			if backoff > m.config.ReconnectMaxBackoff {
+				backoff = m.config.ReconnectMaxBackoff
+			}
+		} else {
+			backoff = m.config.ReconnectMinBackoff
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// check pings the database, sets it up if not done yet, and records the outcome
+func (m *mongoConnection) check(ctx context.Context) error {
+	checkCtx, cancel := context.WithTimeout(ctx, m.config.ConnectTimeout)
+	defer cancel()
+	err := m.client.Ping(checkCtx, nil)
+	if err == nil && !m.ready() {
+		err = m.initialize(checkCtx)
+	}
+	if ctx.Err() != nil {
+		//Stopped while checking, the outcome says nothing about the database
+		return err
+	}
+
+	logEntry := utils.Log()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.state
+	m.lastError = err
+	m.checkedAt = time.Now().UTC()
+	if err != nil {
+		m.state = ConnectionDown
+		m.breaker.Open()
+		if previous != ConnectionDown {
+			logEntry.Errorf("Db connection is down, %v", err)
+		}
+		return err
+	}
+	m.state = ConnectionUp
+	//Only the breaker opened by a failed check is closed here, the one opened by failed operations waits for a trial
+	if previous != ConnectionUp {
+		m.breaker.Success()
+	}
+	if previous != ConnectionUp {
+		logEntry.Infof("Db connection is up: %s", m.db.Name())
+	}
+	return nil
+}
+
+// initialize creates the indexes and detects the transaction support, once the database is reachable
+func (m *mongoConnection) initialize(ctx context.Context) error {
+	//Lookups by id and email need indexes, the unique ones also enforce the email uniqueness
+	if err := ensureIndexes(ctx, m.db, m.config); err != nil {
+		return fmt.Errorf("db index creation failed: %w", err)
+	}
+	transactions, err := supportsTransactions(ctx, m.db)
+	if err != nil {
+		return fmt.Errorf("unable to check the transaction support: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.initialized = true
+	m.transactions = transactions
+	return nil
+}
+
+func (m *mongoConnection) ready() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.initialized
+}
+
+func (m *mongoConnection) supportsTransactions() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.transactions
+}
+
+func (m *mongoConnection) health() Health {
+	breaker, retryAfter := m.breaker.State()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := Health{State: m.state, Breaker: breaker, RetryAfter: retryAfter, CheckedAt: m.checkedAt}
+	if m.lastError != nil {
+		health.Error = m.lastError.Error()
+	}
+	return health
+}
+
+// ping checks the database right away while the connection is up, it does not wait for a connection that is down
+func (m *mongoConnection) ping(ctx context.Context) error {
+	if health := m.health(); health.State != ConnectionUp {
+		return fmt.Errorf("connection is %s: %s", health.State, health.Error)
+	}
+	return m.client.Ping(ctx, nil)
+}
+
+// checkSchema checks that the indexes are created
+func (m *mongoConnection) checkSchema(_ context.Context) error {
+	if !m.ready() {
+		return errors.New("indexes are not created yet")
+	}
+	return nil
+}
+
+// close stops checking the connection and disconnects from mongoDB
+func (m *mongoConnection) close() error {
+	m.stop()
+	<-m.done
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.ConnectTimeout)
+	defer cancel()
+	return m.client.Disconnect(ctx)
+}
+
+// Helper function to tell apart the errors of an unreachable mongoDB from the errors of an operation
+func mongoUnavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) {
+		return commandError.HasErrorLabel("NetworkError")
+	}
+	var connectionError topology.ConnectionError
+	if errors.As(err, &connectionError) {
+		return true
+	}
+	//The driver does not wrap the server selection errors
+	return strings.HasPrefix(err.Error(), "server selection error")
+}
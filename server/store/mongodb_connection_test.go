@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestOpenMongoUnreachable(t *testing.T) {
+	config := DefaultMongoConfig()
+	//Nothing listens on the port 1
+	config.URI = "mongodb://127.0.0.1:1"
+	config.ConnectTimeout = 200 * time.Millisecond
+	config.ServerSelectionTimeout = 100 * time.Millisecond
+	config.ReconnectMinBackoff = 10 * time.Millisecond
+	config.ReconnectMaxBackoff = 20 * time.Millisecond
+
+	s, err := OpenMongo(config)
+	assert.Nil(t, err)
+	assert.Equal(t, ConnectionConnecting, s.Health().State)
+	assert.False(t, s.Health().Available())
+
+	//Operations fail fast rather than waiting for the server selection
+	start := time.Now()
+	_, err = s.Users.GetById(context.Background(), "id")
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Less(t, int64(time.Since(start)), int64(config.ServerSelectionTimeout))
+
+	assert.Eventually(t, func() bool {
+		return s.Health().State == ConnectionDown
+	}, 5*time.Second, 10*time.Millisecond)
+	health := s.Health()
+	assert.Equal(t, BreakerOpen, health.Breaker)
+	assert.NotEmpty(t, health.Error)
+	assert.False(t, health.CheckedAt.IsZero())
+
+	assert.Nil(t, s.Close())
+}
+
+func TestMongoUnavailable(t *testing.T) {
+	assert.True(t, mongoUnavailable(context.DeadlineExceeded))
+	assert.True(t, mongoUnavailable(mongo.ErrClientDisconnected))
+	assert.True(t, mongoUnavailable(mongo.CommandError{Labels: []string{"NetworkError"}}))
+	assert.True(t, mongoUnavailable(errors.New("server selection error: server selection timeout")))
+	assert.False(t, mongoUnavailable(mongo.CommandError{Code: 2, Message: "bad value"}))
+	assert.False(t, mongoUnavailable(ErrNotFound))
+}
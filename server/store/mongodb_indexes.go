@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gouthams/blogApp/server/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server error codes of a unique index violation
+var duplicateKeyCodes = map[int]bool{11000: true, 11001: true, 12582: true}
+
+// The indexes of the user, post and api key collections are created at startup, creating an existing index is a no-op.
+// The unique indexes also cover the trashed documents, so a trashed user's email stays taken.
+var (
+	blogUserIndexes = []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("blogUser_id").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("blogUser_email").SetUnique(true),
+		},
+	}
+	blogPostIndexes = []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("blogPost_id").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "userid", Value: 1}, {Key: "lastmodifieddate", Value: 1}},
+			Options: options.Index().SetName("blogPost_userid_lastmodifieddate"),
+		},
+		{
+			//Full-text search of the posts needs a text index
+			Keys: bson.D{{Key: "topic", Value: "text"}, {Key: "content", Value: "text"}},
+			Options: options.Index().SetName("blogPost_text").SetWeights(bson.D{
+				{Key: "topic", Value: topicTextWeight},
+				{Key: "content", Value: contentTextWeight},
+			}),
+		},
+	}
+	apiKeyIndexes = []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("apiKey_id").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "prefix", Value: 1}},
+			Options: options.Index().SetName("apiKey_prefix").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "userid", Value: 1}, {Key: "createdat", Value: 1}},
+			Options: options.Index().SetName("apiKey_userid_createdat"),
+		},
+	}
+	refreshTokenIndexes = []mongo.IndexModel{
+		{
+			//A refresh token is redeemed once
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("refreshToken_id").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresat", Value: 1}},
+			Options: options.Index().SetName("refreshToken_expiresat"),
+		},
+	}
+)
+
+// Helper function to create the indexes of every collection
+func ensureIndexes(ctx context.Context, db *mongo.Database, config MongoConfig) error {
+	logEntry := utils.Log()
+	collections := map[string][]mongo.IndexModel{
+		config.UserCollection:         blogUserIndexes,
+		config.PostCollection:         blogPostIndexes,
+		config.ApiKeyCollection:       apiKeyIndexes,
+		config.RefreshTokenCollection: refreshTokenIndexes,
+	}
+	for collection, indexes := range collections {
+		names, err := db.Collection(collection).Indexes().CreateMany(ctx, indexes)
+		if err != nil {
+			return fmt.Errorf("create indexes of %s: %w", collection, err)
+		}
+		logEntry.Infof("Ensured indexes of %s: %v", collection, names)
+	}
+	return nil
+}
+
+// Helper function to map the unique index violations to ErrDuplicate
+func mongoError(err error) error {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if duplicateKeyCodes[writeError.Code] {
+				return ErrDuplicate
+			}
+		}
+	}
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) && duplicateKeyCodes[int(commandError.Code)] {
+		return ErrDuplicate
+	}
+	return err
+}
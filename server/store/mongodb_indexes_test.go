@@ -0,0 +1,23 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMongoError(t *testing.T) {
+	duplicate := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	assert.Equal(t, ErrDuplicate, mongoError(duplicate))
+	assert.Equal(t, ErrDuplicate, mongoError(fmt.Errorf("insert: %w", duplicate)))
+	assert.Equal(t, ErrDuplicate, mongoError(mongo.CommandError{Code: 11000}))
+
+	other := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121, Message: "Document failed validation"}}}
+	assert.Equal(t, other, mongoError(other))
+	err := errors.New("connection refused")
+	assert.Equal(t, err, mongoError(err))
+	assert.Nil(t, mongoError(nil))
+}
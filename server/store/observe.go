@@ -0,0 +1,251 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+)
+
+// Observer is told about every operation of the repositories of a store by the repository and the operation names
+// when the operation starts. It returns the context the operation runs with, and the function told about the error
+// the operation returned once it is done, nil when it succeeded.
+type Observer func(ctx context.Context, repository string, operation string) (context.Context, func(err error))
+
+// Observe returns the store telling the observer about every operation of its repositories.
+func (s Store) Observe(observer Observer) Store {
+	s.Users = &observedUserRepository{users: s.Users, observer: observer}
+	s.Posts = &observedPostRepository{posts: s.Posts, observer: observer}
+	s.ApiKeys = &observedApiKeyRepository{apiKeys: s.ApiKeys, observer: observer}
+	s.RefreshTokens = &observedRefreshTokenRepository{refreshTokens: s.RefreshTokens, observer: observer}
+	return s
+}
+
+// Helper function to tell the observer about the operation
+func observe(ctx context.Context, observer Observer, repository string, operation string,
+	do func(ctx context.Context) error) error {
+	ctx, done := observer(ctx, repository, operation)
+	err := do(ctx)
+	done(err)
+	return err
+}
+
+type observedUserRepository struct {
+	users    UserRepository
+	observer Observer
+}
+
+// Helper function to observe an operation of the user repository
+func (r *observedUserRepository) observe(ctx context.Context, operation string,
+	do func(ctx context.Context) error) error {
+	return observe(ctx, r.observer, "users", operation, do)
+}
+
+func (r *observedUserRepository) Insert(ctx context.Context, user model.BlogUser) error {
+	return r.observe(ctx, "Insert", func(ctx context.Context) error {
+		return r.users.Insert(ctx, user)
+	})
+}
+
+func (r *observedUserRepository) GetById(ctx context.Context, id string) (user model.BlogUser, err error) {
+	err = r.observe(ctx, "GetById", func(ctx context.Context) error {
+		user, err = r.users.GetById(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (r *observedUserRepository) GetByEmail(ctx context.Context, email string) (user model.BlogUser, err error) {
+	err = r.observe(ctx, "GetByEmail", func(ctx context.Context) error {
+		user, err = r.users.GetByEmail(ctx, email)
+		return err
+	})
+	return user, err
+}
+
+func (r *observedUserRepository) Search(ctx context.Context, filter UserFilter) (users []model.BlogUser, err error) {
+	err = r.observe(ctx, "Search", func(ctx context.Context) error {
+		users, err = r.users.Search(ctx, filter)
+		return err
+	})
+	return users, err
+}
+
+func (r *observedUserRepository) Replace(ctx context.Context, user model.BlogUser, version int64) error {
+	return r.observe(ctx, "Replace", func(ctx context.Context) error {
+		return r.users.Replace(ctx, user, version)
+	})
+}
+
+func (r *observedUserRepository) DeleteById(ctx context.Context, id string, version int64, onPosts OnPosts) error {
+	return r.observe(ctx, "DeleteById", func(ctx context.Context) error {
+		return r.users.DeleteById(ctx, id, version, onPosts)
+	})
+}
+
+func (r *observedUserRepository) Trash(ctx context.Context) (users []model.BlogUser, err error) {
+	err = r.observe(ctx, "Trash", func(ctx context.Context) error {
+		users, err = r.users.Trash(ctx)
+		return err
+	})
+	return users, err
+}
+
+func (r *observedUserRepository) Restore(ctx context.Context, id string) error {
+	return r.observe(ctx, "Restore", func(ctx context.Context) error {
+		return r.users.Restore(ctx, id)
+	})
+}
+
+func (r *observedUserRepository) Purge(ctx context.Context, before time.Time) (count int64, err error) {
+	err = r.observe(ctx, "Purge", func(ctx context.Context) error {
+		count, err = r.users.Purge(ctx, before)
+		return err
+	})
+	return count, err
+}
+
+type observedPostRepository struct {
+	posts    PostRepository
+	observer Observer
+}
+
+// Helper function to observe an operation of the post repository
+func (r *observedPostRepository) observe(ctx context.Context, operation string,
+	do func(ctx context.Context) error) error {
+	return observe(ctx, r.observer, "posts", operation, do)
+}
+
+func (r *observedPostRepository) Insert(ctx context.Context, post model.BlogPost) error {
+	return r.observe(ctx, "Insert", func(ctx context.Context) error {
+		return r.posts.Insert(ctx, post)
+	})
+}
+
+func (r *observedPostRepository) GetById(ctx context.Context, id string) (post model.BlogPost, err error) {
+	err = r.observe(ctx, "GetById", func(ctx context.Context) error {
+		post, err = r.posts.GetById(ctx, id)
+		return err
+	})
+	return post, err
+}
+
+func (r *observedPostRepository) Search(ctx context.Context, filter PostFilter) (posts []model.BlogPost, err error) {
+	err = r.observe(ctx, "Search", func(ctx context.Context) error {
+		posts, err = r.posts.Search(ctx, filter)
+		return err
+	})
+	return posts, err
+}
+
+func (r *observedPostRepository) TextSearch(ctx context.Context, query TextQuery) (hits []TextHit, err error) {
+	err = r.observe(ctx, "TextSearch", func(ctx context.Context) error {
+		hits, err = r.posts.TextSearch(ctx, query)
+		return err
+	})
+	return hits, err
+}
+
+func (r *observedPostRepository) Replace(ctx context.Context, post model.BlogPost, version int64) error {
+	return r.observe(ctx, "Replace", func(ctx context.Context) error {
+		return r.posts.Replace(ctx, post, version)
+	})
+}
+
+func (r *observedPostRepository) DeleteById(ctx context.Context, id string, version int64) error {
+	return r.observe(ctx, "DeleteById", func(ctx context.Context) error {
+		return r.posts.DeleteById(ctx, id, version)
+	})
+}
+
+func (r *observedPostRepository) Trash(ctx context.Context) (posts []model.BlogPost, err error) {
+	err = r.observe(ctx, "Trash", func(ctx context.Context) error {
+		posts, err = r.posts.Trash(ctx)
+		return err
+	})
+	return posts, err
+}
+
+func (r *observedPostRepository) Restore(ctx context.Context, id string) error {
+	return r.observe(ctx, "Restore", func(ctx context.Context) error {
+		return r.posts.Restore(ctx, id)
+	})
+}
+
+func (r *observedPostRepository) Purge(ctx context.Context, before time.Time) (count int64, err error) {
+	err = r.observe(ctx, "Purge", func(ctx context.Context) error {
+		count, err = r.posts.Purge(ctx, before)
+		return err
+	})
+	return count, err
+}
+
+type observedApiKeyRepository struct {
+	apiKeys  ApiKeyRepository
+	observer Observer
+}
+
+// Helper function to observe an operation of the api key repository
+func (r *observedApiKeyRepository) observe(ctx context.Context, operation string,
+	do func(ctx context.Context) error) error {
+	return observe(ctx, r.observer, "apiKeys", operation, do)
+}
+
+func (r *observedApiKeyRepository) Insert(ctx context.Context, key model.ApiKey) error {
+	return r.observe(ctx, "Insert", func(ctx context.Context) error {
+		return r.apiKeys.Insert(ctx, key)
+	})
+}
+
+func (r *observedApiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (key model.ApiKey, err error) {
+	err = r.observe(ctx, "GetByPrefix", func(ctx context.Context) error {
+		key, err = r.apiKeys.GetByPrefix(ctx, prefix)
+		return err
+	})
+	return key, err
+}
+
+func (r *observedApiKeyRepository) ListByUser(ctx context.Context, userId string) (keys []model.ApiKey, err error) {
+	err = r.observe(ctx, "ListByUser", func(ctx context.Context) error {
+		keys, err = r.apiKeys.ListByUser(ctx, userId)
+		return err
+	})
+	return keys, err
+}
+
+func (r *observedApiKeyRepository) Delete(ctx context.Context, userId string, id string) error {
+	return r.observe(ctx, "Delete", func(ctx context.Context) error {
+		return r.apiKeys.Delete(ctx, userId, id)
+	})
+}
+
+func (r *observedApiKeyRepository) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	return r.observe(ctx, "Touch", func(ctx context.Context) error {
+		return r.apiKeys.Touch(ctx, id, usedAt)
+	})
+}
+
+type observedRefreshTokenRepository struct {
+	refreshTokens RefreshTokenRepository
+	observer      Observer
+}
+
+// Helper function to observe an operation of the refresh token repository
+func (r *observedRefreshTokenRepository) observe(ctx context.Context, operation string,
+	do func(ctx context.Context) error) error {
+	return observe(ctx, r.observer, "refreshTokens", operation, do)
+}
+
+func (r *observedRefreshTokenRepository) Redeem(ctx context.Context, id string, expiresAt time.Time) error {
+	return r.observe(ctx, "Redeem", func(ctx context.Context) error {
+		return r.refreshTokens.Redeem(ctx, id, expiresAt)
+	})
+}
+
+func (r *observedRefreshTokenRepository) Purge(ctx context.Context, before time.Time) (count int64, err error) {
+	err = r.observe(ctx, "Purge", func(ctx context.Context) error {
+		count, err = r.refreshTokens.Purge(ctx, before)
+		return err
+	})
+	return count, err
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	model "github.com/gouthams/blogApp/server/model"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserve(t *testing.T) {
+	type observation struct {
+		repository string
+		operation  string
+		err        error
+	}
+	var observed []observation
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	s := NewMemoryStore().Observe(func(ctx context.Context, repository string, operation string) (context.Context,
+		func(error)) {
+		assert.Equal(t, "request", ctx.Value(key{}))
+		return ctx, func(err error) {
+			observed = append(observed, observation{repository, operation, err})
+		}
+	})
+
+	user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: "david@abc.com", Version: 1}
+	assert.Nil(t, s.Users.Insert(ctx, user))
+	assert.Equal(t, ErrDuplicate, s.Users.Insert(ctx, user))
+	found, err := s.Users.GetByEmail(ctx, user.Email)
+	assert.Nil(t, err)
+	assert.Equal(t, user.Id, found.Id)
+	_, err = s.Posts.GetById(ctx, uuid.NewV4().String())
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Equal(t, []observation{
+		{"users", "Insert", nil},
+		{"users", "Insert", ErrDuplicate},
+		{"users", "GetByEmail", nil},
+		{"posts", "GetById", ErrNotFound},
+	}, observed)
+}
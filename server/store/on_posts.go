@@ -0,0 +1,59 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+)
+
+// ErrHasPosts is returned when deleting a user who still has posts with OnPostsReject.
+var ErrHasPosts = errors.New("user still has posts")
+
+// OnPosts tells what happens to the posts of a deleted user.
+type OnPosts string
+
+const (
+	// OnPostsReject refuses to delete a user who still has posts with ErrHasPosts.
+	OnPostsReject OnPosts = "reject"
+	// OnPostsCascade deletes the posts along with their user.
+	OnPostsCascade OnPosts = "cascade"
+	// OnPostsReassign hands the posts over to the TombstoneUser.
+	OnPostsReassign OnPosts = "reassign"
+)
+
+// ParseOnPosts returns the OnPosts policy with the given name.
+func ParseOnPosts(value string) (OnPosts, error) {
+	switch onPosts := OnPosts(value); onPosts {
+	case OnPostsReject, OnPostsCascade, OnPostsReassign:
+		return onPosts, nil
+	default:
+		return "", fmt.Errorf("invalid onPosts: %s, must be one of %s, %s or %s",
+			value, OnPostsReject, OnPostsCascade, OnPostsReassign)
+	}
+}
+
+// TombstoneUser owns the posts reassigned from deleted users. It is created on first use.
+// Its own posts can not be reassigned to itself, so deleting it with OnPostsReassign behaves like OnPostsReject.
+var TombstoneUser = model.BlogUser{
+	Id:      "00000000-0000-0000-0000-000000000000",
+	Name:    "Deleted user",
+	Email:   "deleted-user@tombstone.invalid",
+	Version: 1,
+}
+
+// Helper function to create the tombstone user as of now
+func newTombstoneUser() model.BlogUser {
+	user := TombstoneUser
+	user.LastModifiedDate = time.Now().UTC()
+	return user
+}
+
+// Helper function to resolve the policy that actually applies when deleting the user with the given id
+func effectiveOnPosts(id string, onPosts OnPosts) OnPosts {
+	if onPosts == OnPostsReassign && id == TombstoneUser.Id {
+		return OnPostsReject
+	}
+	return onPosts
+}
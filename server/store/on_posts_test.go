@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	model "github.com/gouthams/blogApp/server/model"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteUserOnPosts(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		newUser := func() model.BlogUser {
+			user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: uuid.NewV4().String() + "@abc.com", Version: 1}
+			assert.Nil(t, s.Users.Insert(ctx, user), name)
+			for i := 0; i < 2; i++ {
+				post := model.BlogPost{Id: uuid.NewV4().String(), UserId: user.Id, Topic: "Topic", Content: "Content", Version: 1}
+				assert.Nil(t, s.Posts.Insert(ctx, post), name)
+			}
+			return user
+		}
+		postsOf := func(userId string) []model.BlogPost {
+			posts, err := s.Posts.Search(ctx, PostFilter{UserIds: []string{userId}})
+			assert.Nil(t, err, name)
+			return posts
+		}
+
+		//Nothing changes when the delete is rejected
+		user := newUser()
+		assert.Equal(t, ErrHasPosts, s.Users.DeleteById(ctx, user.Id, AnyVersion, OnPostsReject), name)
+		assert.Equal(t, ErrVersionMismatch, s.Users.DeleteById(ctx, user.Id, 2, OnPostsCascade), name)
+		_, err := s.Users.GetById(ctx, user.Id)
+		assert.Nil(t, err, name)
+		assert.Len(t, postsOf(user.Id), 2, name)
+
+		assert.Nil(t, s.Users.DeleteById(ctx, user.Id, 1, OnPostsCascade), name)
+		_, err = s.Users.GetById(ctx, user.Id)
+		assert.Equal(t, ErrNotFound, err, name)
+		assert.Empty(t, postsOf(user.Id), name)
+
+		user = newUser()
+		assert.Nil(t, s.Users.DeleteById(ctx, user.Id, AnyVersion, OnPostsReassign), name)
+		assert.Empty(t, postsOf(user.Id), name)
+		reassigned := postsOf(TombstoneUser.Id)
+		assert.Len(t, reassigned, 2, name)
+		assert.Equal(t, int64(2), reassigned[0].Version, name)
+		tombstone, err := s.Users.GetById(ctx, TombstoneUser.Id)
+		assert.Nil(t, err, name)
+		assert.Equal(t, TombstoneUser.Email, tombstone.Email, name)
+
+		//The tombstone is reused and can not take over its own posts
+		user = newUser()
+		assert.Nil(t, s.Users.DeleteById(ctx, user.Id, AnyVersion, OnPostsReassign), name)
+		assert.Len(t, postsOf(TombstoneUser.Id), 4, name)
+		assert.Equal(t, ErrHasPosts, s.Users.DeleteById(ctx, TombstoneUser.Id, AnyVersion, OnPostsReassign), name)
+
+		//Users without posts are deleted whatever the policy
+		user = model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: name + "@abc.com", Version: 1}
+		assert.Nil(t, s.Users.Insert(ctx, user), name)
+		assert.Nil(t, s.Users.DeleteById(ctx, user.Id, AnyVersion, OnPostsReject), name)
+	}
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"strings"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+)
+
+// Sortable blogPost fields, named like their json properties.
+const (
+	SortById               = "id"
+	SortByUserId           = "userId"
+	SortByTopic            = "topic"
+	SortByLastModifiedDate = "lastModifiedDate"
+)
+
+// PostSortFields lists the fields blogPosts can be sorted by.
+var PostSortFields = []string{SortById, SortByUserId, SortByTopic, SortByLastModifiedDate}
+
+// SortField orders search results by one field.
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// PostFilter holds the search criteria for blogPosts. Every criterion is ignored when empty.
+type PostFilter struct {
+	// UserIds matches posts owned by any of these users.
+	UserIds []string
+	// Topic matches the topic exactly.
+	Topic string
+	// TopicPrefix matches topics starting with this prefix.
+	TopicPrefix string
+	// Content matches posts whose content contains this substring.
+	Content string
+	// Since matches posts last modified at or after this time.
+	Since time.Time
+	// Until matches posts last modified before this time.
+	Until time.Time
+	// Sort orders the results, ties and an empty Sort are ordered by ascending id.
+	Sort []SortField
+	// PageSize limits the number of records returned, 0 means no limit.
+	PageSize int64
+	// After resumes the search after this post in Sort order. Only its sort fields and id are used.
+	After *model.BlogPost
+}
+
+// SortKey returns Sort with the ascending id tiebreaker appended, so that the order is total.
+func (f PostFilter) SortKey() []SortField {
+	key := make([]SortField, 0, len(f.Sort)+1)
+	for _, field := range f.Sort {
+		if field.Field == SortById {
+			return append(key, field)
+		}
+		key = append(key, field)
+	}
+	return append(key, SortField{Field: SortById})
+}
+
+// Matches reports whether the post satisfies the criteria of the filter, ignoring the paging.
+func (f PostFilter) Matches(post model.BlogPost) bool {
+	if len(f.UserIds) > 0 && !containsString(f.UserIds, post.UserId) {
+		return false
+	}
+	if f.Topic != "" && post.Topic != f.Topic {
+		return false
+	}
+	if f.TopicPrefix != "" && !strings.HasPrefix(post.Topic, f.TopicPrefix) {
+		return false
+	}
+	if f.Content != "" && !strings.Contains(post.Content, f.Content) {
+		return false
+	}
+	if !f.Since.IsZero() && post.LastModifiedDate.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !post.LastModifiedDate.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// ComparePosts orders a and b by the given sort key, returning -1, 0 or 1.
+func ComparePosts(a, b model.BlogPost, key []SortField) int {
+	for _, field := range key {
+		var cmp int
+		switch field.Field {
+		case SortById:
+			cmp = strings.Compare(a.Id, b.Id)
+		case SortByUserId:
+			cmp = strings.Compare(a.UserId, b.UserId)
+		case SortByTopic:
+			cmp = strings.Compare(a.Topic, b.Topic)
+		case SortByLastModifiedDate:
+			cmp = compareTime(a.LastModifiedDate, b.LastModifiedDate)
+		}
+		if field.Descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
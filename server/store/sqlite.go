@@ -0,0 +1,678 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	model "github.com/gouthams/blogApp/server/model"
+	"github.com/gouthams/blogApp/server/utils"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqlTimeLayout is a fixed width UTC layout so that the stored dates sort lexically
+const sqlTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// OpenSqlite opens the SQLite database at the given path, applies the pending migrations
+// and returns a Store backed by it. Use ":memory:" for a throwaway database.
+func OpenSqlite(path string) (Store, error) {
+	logEntry := utils.Log()
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return Store{}, err
+	}
+	// SQLite serializes the writers anyway, a single connection also keeps ":memory:" databases alive
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return Store{}, err
+	}
+	logEntry.Infof("Opened sqlite db: %s", path)
+
+	return Store{
+		Users:         &sqlUserRepository{db: db},
+		Posts:         &sqlPostRepository{db: db},
+		ApiKeys:       &sqlApiKeyRepository{db: db},
+		RefreshTokens: &sqlRefreshTokenRepository{db: db},
+		close:         db.Close,
+		ping:          db.PingContext,
+		checkSchema: func(ctx context.Context) error {
+			version, err := SchemaVersion(ctx, db)
+			if err != nil {
+				return err
+			}
+			if version != LatestSchemaVersion() {
+				return fmt.Errorf("schema version is %d, expected %d", version, LatestSchemaVersion())
+			}
+			return nil
+		},
+	}, nil
+}
+
+type sqlUserRepository struct {
+	db *sql.DB
+}
+
+func (r *sqlUserRepository) Insert(ctx context.Context, user model.BlogUser) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO blog_user (id, name, email, last_modified_date, version, password_hash, role, oidc_linked,
+			token_generation) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.Id, user.Name, user.Email, formatSqlTime(user.LastModifiedDate), user.Version, user.PasswordHash, user.Role,
+		user.OidcLinked, user.TokenGeneration)
+	return sqlError(err)
+}
+
+func (r *sqlUserRepository) GetById(ctx context.Context, id string) (model.BlogUser, error) {
+	return r.queryOne(ctx, `WHERE id = ? AND deleted_at IS NULL`, id)
+}
+
+func (r *sqlUserRepository) GetByEmail(ctx context.Context, email string) (model.BlogUser, error) {
+	return r.queryOne(ctx, `WHERE email = ? AND deleted_at IS NULL`, email)
+}
+
+func (r *sqlUserRepository) queryOne(ctx context.Context, where string, args ...interface{}) (model.BlogUser, error) {
+	users, err := r.query(ctx, where+` LIMIT 1`, args...)
+	if err != nil {
+		return model.BlogUser{}, err
+	}
+	if len(users) == 0 {
+		return model.BlogUser{}, ErrNotFound
+	}
+	return users[0], nil
+}
+
+func (r *sqlUserRepository) Search(ctx context.Context, filter UserFilter) ([]model.BlogUser, error) {
+	where := []string{`deleted_at IS NULL`}
+	var args []interface{}
+	if filter.Name != "" {
+		where = append(where, `name = ?`)
+		args = append(args, filter.Name)
+	}
+	if filter.After != "" {
+		where = append(where, `id > ?`)
+		args = append(args, filter.After)
+	}
+	return r.query(ctx, whereClause(where)+` ORDER BY id`+limitClause(filter.PageSize), args...)
+}
+
+func (r *sqlUserRepository) query(ctx context.Context, clause string, args ...interface{}) ([]model.BlogUser, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, name, email, last_modified_date, version, deleted_at, password_hash, role, oidc_linked,
+			token_generation FROM blog_user `+clause,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.BlogUser{}
+	for rows.Next() {
+		var user model.BlogUser
+		var lastModified string
+		var deletedAt sql.NullString
+		err := rows.Scan(&user.Id, &user.Name, &user.Email, &lastModified, &user.Version, &deletedAt,
+			&user.PasswordHash, &user.Role, &user.OidcLinked, &user.TokenGeneration)
+		if err != nil {
+			return nil, err
+		}
+		user.LastModifiedDate = parseSqlTime(lastModified)
+		user.DeletedAt = parseNullSqlTime(deletedAt)
+		res = append(res, user)
+	}
+	return res, rows.Err()
+}
+
+func (r *sqlUserRepository) Replace(ctx context.Context, user model.BlogUser, version int64) error {
+	return execOne(ctx, r.db, "blog_user", user.Id, version,
+		`UPDATE blog_user SET name = ?, email = ?, last_modified_date = ?, password_hash = COALESCE(NULLIF(?, ''), password_hash),
+			role = COALESCE(NULLIF(?, ''), role), oidc_linked = (email = ? AND MAX(oidc_linked, ?)),
+			token_generation = token_generation + (email != ? OR (? != '' AND ? != password_hash)),
+			version = version + 1 WHERE id = ? AND deleted_at IS NULL`,
+		user.Name, user.Email, formatSqlTime(user.LastModifiedDate), user.PasswordHash, user.Role, user.Email,
+		user.OidcLinked, user.Email, user.PasswordHash, user.PasswordHash, user.Id)
+}
+
+func (r *sqlUserRepository) DeleteById(ctx context.Context, id string, version int64, onPosts OnPosts) error {
+	return withTx(ctx, r.db, func(tx *sql.Tx) error {
+		//Check the user first, the posts must be left alone when it does not match
+		var stored int64
+		err := tx.QueryRowContext(ctx, `SELECT version FROM blog_user WHERE id = ? AND deleted_at IS NULL`, id).Scan(&stored)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !versionMatches(stored, version) {
+			return ErrVersionMismatch
+		}
+
+		now := formatSqlTime(time.Now().UTC())
+		switch effectiveOnPosts(id, onPosts) {
+		case OnPostsCascade:
+			_, err = tx.ExecContext(ctx,
+				`UPDATE blog_post SET deleted_at = ?, version = version + 1 WHERE user_id = ? AND deleted_at IS NULL`, now, id)
+		case OnPostsReassign:
+			tombstone := newTombstoneUser()
+			_, err = tx.ExecContext(ctx,
+				`INSERT OR IGNORE INTO blog_user (id, name, email, last_modified_date, version) VALUES (?, ?, ?, ?, ?)`,
+				tombstone.Id, tombstone.Name, tombstone.Email, formatSqlTime(tombstone.LastModifiedDate), tombstone.Version)
+			if err == nil {
+				_, err = tx.ExecContext(ctx,
+					`UPDATE blog_post SET user_id = ?, last_modified_date = ?, version = version + 1 WHERE user_id = ?`,
+					tombstone.Id, formatSqlTime(tombstone.LastModifiedDate), id)
+			}
+		default:
+			var count int
+			err = tx.QueryRowContext(ctx,
+				`SELECT COUNT(*) FROM blog_post WHERE user_id = ? AND deleted_at IS NULL`, id).Scan(&count)
+			if err == nil && count > 0 {
+				err = ErrHasPosts
+			}
+		}
+		if err != nil {
+			return sqlError(err)
+		}
+		return execOne(ctx, tx, "blog_user", id, AnyVersion,
+			`UPDATE blog_user SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`, now, id)
+	})
+}
+
+func (r *sqlUserRepository) Trash(ctx context.Context) ([]model.BlogUser, error) {
+	return r.query(ctx, `WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id`)
+}
+
+func (r *sqlUserRepository) Restore(ctx context.Context, id string) error {
+	return withTx(ctx, r.db, func(tx *sql.Tx) error {
+		var deletedAt string
+		err := tx.QueryRowContext(ctx,
+			`SELECT deleted_at FROM blog_user WHERE id = ? AND deleted_at IS NOT NULL`, id).Scan(&deletedAt)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		//The posts trashed along with the user share its deletion time
+		_, err = tx.ExecContext(ctx,
+			`UPDATE blog_post SET deleted_at = NULL, version = version + 1 WHERE user_id = ? AND deleted_at = ?`, id, deletedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE blog_user SET deleted_at = NULL, version = version + 1 WHERE id = ?`, id)
+		return err
+	})
+}
+
+func (r *sqlUserRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM blog_post WHERE user_id IN (SELECT id FROM blog_user WHERE deleted_at < ?)`, formatSqlTime(before))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM api_key WHERE user_id IN (SELECT id FROM blog_user WHERE deleted_at < ?)`, formatSqlTime(before))
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM blog_user WHERE deleted_at < ?`, formatSqlTime(before))
+		if err != nil {
+			return err
+		}
+		count, err = res.RowsAffected()
+		return err
+	})
+	return count, err
+}
+
+type sqlPostRepository struct {
+	db *sql.DB
+}
+
+func (r *sqlPostRepository) Insert(ctx context.Context, post model.BlogPost) error {
+	return withTx(ctx, r.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO blog_post (id, user_id, topic, content, last_modified_date, version) VALUES (?, ?, ?, ?, ?, ?)`,
+			post.Id, post.UserId, post.Topic, post.Content, formatSqlTime(post.LastModifiedDate), post.Version)
+		if err != nil {
+			return sqlError(err)
+		}
+		return indexPostTerms(ctx, tx, post)
+	})
+}
+
+func (r *sqlPostRepository) GetById(ctx context.Context, id string) (model.BlogPost, error) {
+	posts, err := r.query(ctx, `WHERE id = ? AND deleted_at IS NULL LIMIT 1`, id)
+	if err != nil {
+		return model.BlogPost{}, err
+	}
+	if len(posts) == 0 {
+		return model.BlogPost{}, ErrNotFound
+	}
+	return posts[0], nil
+}
+
+func (r *sqlPostRepository) Search(ctx context.Context, filter PostFilter) ([]model.BlogPost, error) {
+	where := []string{`deleted_at IS NULL`}
+	var args []interface{}
+	if len(filter.UserIds) > 0 {
+		where = append(where, `user_id IN (?`+strings.Repeat(`, ?`, len(filter.UserIds)-1)+`)`)
+		for _, userId := range filter.UserIds {
+			args = append(args, userId)
+		}
+	}
+	if filter.Topic != "" {
+		where = append(where, `topic = ?`)
+		args = append(args, filter.Topic)
+	}
+	if filter.TopicPrefix != "" {
+		where = append(where, `substr(topic, 1, ?) = ?`)
+		args = append(args, utf8.RuneCountInString(filter.TopicPrefix), filter.TopicPrefix)
+	}
+	if filter.Content != "" {
+		where = append(where, `instr(content, ?) > 0`)
+		args = append(args, filter.Content)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, `last_modified_date >= ?`)
+		args = append(args, formatSqlTime(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, `last_modified_date < ?`)
+		args = append(args, formatSqlTime(filter.Until))
+	}
+
+	key := filter.SortKey()
+	if filter.After != nil {
+		condition, afterArgs := sqlAfter(*filter.After, key)
+		where = append(where, condition)
+		args = append(args, afterArgs...)
+	}
+
+	var orderBy []string
+	for _, field := range key {
+		column := sqlPostColumns[field.Field]
+		if field.Descending {
+			column += ` DESC`
+		}
+		orderBy = append(orderBy, column)
+	}
+	return r.query(ctx, whereClause(where)+` ORDER BY `+strings.Join(orderBy, `, `)+limitClause(filter.PageSize), args...)
+}
+
+// sqlPostColumns maps the sortable post fields to their columns
+var sqlPostColumns = map[string]string{
+	SortById:               "id",
+	SortByUserId:           "user_id",
+	SortByTopic:            "topic",
+	SortByLastModifiedDate: "last_modified_date",
+}
+
+// Helper function to match the posts following the cursor in sort order:
+// (k0 > v0) or (k0 = v0 and k1 > v1) or ... with the comparison flipped for descending fields
+func sqlAfter(after model.BlogPost, key []SortField) (string, []interface{}) {
+	values := map[string]interface{}{
+		SortById:               after.Id,
+		SortByUserId:           after.UserId,
+		SortByTopic:            after.Topic,
+		SortByLastModifiedDate: formatSqlTime(after.LastModifiedDate),
+	}
+	var or []string
+	var args []interface{}
+	for i, field := range key {
+		var and []string
+		for _, previous := range key[:i] {
+			and = append(and, sqlPostColumns[previous.Field]+` = ?`)
+			args = append(args, values[previous.Field])
+		}
+		op := ` > ?`
+		if field.Descending {
+			op = ` < ?`
+		}
+		and = append(and, sqlPostColumns[field.Field]+op)
+		args = append(args, values[field.Field])
+		or = append(or, `(`+strings.Join(and, ` AND `)+`)`)
+	}
+	return `(` + strings.Join(or, ` OR `) + `)`, args
+}
+
+func (r *sqlPostRepository) query(ctx context.Context, clause string, args ...interface{}) ([]model.BlogPost, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, user_id, topic, content, last_modified_date, version, deleted_at FROM blog_post `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.BlogPost{}
+	for rows.Next() {
+		var post model.BlogPost
+		var lastModified string
+		var deletedAt sql.NullString
+		if err := rows.Scan(&post.Id, &post.UserId, &post.Topic, &post.Content, &lastModified, &post.Version,
+			&deletedAt); err != nil {
+			return nil, err
+		}
+		post.LastModifiedDate = parseSqlTime(lastModified)
+		post.DeletedAt = parseNullSqlTime(deletedAt)
+		res = append(res, post)
+	}
+	return res, rows.Err()
+}
+
+func (r *sqlPostRepository) Replace(ctx context.Context, post model.BlogPost, version int64) error {
+	return withTx(ctx, r.db, func(tx *sql.Tx) error {
+		err := execOne(ctx, tx, "blog_post", post.Id, version,
+			`UPDATE blog_post SET user_id = ?, topic = ?, content = ?, last_modified_date = ?, version = version + 1
+				WHERE id = ? AND deleted_at IS NULL`,
+			post.UserId, post.Topic, post.Content, formatSqlTime(post.LastModifiedDate), post.Id)
+		if err != nil {
+			return err
+		}
+		return indexPostTerms(ctx, tx, post)
+	})
+}
+
+func (r *sqlPostRepository) DeleteById(ctx context.Context, id string, version int64) error {
+	return execOne(ctx, r.db, "blog_post", id, version,
+		`UPDATE blog_post SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`,
+		formatSqlTime(time.Now().UTC()), id)
+}
+
+func (r *sqlPostRepository) Trash(ctx context.Context) ([]model.BlogPost, error) {
+	return r.query(ctx, `WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id`)
+}
+
+func (r *sqlPostRepository) Restore(ctx context.Context, id string) error {
+	return withTx(ctx, r.db, func(tx *sql.Tx) error {
+		var userDeleted bool
+		err := tx.QueryRowContext(ctx, `SELECT blog_user.deleted_at IS NOT NULL FROM blog_post
+			JOIN blog_user ON blog_user.id = blog_post.user_id
+			WHERE blog_post.id = ? AND blog_post.deleted_at IS NOT NULL`, id).Scan(&userDeleted)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if userDeleted {
+			return ErrOwnerDeleted
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE blog_post SET deleted_at = NULL, version = version + 1 WHERE id = ?`, id)
+		return err
+	})
+}
+
+func (r *sqlPostRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM blog_post WHERE deleted_at < ?`, formatSqlTime(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *sqlPostRepository) TextSearch(ctx context.Context, query TextQuery) ([]TextHit, error) {
+	//Every match contains at least one of the query words, so only their postings need scoring
+	words := query.Words()
+	args := make([]interface{}, 0, len(words))
+	for _, word := range words {
+		args = append(args, word)
+	}
+	candidates, err := r.query(ctx,
+		`WHERE deleted_at IS NULL AND id IN (SELECT post_id FROM blog_post_term WHERE term IN (?`+
+			strings.Repeat(`, ?`, len(words)-1)+`))`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []TextHit{}
+	for _, post := range candidates {
+		score, ok := query.Score(post)
+		if !ok {
+			continue
+		}
+		hit := TextHit{Post: post, Score: score}
+		if query.After != nil && CompareHits(hit, *query.After) <= 0 {
+			continue
+		}
+		res = append(res, hit)
+	}
+	sort.Slice(res, func(i, j int) bool { return CompareHits(res[i], res[j]) < 0 })
+	if query.PageSize > 0 && int64(len(res)) > query.PageSize {
+		res = res[:query.PageSize]
+	}
+	return res, nil
+}
+
+// indexPostTerms replaces the inverted index entries of the post by its current words
+func indexPostTerms(ctx context.Context, conn sqlConn, post model.BlogPost) error {
+	if _, err := conn.ExecContext(ctx, `DELETE FROM blog_post_term WHERE post_id = ?`, post.Id); err != nil {
+		return err
+	}
+	for _, word := range postWords(post) {
+		_, err := conn.ExecContext(ctx, `INSERT INTO blog_post_term (term, post_id) VALUES (?, ?)`, word, post.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sqlApiKeyRepository struct {
+	db *sql.DB
+}
+
+func (r *sqlApiKeyRepository) Insert(ctx context.Context, key model.ApiKey) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO api_key (id, user_id, name, prefix, key_hash, scopes, expires_at, created_at, last_used_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.Id, key.UserId, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " "),
+		formatNullSqlTime(key.ExpiresAt), formatSqlTime(key.CreatedAt), formatNullSqlTime(key.LastUsedAt))
+	return sqlError(err)
+}
+
+func (r *sqlApiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (model.ApiKey, error) {
+	keys, err := r.query(ctx, `WHERE prefix = ? LIMIT 1`, prefix)
+	if err != nil {
+		return model.ApiKey{}, err
+	}
+	if len(keys) == 0 {
+		return model.ApiKey{}, ErrNotFound
+	}
+	return keys[0], nil
+}
+
+func (r *sqlApiKeyRepository) ListByUser(ctx context.Context, userId string) ([]model.ApiKey, error) {
+	return r.query(ctx, `WHERE user_id = ? ORDER BY created_at, id`, userId)
+}
+
+func (r *sqlApiKeyRepository) query(ctx context.Context, clause string, args ...interface{}) ([]model.ApiKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, created_at, last_used_at FROM api_key `+clause,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.ApiKey{}
+	for rows.Next() {
+		var key model.ApiKey
+		var scopes, createdAt string
+		var expiresAt, lastUsedAt sql.NullString
+		err := rows.Scan(&key.Id, &key.UserId, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &expiresAt, &createdAt,
+			&lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		key.Scopes = strings.Fields(scopes)
+		key.ExpiresAt = parseNullSqlTime(expiresAt)
+		key.CreatedAt = parseSqlTime(createdAt)
+		key.LastUsedAt = parseNullSqlTime(lastUsedAt)
+		res = append(res, key)
+	}
+	return res, rows.Err()
+}
+
+func (r *sqlApiKeyRepository) Delete(ctx context.Context, userId string, id string) error {
+	return affectsOne(r.db.ExecContext(ctx, `DELETE FROM api_key WHERE id = ? AND user_id = ?`, id, userId))
+}
+
+func (r *sqlApiKeyRepository) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	return affectsOne(r.db.ExecContext(ctx, `UPDATE api_key SET last_used_at = ? WHERE id = ?`, formatSqlTime(usedAt),
+		id))
+}
+
+type sqlRefreshTokenRepository struct {
+	db *sql.DB
+}
+
+func (r *sqlRefreshTokenRepository) Redeem(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO refresh_token (id, expires_at) VALUES (?, ?)`, id,
+		formatSqlTime(expiresAt))
+	return sqlError(err)
+}
+
+func (r *sqlRefreshTokenRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM refresh_token WHERE expires_at < ?`, formatSqlTime(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Helper function to map a statement that changed no row to ErrNotFound
+func affectsOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Helper function to run an update or delete statement that is expected to change exactly one row.
+// The statement must end with its WHERE clause so that the version condition can be appended.
+func execOne(ctx context.Context, db sqlConn, table string, id string, version int64,
+	statement string, args ...interface{}) error {
+	if version != AnyVersion {
+		statement += ` AND version = ?`
+		args = append(args, version)
+	}
+	res, err := db.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return sqlError(err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	//Tell apart a missing row from a version mismatch
+	var exists int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE id = ? AND deleted_at IS NULL`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionMismatch
+}
+
+// sqlConn is implemented by both *sql.DB and *sql.Tx
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// withTx runs fn in a transaction, committed when fn succeeds and rolled back otherwise
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return `WHERE ` + strings.Join(conditions, ` AND `)
+}
+
+func limitClause(pageSize int64) string {
+	if pageSize <= 0 {
+		return ""
+	}
+	return ` LIMIT ` + strconv.FormatInt(pageSize, 10)
+}
+
+func formatSqlTime(t time.Time) string {
+	return t.UTC().Format(sqlTimeLayout)
+}
+
+func parseSqlTime(value string) time.Time {
+	t, err := time.Parse(sqlTimeLayout, value)
+	if err != nil {
+		utils.Log().Errorf("Unable to parse stored time %s: %v", value, err)
+	}
+	return t
+}
+
+func formatNullSqlTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatSqlTime(*t), Valid: true}
+}
+
+func parseNullSqlTime(value sql.NullString) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t := parseSqlTime(value.String)
+	return &t
+}
+
+// sqlError maps the unique constraint violations to ErrDuplicate
+func sqlError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return ErrDuplicate
+		}
+	}
+	return err
+}
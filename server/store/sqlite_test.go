@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqliteMigrationsAreIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blog.sqlite")
+
+	s, err := OpenSqlite(path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	// Reopening the same file must not re-apply the migrations
+	s, err = OpenSqlite(path)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	db := s.Users.(*sqlUserRepository).db
+	version, err := SchemaVersion(context.Background(), db)
+	assert.Nil(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+}
+
+func TestSqliteConstraints(t *testing.T) {
+	s, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer s.Close()
+	ctx := context.Background()
+
+	user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: "david@abc.com",
+		LastModifiedDate: time.Now().UTC()}
+	assert.Nil(t, s.Users.Insert(ctx, user))
+
+	dup := model.BlogUser{Id: uuid.NewV4().String(), Name: "Dave", Email: "david@abc.com"}
+	assert.Equal(t, ErrDuplicate, s.Users.Insert(ctx, dup))
+
+	stored, err := s.Users.GetById(ctx, user.Id)
+	assert.Nil(t, err)
+	assert.True(t, user.LastModifiedDate.Equal(stored.LastModifiedDate))
+
+	// Posts must reference an existing user
+	orphan := model.BlogPost{Id: uuid.NewV4().String(), UserId: uuid.NewV4().String(), Topic: "Topic", Content: "Content"}
+	assert.NotNil(t, s.Posts.Insert(ctx, orphan))
+
+	post := model.BlogPost{Id: uuid.NewV4().String(), UserId: user.Id, Topic: "Topic", Content: "Content"}
+	assert.Nil(t, s.Posts.Insert(ctx, post))
+
+	posts, err := s.Posts.Search(ctx, PostFilter{UserIds: []string{user.Id}, PageSize: 10})
+	assert.Nil(t, err)
+	assert.Len(t, posts, 1)
+}
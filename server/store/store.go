@@ -0,0 +1,180 @@
+/*
+ * Persistence layer for the blogging APIs
+ */
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+)
+
+// ErrNotFound is returned when no document matches the given id or filter.
+var ErrNotFound = errors.New("document not found")
+
+// ErrDuplicate is returned when a document violates a unique constraint, like the user email.
+var ErrDuplicate = errors.New("duplicate document")
+
+// ErrVersionMismatch is returned when a conditional write does not match the stored version.
+var ErrVersionMismatch = errors.New("document version mismatch")
+
+// ErrOwnerDeleted is returned when restoring a post whose user is in the trash.
+var ErrOwnerDeleted = errors.New("owner of the document is deleted")
+
+// ErrUnavailable is returned when the database can not be reached, the operation may succeed later.
+var ErrUnavailable = errors.New("database unavailable")
+
+// AnyVersion makes Replace and DeleteById unconditional.
+const AnyVersion int64 = 0
+
+// UserFilter holds the search criteria for blogUsers.
+type UserFilter struct {
+	// Name matches the user name exactly, ignored when empty.
+	Name string
+	// PageSize limits the number of records returned, 0 means no limit.
+	PageSize int64
+	// After resumes the search after the user with this id, ignored when empty.
+	After string
+}
+
+// UserRepository persists blogUser documents. Deleted users go to the trash, where they are hidden
+// from every method but Trash, Restore and Purge. Their email stays taken until they are purged.
+type UserRepository interface {
+	// Insert stores a new user or returns ErrDuplicate if the email is taken.
+	Insert(ctx context.Context, user model.BlogUser) error
+	// GetById returns the user with the given id or ErrNotFound.
+	GetById(ctx context.Context, id string) (model.BlogUser, error)
+	// GetByEmail returns the user with the given email or ErrNotFound.
+	GetByEmail(ctx context.Context, email string) (model.BlogUser, error)
+	// Search returns the users matching the filter ordered by id.
+	Search(ctx context.Context, filter UserFilter) ([]model.BlogUser, error)
+	// Replace atomically overwrites the user with the same id and increments its version, the stored
+	// password hash and role are kept when the user has none and a linked user stays OidcLinked until its email
+	// changes. A new password hash or email increments the TokenGeneration. It returns
+	// ErrNotFound if there is no such user, ErrVersionMismatch if the stored version is not the expected one and
+	// ErrDuplicate if the new email is taken.
+	Replace(ctx context.Context, user model.BlogUser, version int64) error
+	// DeleteById atomically moves the user with the given id and version to the trash, and applies
+	// onPosts to the posts of the user, cascaded posts are trashed along with the user.
+	// It returns ErrNotFound if there is no such user, ErrVersionMismatch if the stored version
+	// differs and ErrHasPosts if onPosts rejects deleting a user with posts.
+	DeleteById(ctx context.Context, id string, version int64, onPosts OnPosts) error
+	// Trash returns the trashed users, most recently deleted first.
+	Trash(ctx context.Context) ([]model.BlogUser, error)
+	// Restore takes the user with the given id out of the trash, along with the posts trashed with it.
+	// It returns ErrNotFound if there is no such user in the trash.
+	Restore(ctx context.Context, id string) error
+	// Purge permanently removes the users trashed before the given time along with all their posts,
+	// and returns the number of users removed.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// PostRepository persists blogPost documents. Deleted posts go to the trash, where they are hidden
+// from every method but Trash, Restore and Purge.
+type PostRepository interface {
+	// Insert stores a new post.
+	Insert(ctx context.Context, post model.BlogPost) error
+	// GetById returns the post with the given id or ErrNotFound.
+	GetById(ctx context.Context, id string) (model.BlogPost, error)
+	// Search returns the posts matching the filter in the order of filter.Sort.
+	Search(ctx context.Context, filter PostFilter) ([]model.BlogPost, error)
+	// TextSearch returns the posts matching the full-text query, most relevant first.
+	TextSearch(ctx context.Context, query TextQuery) ([]TextHit, error)
+	// Replace atomically overwrites the post with the same id and increments its version.
+	// It returns ErrNotFound if there is no such post and ErrVersionMismatch if the stored
+	// version is not the expected one.
+	Replace(ctx context.Context, post model.BlogPost, version int64) error
+	// DeleteById moves the post with the given id and version to the trash. It returns ErrNotFound
+	// if there is no such post and ErrVersionMismatch if the stored version differs.
+	DeleteById(ctx context.Context, id string, version int64) error
+	// Trash returns the trashed posts, most recently deleted first.
+	Trash(ctx context.Context) ([]model.BlogPost, error)
+	// Restore takes the post with the given id out of the trash. It returns ErrNotFound if there
+	// is no such post in the trash and ErrOwnerDeleted if the user of the post is in the trash.
+	Restore(ctx context.Context, id string) error
+	// Purge permanently removes the posts trashed before the given time and returns their number.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// ApiKeyRepository persists the api keys of the blogUsers. The keys of a user are removed when it is purged.
+type ApiKeyRepository interface {
+	// Insert stores a new key or returns ErrDuplicate if its prefix is taken.
+	Insert(ctx context.Context, key model.ApiKey) error
+	// GetByPrefix returns the key with the given prefix or ErrNotFound.
+	GetByPrefix(ctx context.Context, prefix string) (model.ApiKey, error)
+	// ListByUser returns the keys of the user, oldest first.
+	ListByUser(ctx context.Context, userId string) ([]model.ApiKey, error)
+	// Delete removes the key with the given id of the user. It returns ErrNotFound if the user has no such key.
+	Delete(ctx context.Context, userId string, id string) error
+	// Touch records that the key with the given id was used at the given time. It returns ErrNotFound if there
+	// is no such key.
+	Touch(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// RefreshTokenRepository records the refresh tokens exchanged for new tokens, so that each one is used once.
+type RefreshTokenRepository interface {
+	// Redeem records the refresh token with the given id, valid until the given time, as used. It returns
+	// ErrDuplicate if it was used already.
+	Redeem(ctx context.Context, id string, expiresAt time.Time) error
+	// Purge removes the refresh tokens expired before the given time and returns their number.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Store bundles the repositories used by the api handlers.
+type Store struct {
+	Users         UserRepository
+	Posts         PostRepository
+	ApiKeys       ApiKeyRepository
+	RefreshTokens RefreshTokenRepository
+
+	// close releases the underlying database, nil when there is nothing to release
+	close func() error
+	// health reports the state of the database connection, nil when it is always up
+	health func() Health
+	// ping checks that the database is reachable, nil when it always is
+	ping func(ctx context.Context) error
+	// checkSchema checks that the database is migrated, nil when there is nothing to migrate
+	checkSchema func(ctx context.Context) error
+}
+
+// Close releases the resources held by the store.
+func (s Store) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// Config selects and configures the persistence backend.
+type Config struct {
+	// Type is one of mongo, memory or sqlite.
+	Type string `yaml:"type"`
+	// SqlitePath is the database file of the sqlite store.
+	SqlitePath string `yaml:"sqlitePath"`
+	// Mongo configures the connection of the mongo store.
+	Mongo MongoConfig `yaml:"mongo"`
+}
+
+// Open returns the Store described by the given configuration.
+func Open(config Config) (Store, error) {
+	switch config.Type {
+	case "mongo":
+		return OpenMongo(config.Mongo)
+	case "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return OpenSqlite(config.SqlitePath)
+	default:
+		return Store{}, fmt.Errorf("unknown store type: %s", config.Type)
+	}
+}
+
+// Helper function to tell whether replacing the stored user changes its credentials, that is its email or its
+// password hash
+func credentialsChanged(current model.BlogUser, user model.BlogUser) bool {
+	return user.Email != current.Email || (user.PasswordHash != "" && user.PasswordHash != current.PasswordHash)
+}
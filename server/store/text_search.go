@@ -0,0 +1,229 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	model "github.com/gouthams/blogApp/server/model"
+)
+
+// ErrEmptyTextQuery is returned when a full-text search has no word to look for.
+var ErrEmptyTextQuery = errors.New("text query must contain at least one word or phrase that is not negated")
+
+// Relative weights of the indexed blogPost fields, a topic match ranks above a content match.
+const (
+	topicTextWeight   = 3
+	contentTextWeight = 1
+)
+
+// TextQuery is a parsed full-text search string. It follows the MongoDB $text syntax:
+// words match any of them, every "quoted phrase" must be present and
+// a word or phrase prefixed with - excludes the posts containing it.
+type TextQuery struct {
+	// Raw is the search string as given by the client.
+	Raw string
+	// Terms are the lower cased words of which at least one must match.
+	Terms []string
+	// Phrases are word sequences that must all match.
+	Phrases [][]string
+	// ExcludedTerms and ExcludedPhrases must not match.
+	ExcludedTerms   []string
+	ExcludedPhrases [][]string
+	// PageSize limits the number of hits returned, 0 means no limit.
+	PageSize int64
+	// After resumes the search after this hit in ranking order.
+	After *TextHit
+}
+
+// TextHit is a post matching a TextQuery with its relevance score.
+type TextHit struct {
+	Post  model.BlogPost
+	Score float64
+}
+
+// ParseTextQuery parses a search string, it returns ErrEmptyTextQuery when nothing would match.
+func ParseTextQuery(raw string) (TextQuery, error) {
+	query := TextQuery{Raw: raw}
+	rest := raw
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+		negated := strings.HasPrefix(rest, "-")
+		if negated {
+			rest = rest[1:]
+		}
+
+		if strings.HasPrefix(rest, `"`) {
+			var phrase string
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				//An unterminated phrase runs until the end of the search string
+				phrase, rest = rest[1:], ""
+			} else {
+				phrase, rest = rest[1:end+1], rest[end+2:]
+			}
+			words := Tokenize(phrase)
+			switch {
+			case len(words) == 0:
+			case negated:
+				query.ExcludedPhrases = append(query.ExcludedPhrases, words)
+			default:
+				query.Phrases = append(query.Phrases, words)
+			}
+			continue
+		}
+
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		words := Tokenize(rest[:end])
+		rest = rest[end:]
+		if negated {
+			query.ExcludedTerms = appendMissing(query.ExcludedTerms, words...)
+		} else {
+			query.Terms = appendMissing(query.Terms, words...)
+		}
+	}
+
+	if len(query.Terms) == 0 && len(query.Phrases) == 0 {
+		return query, ErrEmptyTextQuery
+	}
+	return query, nil
+}
+
+// Words returns the distinct words of the terms and phrases, the ones a matching post contains.
+func (q TextQuery) Words() []string {
+	words := append([]string(nil), q.Terms...)
+	for _, phrase := range q.Phrases {
+		words = appendMissing(words, phrase...)
+	}
+	return words
+}
+
+// Score returns the relevance of the post for the query and whether it matches at all.
+func (q TextQuery) Score(post model.BlogPost) (float64, bool) {
+	topic := Tokenize(post.Topic)
+	content := Tokenize(post.Content)
+
+	for _, phrase := range q.Phrases {
+		if !containsPhrase(topic, phrase) && !containsPhrase(content, phrase) {
+			return 0, false
+		}
+	}
+	for _, phrase := range q.ExcludedPhrases {
+		if containsPhrase(topic, phrase) || containsPhrase(content, phrase) {
+			return 0, false
+		}
+	}
+	for _, term := range q.ExcludedTerms {
+		if containsString(topic, term) || containsString(content, term) {
+			return 0, false
+		}
+	}
+
+	//Words are visited in query order so that the floating point sum is deterministic
+	var score float64
+	matched := len(q.Terms) == 0
+	for _, word := range q.Words() {
+		topicCount, contentCount := countString(topic, word), countString(content, word)
+		if topicCount+contentCount > 0 && containsString(q.Terms, word) {
+			matched = true
+		}
+		score += fieldScore(topicTextWeight, topicCount, len(topic))
+		score += fieldScore(contentTextWeight, contentCount, len(content))
+	}
+	return score, matched
+}
+
+// CompareHits orders hits by descending score then ascending post id, returning -1, 0 or 1.
+func CompareHits(a, b TextHit) int {
+	switch {
+	case a.Score > b.Score:
+		return -1
+	case a.Score < b.Score:
+		return 1
+	default:
+		return strings.Compare(a.Post.Id, b.Post.Id)
+	}
+}
+
+// Tokenize splits text into lower cased words of letters and digits.
+func Tokenize(text string) []string {
+	var words []string
+	for _, span := range WordSpans(text) {
+		words = append(words, strings.ToLower(text[span[0]:span[1]]))
+	}
+	return words
+}
+
+// WordSpans returns the byte offsets [start, end) of the words of text, as split by Tokenize.
+func WordSpans(text string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// postWords returns the distinct words of the indexed fields of the post
+func postWords(post model.BlogPost) []string {
+	return appendMissing(nil, append(Tokenize(post.Topic), Tokenize(post.Content)...)...)
+}
+
+// Helper function to weigh the occurrences of a word by the length of the field it occurs in
+func fieldScore(weight float64, count int, length int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return weight * float64(count) / float64(length)
+}
+
+func containsPhrase(words []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j, word := range phrase {
+			if words[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func countString(values []string, value string) int {
+	count := 0
+	for _, v := range values {
+		if v == value {
+			count++
+		}
+	}
+	return count
+}
+
+func appendMissing(values []string, candidates ...string) []string {
+	for _, candidate := range candidates {
+		if !containsString(values, candidate) {
+			values = append(values, candidate)
+		}
+	}
+	return values
+}
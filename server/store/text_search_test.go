@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	model "github.com/gouthams/blogApp/server/model"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTextQuery(t *testing.T) {
+	query, err := ParseTextQuery(`Go  "error handling" -java -"checked exceptions" co-op`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"go", "co", "op"}, query.Terms)
+	assert.Equal(t, [][]string{{"error", "handling"}}, query.Phrases)
+	assert.Equal(t, []string{"java"}, query.ExcludedTerms)
+	assert.Equal(t, [][]string{{"checked", "exceptions"}}, query.ExcludedPhrases)
+	assert.Equal(t, []string{"go", "co", "op", "error", "handling"}, query.Words())
+
+	query, err = ParseTextQuery(`"unterminated phrase`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"unterminated", "phrase"}}, query.Phrases)
+
+	for _, raw := range []string{"", "   ", "-java", `-"checked exceptions"`, `"" !?`} {
+		_, err = ParseTextQuery(raw)
+		assert.Equal(t, ErrEmptyTextQuery, err, raw)
+	}
+}
+
+func TestTextQueryScore(t *testing.T) {
+	topicMatch := model.BlogPost{Topic: "Golang tips", Content: "Use gofmt"}
+	contentMatch := model.BlogPost{Topic: "Tips", Content: "Write golang, then run gofmt"}
+
+	query, _ := ParseTextQuery("golang")
+	topicScore, ok := query.Score(topicMatch)
+	assert.True(t, ok)
+	contentScore, ok := query.Score(contentMatch)
+	assert.True(t, ok)
+	assert.Greater(t, topicScore, contentScore)
+
+	query, _ = ParseTextQuery(`tips "run gofmt"`)
+	_, ok = query.Score(topicMatch)
+	assert.False(t, ok)
+	_, ok = query.Score(contentMatch)
+	assert.True(t, ok)
+
+	query, _ = ParseTextQuery("tips -golang")
+	_, ok = query.Score(topicMatch)
+	assert.False(t, ok)
+	_, ok = query.Score(contentMatch)
+	assert.False(t, ok)
+
+	query, _ = ParseTextQuery("rust")
+	_, ok = query.Score(topicMatch)
+	assert.False(t, ok)
+}
+
+func TestTextSearchIndexMaintenance(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: name + "@abc.com"}
+		assert.Nil(t, s.Users.Insert(ctx, user), name)
+
+		post := model.BlogPost{Id: uuid.NewV4().String(), UserId: user.Id, Topic: "Golang", Content: "Channels", Version: 1}
+		assert.Nil(t, s.Posts.Insert(ctx, post), name)
+
+		search := func(raw string) []TextHit {
+			query, err := ParseTextQuery(raw)
+			assert.Nil(t, err, name)
+			hits, err := s.Posts.TextSearch(ctx, query)
+			assert.Nil(t, err, name)
+			return hits
+		}
+		assert.Len(t, search("channels"), 1, name)
+
+		post.Content = "Goroutines"
+		assert.Nil(t, s.Posts.Replace(ctx, post, AnyVersion), name)
+		assert.Empty(t, search("channels"), name)
+		hits := search("goroutines")
+		assert.Len(t, hits, 1, name)
+		assert.Equal(t, "Goroutines", hits[0].Post.Content, name)
+
+		assert.Nil(t, s.Posts.DeleteById(ctx, post.Id, AnyVersion), name)
+		assert.Empty(t, search("golang goroutines"), name)
+	}
+}
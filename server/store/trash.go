@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/gouthams/blogApp/server/utils"
+)
+
+// Purge permanently removes the posts and users trashed before the given time, along with the refresh tokens
+// expired before it.
+func (s Store) Purge(ctx context.Context, before time.Time) error {
+	logEntry := utils.Log()
+	posts, err := s.Posts.Purge(ctx, before)
+	if err != nil {
+		return err
+	}
+	users, err := s.Users.Purge(ctx, before)
+	if err != nil {
+		return err
+	}
+	if posts > 0 || users > 0 {
+		logEntry.Infof("Purged %d posts and %d users trashed before %v", posts, users, before)
+	}
+	tokens, err := s.RefreshTokens.Purge(ctx, before)
+	if err != nil {
+		return err
+	}
+	if tokens > 0 {
+		logEntry.Infof("Purged %d refresh tokens expired before %v", tokens, before)
+	}
+	return nil
+}
+
+// RunJanitor purges the documents that spent more than the retention period in the trash,
+// every interval until the context is done.
+func RunJanitor(ctx context.Context, s Store, retention time.Duration, interval time.Duration) {
+	logEntry := utils.Log()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Purge(ctx, time.Now().UTC().Add(-retention)); err != nil {
+			logEntry.Errorf("Trash purge failed %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Helper function to order the trash by most recent deletion first, then by id
+func trashedBefore(deletedAt time.Time, id string, otherDeletedAt time.Time, otherId string) bool {
+	if !deletedAt.Equal(otherDeletedAt) {
+		return deletedAt.After(otherDeletedAt)
+	}
+	return id < otherId
+}
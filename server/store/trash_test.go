@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	model "github.com/gouthams/blogApp/server/model"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrashAndRestore(t *testing.T) {
+	sqliteStore, err := OpenSqlite(":memory:")
+	assert.Nil(t, err)
+	defer sqliteStore.Close()
+
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqliteStore} {
+		ctx := context.Background()
+		user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: name + "@abc.com", Version: 1}
+		assert.Nil(t, s.Users.Insert(ctx, user), name)
+
+		post := model.BlogPost{Id: uuid.NewV4().String(), UserId: user.Id, Topic: "Golang", Content: "Channels", Version: 1}
+		assert.Nil(t, s.Posts.Insert(ctx, post), name)
+		other := model.BlogPost{Id: uuid.NewV4().String(), UserId: user.Id, Topic: "Rust", Content: "Traits", Version: 1}
+		assert.Nil(t, s.Posts.Insert(ctx, other), name)
+
+		//A trashed post is hidden from every read and write
+		assert.Nil(t, s.Posts.DeleteById(ctx, post.Id, 1), name)
+		_, err := s.Posts.GetById(ctx, post.Id)
+		assert.Equal(t, ErrNotFound, err, name)
+		posts, err := s.Posts.Search(ctx, PostFilter{UserIds: []string{user.Id}})
+		assert.Nil(t, err, name)
+		assert.Len(t, posts, 1, name)
+		query, _ := ParseTextQuery("channels")
+		hits, err := s.Posts.TextSearch(ctx, query)
+		assert.Nil(t, err, name)
+		assert.Empty(t, hits, name)
+		assert.Equal(t, ErrNotFound, s.Posts.Replace(ctx, post, AnyVersion), name)
+		assert.Equal(t, ErrNotFound, s.Posts.DeleteById(ctx, post.Id, AnyVersion), name)
+
+		trash, err := s.Posts.Trash(ctx)
+		assert.Nil(t, err, name)
+		if assert.Len(t, trash, 1, name) {
+			assert.Equal(t, post.Id, trash[0].Id, name)
+			assert.NotNil(t, trash[0].DeletedAt, name)
+			assert.Equal(t, int64(2), trash[0].Version, name)
+		}
+
+		assert.Nil(t, s.Posts.Restore(ctx, post.Id), name)
+		assert.Equal(t, ErrNotFound, s.Posts.Restore(ctx, post.Id), name)
+		restored, err := s.Posts.GetById(ctx, post.Id)
+		assert.Nil(t, err, name)
+		assert.Nil(t, restored.DeletedAt, name)
+		assert.Equal(t, int64(3), restored.Version, name)
+
+		//Restoring a user brings back the posts trashed along with them, not the ones trashed before
+		assert.Nil(t, s.Posts.DeleteById(ctx, other.Id, AnyVersion), name)
+		time.Sleep(time.Millisecond)
+		assert.Nil(t, s.Users.DeleteById(ctx, user.Id, AnyVersion, OnPostsCascade), name)
+		_, err = s.Users.GetById(ctx, user.Id)
+		assert.Equal(t, ErrNotFound, err, name)
+		_, err = s.Users.GetByEmail(ctx, user.Email)
+		assert.Equal(t, ErrNotFound, err, name)
+
+		//The email of a trashed user stays taken
+		duplicate := model.BlogUser{Id: uuid.NewV4().String(), Name: "Other", Email: user.Email, Version: 1}
+		assert.Equal(t, ErrDuplicate, s.Users.Insert(ctx, duplicate), name)
+
+		assert.Equal(t, ErrOwnerDeleted, s.Posts.Restore(ctx, other.Id), name)
+		users, err := s.Users.Trash(ctx)
+		assert.Nil(t, err, name)
+		assert.Len(t, users, 1, name)
+
+		assert.Nil(t, s.Users.Restore(ctx, user.Id), name)
+		_, err = s.Posts.GetById(ctx, post.Id)
+		assert.Nil(t, err, name)
+		_, err = s.Posts.GetById(ctx, other.Id)
+		assert.Equal(t, ErrNotFound, err, name)
+
+		//Purging only removes what was trashed before the given time, users along with all their posts
+		assert.Nil(t, s.Users.DeleteById(ctx, user.Id, AnyVersion, OnPostsCascade), name)
+		count, err := s.Posts.Purge(ctx, time.Now().UTC().Add(-time.Hour))
+		assert.Nil(t, err, name)
+		assert.Equal(t, int64(0), count, name)
+
+		count, err = s.Users.Purge(ctx, time.Now().UTC().Add(time.Second))
+		assert.Nil(t, err, name)
+		assert.Equal(t, int64(1), count, name)
+		trash, err = s.Posts.Trash(ctx)
+		assert.Nil(t, err, name)
+		assert.Empty(t, trash, name)
+		assert.Equal(t, ErrNotFound, s.Users.Restore(ctx, user.Id), name)
+		assert.Nil(t, s.Users.Insert(ctx, duplicate), name)
+	}
+}
+
+func TestRunJanitor(t *testing.T) {
+	s := NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	user := model.BlogUser{Id: uuid.NewV4().String(), Name: "David", Email: "david@abc.com", Version: 1}
+	assert.Nil(t, s.Users.Insert(ctx, user))
+	assert.Nil(t, s.Users.DeleteById(ctx, user.Id, AnyVersion, OnPostsReject))
+
+	done := make(chan struct{})
+	go func() {
+		RunJanitor(ctx, s, 0, time.Millisecond)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		users, err := s.Users.Trash(ctx)
+		return err == nil && len(users) == 0
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
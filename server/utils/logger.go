@@ -1,15 +1,93 @@
 package utils
 
 import (
+	"context"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
 	"os"
+	"sync/atomic"
 )
 
-func InitializeLogging() {
-	log.SetOutput(os.Stdout)
-	log.SetFormatter(&log.JSONFormatter{PrettyPrint: true})
-	log.SetReportCaller(true)
-	log.SetLevel(log.DebugLevel)
+// Formats of the log lines
+const (
+	LogJson   = "json"
+	LogLogfmt = "logfmt"
+	LogText   = "text"
+)
+
+// LogConfig configures the logging.
+type LogConfig struct {
+	// Level is one of trace, debug, info, warn, error, fatal or panic.
+	Level string `yaml:"level"`
+	// Format is json, logfmt or text, text being colored for humans.
+	Format string `yaml:"format"`
+	// ReportCaller adds the file and the function logging every line.
+	ReportCaller bool `yaml:"reportCaller"`
+	// File is the file the lines are written to, rotated by size, stdout when empty.
+	File string `yaml:"file"`
+	// MaxSizeMB is the size in megabytes the file is rotated at.
+	MaxSizeMB int `yaml:"maxSizeMB"`
+	// MaxBackups is how many rotated files are kept, 0 keeps them all.
+	MaxBackups int `yaml:"maxBackups"`
+	// MaxAgeDays is how many days the rotated files are kept, 0 keeps them regardless of their age.
+	MaxAgeDays int `yaml:"maxAgeDays"`
+	// DebugSampling keeps one debug or trace line in every DebugSampling, 1 keeps them all.
+	DebugSampling int `yaml:"debugSampling"`
+	// Redact names the fields whose values are masked, the email addresses are masked in every line.
+	Redact []string `yaml:"redact"`
+}
+
+func InitializeLogging(config LogConfig) error {
+	logLevel, err := log.ParseLevel(config.Level)
+	if err != nil {
+		return err
+	}
+	var formatter log.Formatter
+	switch config.Format {
+	case LogJson:
+		formatter = &log.JSONFormatter{}
+	case LogLogfmt:
+		formatter = &log.TextFormatter{DisableColors: true, FullTimestamp: true, QuoteEmptyFields: true}
+	case LogText:
+		formatter = &log.TextFormatter{ForceColors: true, FullTimestamp: true}
+	default:
+		return fmt.Errorf("unknown log format %q", config.Format)
+	}
+	if config.DebugSampling > 1 {
+		formatter = &samplingFormatter{Formatter: formatter, every: uint64(config.DebugSampling)}
+	}
+
+	var output io.Writer = os.Stdout
+	if config.File != "" {
+		output = &lumberjack.Logger{Filename: config.File, MaxSize: config.MaxSizeMB, MaxBackups: config.MaxBackups,
+			MaxAge: config.MaxAgeDays}
+	}
+
+	log.SetOutput(output)
+	log.SetFormatter(formatter)
+	log.SetReportCaller(config.ReportCaller)
+	log.SetLevel(logLevel)
+	log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	log.AddHook(NewRedactionHook(config.Redact))
+	return nil
+}
+
+// samplingFormatter writes one in every "every" debug or trace lines, the other ones are dropped
+type samplingFormatter struct {
+	log.Formatter
+	every uint64
+	count atomic.Uint64
+}
+
+func (f *samplingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if entry.Level >= log.DebugLevel && (f.count.Add(1)-1)%f.every != 0 {
+		//Nothing is written for an empty line
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
 }
 
 type Fields log.Fields
@@ -29,6 +107,17 @@ func (r *REntry) WithField(key string, value interface{}) *REntry {
 	return &newREntry
 }
 
+// WithContext adds the ids of the trace and the span of the context to the entry, when the context has any
+func (r *REntry) WithContext(ctx context.Context) *REntry {
+	entry := r.Entry.WithContext(ctx)
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		entry = entry.WithFields(log.Fields{"traceId": spanContext.TraceID().String(),
+			"spanId": spanContext.SpanID().String()})
+	}
+	newREntry := REntry{*entry}
+	return &newREntry
+}
+
 func Log() *REntry {
 	return &REntry{*log.WithFields(log.Fields{})}
 }
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to reset the logging once the test is done
+func resetLogging(t *testing.T) {
+	t.Cleanup(func() {
+		log.SetOutput(os.Stdout)
+		log.SetFormatter(&log.TextFormatter{})
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	})
+}
+
+// Helper function to initialize the logging with the config and capture the lines
+func captureLogs(t *testing.T, config LogConfig) *bytes.Buffer {
+	resetLogging(t)
+	assert.Nil(t, InitializeLogging(config))
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	return &out
+}
+
+func TestLogFormats(t *testing.T) {
+	out := captureLogs(t, LogConfig{Level: "info", Format: LogJson, DebugSampling: 1})
+	Log().WithField("id", "42").Info("created")
+	assert.Regexp(t, `^\{.*"id":"42".*"msg":"created".*\}\n$`, out.String())
+
+	out = captureLogs(t, LogConfig{Level: "info", Format: LogLogfmt, DebugSampling: 1})
+	Log().WithField("id", "42").Info("created")
+	assert.Regexp(t, `^time=".*" level=info msg=created id=42\n$`, out.String())
+
+	//The lines below the level are dropped
+	out = captureLogs(t, LogConfig{Level: "warn", Format: LogLogfmt, DebugSampling: 1})
+	Log().Info("created")
+	assert.Empty(t, out.String())
+
+	assert.Error(t, InitializeLogging(LogConfig{Level: "info", Format: "xml"}))
+	assert.Error(t, InitializeLogging(LogConfig{Level: "loud", Format: LogJson}))
+}
+
+func TestLogDebugSampling(t *testing.T) {
+	out := captureLogs(t, LogConfig{Level: "debug", Format: LogLogfmt, DebugSampling: 3})
+	for i := 0; i < 9; i++ {
+		Log().Debug("sampled")
+		Log().Info("kept")
+	}
+	assert.Equal(t, 3, strings.Count(out.String(), "msg=sampled"))
+	assert.Equal(t, 9, strings.Count(out.String(), "msg=kept"))
+}
+
+func TestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blog.log")
+	resetLogging(t)
+	assert.Nil(t, InitializeLogging(LogConfig{Level: "info", Format: LogLogfmt, File: path, MaxSizeMB: 1,
+		DebugSampling: 1}))
+	Log().Info("to the file")
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "msg=\"to the file\"")
+}
+
+func TestLogRedaction(t *testing.T) {
+	out := captureLogs(t, LogConfig{Level: "info", Format: LogJson, DebugSampling: 1,
+		Redact: []string{"email", "Password"}})
+	entry := Log().WithFields(Fields{"email": "jim.do@gmail.com", "password": "hunter2", "name": "Jim",
+		"error": errors.New("email jim.do@gmail.com is taken")})
+	entry.Infof("Created %v", map[string]string{"email": "jim.do@gmail.com"})
+
+	line := out.String()
+	assert.NotContains(t, line, "jim.do@gmail.com")
+	assert.NotContains(t, line, "hunter2")
+	assert.Contains(t, line, `"email":"j***@gmail.com"`)
+	assert.Contains(t, line, `"password":"REDACTED"`)
+	assert.Contains(t, line, `"name":"Jim"`)
+	assert.Contains(t, line, `"error":"email j***@gmail.com is taken"`)
+	assert.Contains(t, line, `Created map[email:j***@gmail.com]`)
+
+	//The fields of the entry are left alone
+	assert.Equal(t, "jim.do@gmail.com", entry.Data["email"])
+}
+
+func TestMaskEmails(t *testing.T) {
+	assert.Equal(t, "no email", MaskEmails("no email"))
+	assert.Equal(t, "a***@b.io and c***@d.example.com", MaskEmails("a@b.io and carol+tag@d.example.com"))
+}
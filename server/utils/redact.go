@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// redactedValue replaces the values of the redacted fields that are not email addresses
+const redactedValue = "REDACTED"
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// RedactionHook masks the values of the named fields of every log line, and the email addresses in its message and
+// its other string fields, so that no personal data is logged.
+type RedactionHook struct {
+	fields map[string]bool
+}
+
+// NewRedactionHook returns the hook masking the fields with the given names, regardless of their case.
+func NewRedactionHook(fields []string) *RedactionHook {
+	hook := &RedactionHook{fields: make(map[string]bool, len(fields))}
+	for _, field := range fields {
+		hook.fields[strings.ToLower(field)] = true
+	}
+	return hook
+}
+
+func (h *RedactionHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *RedactionHook) Fire(entry *log.Entry) error {
+	entry.Message = MaskEmails(entry.Message)
+	//The fields are shared with the entry the line was logged from, they are copied rather than masked in place
+	data := make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch {
+		case h.fields[strings.ToLower(key)]:
+			data[key] = redact(value)
+		case isString(value):
+			data[key] = MaskEmails(fmt.Sprint(value))
+		default:
+			data[key] = value
+		}
+	}
+	entry.Data = data
+	return nil
+}
+
+// MaskEmails masks the email addresses of the text, keeping the first letter and the domain: j***@example.com.
+func MaskEmails(text string) string {
+	return emailPattern.ReplaceAllStringFunc(text, func(email string) string {
+		at := strings.LastIndexByte(email, '@')
+		return email[:1] + "***" + email[at:]
+	})
+}
+
+// Helper function to mask the value of a redacted field, the email addresses keep their domain
+func redact(value interface{}) interface{} {
+	if text, ok := value.(string); ok && emailPattern.MatchString(text) {
+		return MaskEmails(text)
+	}
+	return redactedValue
+}
+
+// Helper function to tell the values logged as text, which may hold email addresses
+func isString(value interface{}) bool {
+	switch value.(type) {
+	case string, fmt.Stringer, error:
+		return true
+	}
+	return false
+}
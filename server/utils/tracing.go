@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the server
+const tracerName = "github.com/gouthams/blogApp/server"
+
+// serviceName is the service.name of the exported spans
+const serviceName = "blogApp"
+
+// Exporters of the spans
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingOtlp   = "otlp"
+)
+
+// TracingConfig configures the export of the spans.
+type TracingConfig struct {
+	// Exporter is where the spans are exported: none, stdout or otlp.
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the host:port of the OTLP/HTTP collector of the otlp exporter.
+	Endpoint string `yaml:"endpoint"`
+	// Insecure exports the spans to the collector over http rather than https.
+	Insecure bool `yaml:"insecure"`
+	// SampleRatio is the ratio of the traces started by the server that are sampled, the traces started by a caller
+	// follow the sampling decision of the caller.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// InitializeTracing propagates the W3C trace context of the requests and exports the spans as configured.
+// The returned function flushes the spans not exported yet and stops the exporter.
+func InitializeTracing(config TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{},
+		propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case TracingNone:
+		return func(context.Context) error { return nil }, nil
+	case TracingStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracingOtlp:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create the %s tracing exporter: %w", config.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the spans of the server.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
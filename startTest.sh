@@ -1,3 +1,2 @@
 #bin/sh
-mongod --bind_ip 0.0.0.0 &
 go test -coverprofile=cover.out ./...
\ No newline at end of file